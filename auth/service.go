@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"errors"
 )
 
@@ -49,6 +50,15 @@ func (s *Service) SignAndBase64Encode(sessionID string) (string, error) {
 	return string(encode(sessionValBytes)[:]), nil
 }
 
+// SignAndBase64EncodeContext is like SignAndBase64Encode, but returns ctx's error if ctx is already done
+func (s *Service) SignAndBase64EncodeContext(ctx context.Context, sessionID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return s.SignAndBase64Encode(sessionID)
+}
+
 // VerifyAndDecode takes in a signed session string and returns a sessionID, only if the signed string passes
 // auth verification.
 func (s *Service) VerifyAndDecode(signed string) (string, error) {
@@ -72,3 +82,12 @@ func (s *Service) VerifyAndDecode(signed string) (string, error) {
 
 	return string(sessionIDBytes[:]), nil
 }
+
+// VerifyAndDecodeContext is like VerifyAndDecode, but returns ctx's error if ctx is already done
+func (s *Service) VerifyAndDecodeContext(ctx context.Context, signed string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return s.VerifyAndDecode(signed)
+}
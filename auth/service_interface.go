@@ -1,7 +1,17 @@
 package auth
 
+import "context"
+
 // ServiceInterface defines the methods that are performend by the auth service
 type ServiceInterface interface {
 	SignAndBase64Encode(sessionID string) (string, error)
 	VerifyAndDecode(signed string) (string, error)
 }
+
+// ContextServiceInterface is optionally implemented by auth services whose operations can be cancelled, e.g. ones \
+// that fetch their keys from a remote key service. When implemented, the session service passes the request's \
+// context to the auth service.
+type ContextServiceInterface interface {
+	SignAndBase64EncodeContext(ctx context.Context, sessionID string) (string, error)
+	VerifyAndDecodeContext(ctx context.Context, signed string) (string, error)
+}
@@ -3,10 +3,17 @@
 package auth
 
 import (
+	"context"
 	"reflect"
 	"testing"
 )
 
+// note: this will fail to compile if the auth service does not implement the auth interfaces
+var (
+	_ ServiceInterface        = (*Service)(nil)
+	_ ContextServiceInterface = (*Service)(nil)
+)
+
 var (
 	validKey     = []byte("DOZDgBdMhGLImnk0BGYgOUI+h1n7U+OdxcZPctMbeFCsuAom2aFU4JPV4Qj11hbcb5yaM4WDuNP/3B7b+BnFhw==")
 	validService = Service{
@@ -101,3 +108,31 @@ func TestVerifyAndDecode(t *testing.T) {
 		}
 	}
 }
+
+// TestContext tests that the context variants sign and verify like their plain counterparts, unless ctx is done
+func TestContext(t *testing.T) {
+	sessionID := "5f4cd331-c869-4871-bb41-76b726df9937"
+	signed, _ := validService.SignAndBase64Encode(sessionID)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var tests = []struct {
+		ctx            context.Context
+		expectedSigned string
+		expectedID     string
+		expectedErr    error
+	}{
+		{context.Background(), signed, sessionID, nil},
+		{cancelled, "", "", context.Canceled},
+	}
+
+	for idx, tt := range tests {
+		a, signErr := validService.SignAndBase64EncodeContext(tt.ctx, sessionID)
+		b, verifyErr := validService.VerifyAndDecodeContext(tt.ctx, signed)
+
+		if a != tt.expectedSigned || b != tt.expectedID || signErr != tt.expectedErr || verifyErr != tt.expectedErr {
+			t.Errorf("test #%d failed; expected: %s, %s, err: %v, received: %s, %s, errs: %v, %v", idx+1, tt.expectedSigned, tt.expectedID, tt.expectedErr, a, b, signErr, verifyErr)
+		}
+	}
+}
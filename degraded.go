@@ -0,0 +1,128 @@
+package sessions
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/adam-hanna/sessions/user"
+)
+
+const (
+	// DefaultDegradedCacheDuration sets the default duration a validated session may be served while the store \
+	// is failing, under DegradedPolicyFailOpen
+	DefaultDegradedCacheDuration = 1 * time.Minute
+	// DefaultDegradedCacheMaxEntries sets the default maximum number of validated sessions kept under \
+	// DegradedPolicyFailOpen
+	DefaultDegradedCacheMaxEntries = 10000
+)
+
+// DegradedPolicy defines how GetUserSession behaves when the store fails to fetch a session
+type DegradedPolicy int
+
+const (
+	// DegradedPolicyPropagate returns the store's error. This is the default
+	DegradedPolicyPropagate DegradedPolicy = iota
+	// DegradedPolicyFailClosed treats the request as if it had no valid session, i.e. a nil session and a nil \
+	// error are returned, which should result in a 401
+	DegradedPolicyFailClosed
+	// DegradedPolicyFailOpen serves sessions that were validated within the last DegradedCacheDuration from a \
+	// local cache. Other requests are treated as if they had no valid session
+	DegradedPolicyFailOpen
+)
+
+// recentSessions is a bounded cache of recently validated sessions, used under DegradedPolicyFailOpen
+type recentSessions struct {
+	duration   time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// recentSession is a validated session
+type recentSession struct {
+	userSession user.Session
+	validUntil  time.Time
+}
+
+// newRecentSessions returns an empty cache of recently validated sessions
+func newRecentSessions(duration time.Duration, maxEntries int) *recentSessions {
+	return &recentSessions{
+		duration:   duration,
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// add caches a copy of a validated session, evicting the least recently validated session if the cache is full
+func (r *recentSessions) add(userSession *user.Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if element, ok := r.entries[userSession.ID]; ok {
+		r.removeElement(element)
+	}
+
+	validUntil := time.Now().Add(r.duration)
+	if userSession.ExpiresAt.Before(validUntil) {
+		validUntil = userSession.ExpiresAt
+	}
+	r.entries[userSession.ID] = r.lru.PushFront(&recentSession{
+		userSession: *userSession.Copy(),
+		validUntil:  validUntil,
+	})
+
+	for r.lru.Len() > r.maxEntries {
+		r.removeElement(r.lru.Back())
+	}
+}
+
+// get returns a copy of a recently validated session, or a nil pointer if there is none
+func (r *recentSessions) get(sessionID string) *user.Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	element, ok := r.entries[sessionID]
+	if !ok {
+		return nil
+	}
+
+	e := element.Value.(*recentSession)
+	if !e.validUntil.After(time.Now()) {
+		r.removeElement(element)
+		return nil
+	}
+
+	return e.userSession.Copy()
+}
+
+// remove removes a session from the cache
+func (r *recentSessions) remove(sessionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if element, ok := r.entries[sessionID]; ok {
+		r.removeElement(element)
+	}
+}
+
+// removeUser removes all sessions of a user from the cache
+func (r *recentSessions) removeUser(userID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, element := range r.entries {
+		if element.Value.(*recentSession).userSession.UserID == userID {
+			r.removeElement(element)
+		}
+	}
+}
+
+// removeElement removes an element from the cache. The caller must hold the lock
+func (r *recentSessions) removeElement(element *list.Element) {
+	r.lru.Remove(element)
+	delete(r.entries, element.Value.(*recentSession).userSession.ID)
+}
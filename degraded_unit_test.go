@@ -0,0 +1,56 @@
+// +build unit
+
+package sessions
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/user"
+)
+
+// TestRecentSessions tests the cache of recently validated sessions
+func TestRecentSessions(t *testing.T) {
+	r := newRecentSessions(20*time.Millisecond, 2)
+
+	for idx := 0; idx < 3; idx++ {
+		r.add(&user.Session{ID: "sessionID" + strconv.Itoa(idx), UserID: "userID" + strconv.Itoa(idx%2), ExpiresAt: time.Now().Add(1 * time.Hour)})
+	}
+	r.add(&user.Session{ID: "expiringSessionID", ExpiresAt: time.Now().Add(5 * time.Millisecond)})
+
+	var tests = []struct {
+		input          string
+		expectedExists bool
+	}{
+		// note: the oldest sessions were evicted when the cache filled up
+		{"sessionID0", false},
+		{"sessionID1", false},
+		{"sessionID2", true},
+		{"expiringSessionID", true},
+	}
+
+	for idx, tt := range tests {
+		exists := r.get(tt.input) != nil
+
+		if exists != tt.expectedExists {
+			t.Errorf("test #%d failed; input: %s, expected exists: %t, received: %t", idx+1, tt.input, tt.expectedExists, exists)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if a := r.get("expiringSessionID"); a != nil {
+		t.Errorf("test failed; expected an expired session not to be served, received: %v", a)
+	}
+
+	r.removeUser("userID0")
+	if a := r.get("sessionID2"); a != nil {
+		t.Errorf("test failed; expected the user's sessions to be removed, received: %v", a)
+	}
+
+	r.add(&user.Session{ID: "sessionID", ExpiresAt: time.Now().Add(1 * time.Hour)})
+	time.Sleep(30 * time.Millisecond)
+	if a := r.get("sessionID"); a != nil {
+		t.Errorf("test failed; expected a session validated too long ago not to be served, received: %v", a)
+	}
+}
@@ -3,6 +3,9 @@ module github.com/adam-hanna/sessions
 go 1.14
 
 require (
-	github.com/garyburd/redigo v0.0.0-20170216214944-0d253a66e6e1
+	github.com/gomodule/redigo v1.8.6
 	github.com/google/uuid v1.1.1
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/mna/redisc v1.3.2
+	go.etcd.io/bbolt v1.3.6
 )
@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Session events counted by the session service
+const (
+	// EventIssued is counted when a session is issued
+	EventIssued = "issued"
+	// EventFetched is counted when a valid session is read from a request
+	EventFetched = "fetched"
+	// EventExtended is counted when a session is extended
+	EventExtended = "extended"
+	// EventCleared is counted when a session is cleared
+	EventCleared = "cleared"
+	// EventMissing is counted when a request carries no session, or its session has expired or doesn't exist
+	EventMissing = "missing"
+	// EventTampered is counted when a request carries a session whose signature can't be verified
+	EventTampered = "tampered"
+	// EventErrored is counted when a session operation fails
+	EventErrored = "errored"
+	// EventDegraded is counted when the store fails to fetch a session and the service's degraded policy is \
+	// applied instead of returning the error
+	EventDegraded = "degraded"
+	// EventEvicted is counted when a session is evicted to make room for a new session of the same user, see \
+	// sessions.Options.MaxSessionsPerUser
+	EventEvicted = "evicted"
+	// EventLimited is counted when a session isn't issued because its user holds the maximum number of sessions
+	EventLimited = "limited"
+	// EventRegenerated is counted when a session is moved to a new id
+	EventRegenerated = "regenerated"
+)
+
+// Store operations observed by the instrumented store
+const (
+	// OperationSave is the store's SaveUserSession operation
+	OperationSave = "save"
+	// OperationSaveIfVersion is the store's SaveUserSessionIfVersion operation
+	OperationSaveIfVersion = "save_if_version"
+	// OperationSetField is the store's SetUserSessionField operation
+	OperationSetField = "set_field"
+	// OperationDeleteField is the store's DeleteUserSessionField operation
+	OperationDeleteField = "delete_field"
+	// OperationSaveWithLimit is the store's SaveUserSessionWithLimit operation
+	OperationSaveWithLimit = "save_with_limit"
+	// OperationTouch is the store's TouchUserSession operation
+	OperationTouch = "touch"
+	// OperationRegenerate is the store's RegenerateUserSession operation
+	OperationRegenerate = "regenerate"
+	// OperationLock is the store's LockUserSession operation
+	OperationLock = "lock"
+	// OperationUnlock is the store's UnlockUserSession operation
+	OperationUnlock = "unlock"
+	// OperationPing is the store's Ping operation
+	OperationPing = "ping"
+	// OperationDelete is the store's DeleteUserSession operation
+	OperationDelete = "delete"
+	// OperationFetch is the store's FetchValidUserSession operation
+	OperationFetch = "fetch"
+	// OperationList is the store's ListUserSessions operation
+	OperationList = "list"
+	// OperationDeleteAll is the store's DeleteAllUserSessions operation
+	OperationDeleteAll = "delete_all"
+	// OperationScan is the store's Scan operation
+	OperationScan = "scan"
+)
+
+const (
+	// DefaultNamespace is the default prefix of the metric names
+	DefaultNamespace = "sessions"
+)
+
+// DefaultBuckets are the default upper bounds, in seconds, of the store latency histogram buckets
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// Service keeps session metrics in memory and serves them in the prometheus text exposition format
+type Service struct {
+	options Options
+
+	mu         sync.Mutex
+	events     map[string]uint64
+	operations map[string]*histogram
+}
+
+// Options defines the behavior of the metrics service
+type Options struct {
+	// Namespace prefixes the metric names, e.g. sessions_events_total
+	Namespace string
+	// Buckets are the upper bounds, in seconds, of the store latency histogram buckets
+	Buckets []float64
+}
+
+// histogram records the durations and errors of a store operation
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+	errors uint64
+}
+
+// New returns a new metrics service
+func New(options Options) *Service {
+	setDefaultOptions(&options)
+
+	s := &Service{
+		options:    options,
+		events:     make(map[string]uint64),
+		operations: make(map[string]*histogram),
+	}
+
+	// note: export every known series from the start, so rates can be computed from the first scrape
+	for _, event := range []string{EventIssued, EventFetched, EventExtended, EventCleared, EventMissing, EventTampered, EventErrored, EventDegraded, EventEvicted, EventLimited, EventRegenerated} {
+		s.events[event] = 0
+	}
+	for _, operation := range []string{OperationSave, OperationSaveIfVersion, OperationSetField, OperationDeleteField, OperationSaveWithLimit, OperationTouch, OperationRegenerate, OperationLock, OperationUnlock, OperationPing, OperationDelete, OperationFetch, OperationList, OperationDeleteAll, OperationScan} {
+		s.operations[operation] = s.newHistogram()
+	}
+
+	return s
+}
+
+// CountEvent counts a session event
+func (s *Service) CountEvent(event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events[event]++
+}
+
+// ObserveStoreOperation records the duration of a store operation, and counts it as an error if err isn't nil
+func (s *Service) ObserveStoreOperation(operation string, duration time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.operations[operation]
+	if !ok {
+		h = s.newHistogram()
+		s.operations[operation] = h
+	}
+
+	seconds := duration.Seconds()
+	for idx, bucket := range s.options.Buckets {
+		if seconds <= bucket {
+			h.counts[idx]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+	if err != nil {
+		h.errors++
+	}
+}
+
+// ServeHTTP writes the metrics in the prometheus text exposition format
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	w.Write(s.exposition())
+}
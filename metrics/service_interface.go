@@ -0,0 +1,14 @@
+package metrics
+
+import (
+	"time"
+)
+
+// ServiceInterface defines the methods used to record the behavior of the session layer. Implement it to send \
+// the numbers to your metrics system of choice
+type ServiceInterface interface {
+	// CountEvent counts a session event, e.g. EventIssued
+	CountEvent(event string)
+	// ObserveStoreOperation records the duration of a store operation, e.g. OperationFetch, and whether it failed
+	ObserveStoreOperation(operation string, duration time.Duration, err error)
+}
@@ -0,0 +1,66 @@
+// +build unit
+
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// note: this will fail to compile if the service does not implement the interface
+var _ ServiceInterface = (*Service)(nil)
+
+// TestServeHTTP tests that the metrics are served in the prometheus text exposition format
+func TestServeHTTP(t *testing.T) {
+	s := New(Options{Buckets: []float64{0.01, 0.001}})
+
+	s.CountEvent(EventTampered)
+	s.CountEvent(EventTampered)
+	s.ObserveStoreOperation(OperationFetch, 500*time.Microsecond, nil)
+	s.ObserveStoreOperation(OperationFetch, 5*time.Millisecond, errors.New("test err"))
+	s.ObserveStoreOperation(OperationFetch, 50*time.Millisecond, nil)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("test failed; expected prometheus content type, received: %s", contentType)
+	}
+
+	var tests = []struct {
+		expectedLine string
+	}{
+		{"# TYPE sessions_events_total counter"},
+		{`sessions_events_total{event="tampered"} 2`},
+		{`sessions_events_total{event="issued"} 0`},
+		{"# TYPE sessions_store_operation_duration_seconds histogram"},
+		{`sessions_store_operation_duration_seconds_bucket{operation="fetch",le="0.001"} 1`},
+		{`sessions_store_operation_duration_seconds_bucket{operation="fetch",le="0.01"} 2`},
+		{`sessions_store_operation_duration_seconds_bucket{operation="fetch",le="+Inf"} 3`},
+		{`sessions_store_operation_duration_seconds_sum{operation="fetch"} 0.0555`},
+		{`sessions_store_operation_duration_seconds_count{operation="fetch"} 3`},
+		{`sessions_store_operation_duration_seconds_count{operation="save"} 0`},
+		{`sessions_store_operation_errors_total{operation="fetch"} 1`},
+	}
+
+	for idx, tt := range tests {
+		if !strings.Contains(body, tt.expectedLine+"\n") {
+			t.Errorf("test #%d failed; expected line: %s, received body: %s", idx+1, tt.expectedLine, body)
+		}
+	}
+}
+
+// TestNamespace tests that metric names are prefixed with the namespace
+func TestNamespace(t *testing.T) {
+	s := New(Options{Namespace: "app_sessions"})
+	s.ObserveStoreOperation("custom", time.Millisecond, nil)
+
+	body := string(s.exposition())
+	if strings.Contains(body, "\nsessions_") || !strings.Contains(body, `app_sessions_store_operation_duration_seconds_count{operation="custom"} 1`) {
+		t.Errorf("test failed; expected metrics to be prefixed with the namespace, received body: %s", body)
+	}
+}
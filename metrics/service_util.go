@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// contentType is the content type of the prometheus text exposition format
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// labelValueReplacer escapes label values as required by the prometheus text exposition format
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// setDefaultOptions sets default values for nil fields
+func setDefaultOptions(options *Options) {
+	emptyOptions := Options{}
+	if options.Namespace == emptyOptions.Namespace {
+		options.Namespace = DefaultNamespace
+	}
+	if len(options.Buckets) == 0 {
+		options.Buckets = DefaultBuckets
+	}
+
+	sortedBuckets := make([]float64, len(options.Buckets))
+	copy(sortedBuckets, options.Buckets)
+	sort.Float64s(sortedBuckets)
+	options.Buckets = sortedBuckets
+
+	return
+}
+
+// newHistogram returns an empty histogram with the configured buckets
+func (s *Service) newHistogram() *histogram {
+	return &histogram{
+		counts: make([]uint64, len(s.options.Buckets)),
+	}
+}
+
+// exposition returns the metrics in the prometheus text exposition format
+func (s *Service) exposition() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b bytes.Buffer
+
+	name := s.options.Namespace + "_events_total"
+	fmt.Fprintf(&b, "# HELP %s Number of session events, by event.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+	for _, event := range sortedKeys(s.events) {
+		fmt.Fprintf(&b, "%s{event=%s} %d\n", name, quoteLabelValue(event), s.events[event])
+	}
+
+	operations := make([]string, 0, len(s.operations))
+	for operation := range s.operations {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	name = s.options.Namespace + "_store_operation_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Duration of store operations, by operation.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+	for _, operation := range operations {
+		h := s.operations[operation]
+		label := "operation=" + quoteLabelValue(operation)
+		for idx, bucket := range s.options.Buckets {
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"%s\"} %d\n", name, label, strconv.FormatFloat(bucket, 'g', -1, 64), h.counts[idx])
+		}
+		fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, label, h.count)
+		fmt.Fprintf(&b, "%s_sum{%s} %s\n", name, label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", name, label, h.count)
+	}
+
+	name = s.options.Namespace + "_store_operation_errors_total"
+	fmt.Fprintf(&b, "# HELP %s Number of failed store operations, by operation.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+	for _, operation := range operations {
+		fmt.Fprintf(&b, "%s{operation=%s} %d\n", name, quoteLabelValue(operation), s.operations[operation].errors)
+	}
+
+	return b.Bytes()
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// quoteLabelValue returns a label value, escaped and quoted
+func quoteLabelValue(value string) string {
+	return `"` + labelValueReplacer.Replace(value) + `"`
+}
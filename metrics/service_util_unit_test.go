@@ -0,0 +1,47 @@
+// +build unit
+
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSetDefaultOptions tests the setDefaultOptions function
+func TestSetDefaultOptions(t *testing.T) {
+	var tests = []struct {
+		input    Options
+		expected Options
+	}{
+		{Options{}, Options{Namespace: DefaultNamespace, Buckets: DefaultBuckets}},
+		{Options{Namespace: "test", Buckets: []float64{1, 0.1}}, Options{Namespace: "test", Buckets: []float64{0.1, 1}}},
+	}
+
+	for idx, tt := range tests {
+		setDefaultOptions(&tt.input)
+		assert := reflect.DeepEqual(tt.expected, tt.input)
+
+		if !assert {
+			t.Errorf("test #%d failed; assert: %t, expected: %v, received: %v\n", idx+1, assert, tt.expected, tt.input)
+		}
+	}
+}
+
+// TestQuoteLabelValue tests the quoteLabelValue function
+func TestQuoteLabelValue(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"fetch", `"fetch"`},
+		{`a"b\c` + "\n", `"a\"b\\c\n"`},
+	}
+
+	for idx, tt := range tests {
+		a := quoteLabelValue(tt.input)
+
+		if a != tt.expected {
+			t.Errorf("test #%d failed; input: %s, expected: %s, received: %s", idx+1, tt.input, tt.expected, a)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package sessions
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// DefaultSlidingExpirationThreshold sets the default fraction of a session's idle timeout that must have elapsed \
+// before Middleware extends it
+const DefaultSlidingExpirationThreshold = 0.5
+
+// userSessionContextKey is the key of the session Middleware puts in a request's context
+type userSessionContextKey struct{}
+
+// Middleware returns an http.Handler that loads the session of each request, extends it if more than \
+// Options.SlidingExpirationThreshold of its idle timeout has elapsed, and calls next with the session in the \
+// request's context. Read it with UserSessionFromContext; it is nil if the request has no valid session, so next \
+// decides whether to respond with a 401.
+//
+// The refreshed cookie is set before next is called, so it is written with next's first WriteHeader. Sessions \
+// are only extended once the threshold has passed, so most requests don't write to the store. If the session was \
+// deleted while it was extended, e.g. by a concurrent logout, or has reached its absolute deadline, next is called \
+// with a nil session. If the session can't be loaded or extended for any other reason, the middleware responds \
+// with 500 Internal Server Error, without revealing the error.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userSession, err := s.GetUserSession(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if userSession != nil && s.shouldSlide(userSession, time.Now()) {
+			err := s.ExtendUserSession(userSession, r, w)
+			switch {
+			case err == store.ErrVersionConflict || err == store.ErrSessionNotFound || err == ErrAbsoluteTimeout:
+				// note: the session no longer exists, or its new expiry was never saved
+				userSession = nil
+			case err != nil:
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userSessionContextKey{}, userSession)))
+	})
+}
+
+// UserSessionFromContext returns the session Middleware put in a request's context, or a nil pointer if the request \
+// has no valid session or didn't pass through Middleware
+func UserSessionFromContext(ctx context.Context) *user.Session {
+	userSession, _ := ctx.Value(userSessionContextKey{}).(*user.Session)
+	return userSession
+}
+
+// shouldSlide returns whether Middleware should extend a session at now: more than the threshold of its idle \
+// timeout has elapsed, and extending it would push its expiry forward, i.e. it hasn't reached its absolute deadline
+func (s *Service) shouldSlide(userSession *user.Session, now time.Time) bool {
+	idleTimeout := s.idleTimeout(userSession)
+	elapsed := idleTimeout - userSession.ExpiresAt.Sub(now)
+	if float64(elapsed) <= s.options.SlidingExpirationThreshold*float64(idleTimeout) {
+		return false
+	}
+
+	return s.expiresAt(userSession, now).After(userSession.ExpiresAt)
+}
@@ -0,0 +1,98 @@
+// +build unit
+
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/store/instrument"
+	"github.com/adam-hanna/sessions/store/memory"
+	"github.com/adam-hanna/sessions/transport"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// TestMiddleware tests that the middleware passes the session to the wrapped handler in the request's context, and \
+// only extends sessions that are past the sliding expiration threshold
+func TestMiddleware(t *testing.T) {
+	now := time.Now()
+	options := Options{IdleTimeoutDuration: 1 * time.Hour, AbsoluteTimeoutDuration: 4 * time.Hour}
+
+	var tests = []struct {
+		store             store.ServiceInterface
+		cookie            bool
+		expiresAt         time.Time
+		createdAt         time.Time
+		expectedStatus    int
+		expectedSession   bool
+		expectedExtended  bool
+		expectedExpiresAt time.Time
+	}{
+		{memory.New(memory.Options{}), false, time.Time{}, time.Time{}, http.StatusOK, false, false, time.Time{}},
+		{memory.New(memory.Options{}), true, now.Add(50 * time.Minute), now.Add(-10 * time.Minute), http.StatusOK, true, false, now.Add(50 * time.Minute)},
+		{memory.New(memory.Options{}), true, now.Add(20 * time.Minute), now.Add(-40 * time.Minute), http.StatusOK, true, true, now.Add(1 * time.Hour)},
+		// note: a session that is capped by its absolute deadline can't be extended any further
+		{memory.New(memory.Options{}), true, now.Add(20 * time.Minute), now.Add(-220 * time.Minute), http.StatusOK, true, false, now.Add(20 * time.Minute)},
+		// note: decorating stores don't stop sessions from sliding if the store they wrap doesn't version sessions
+		{instrument.New(&UnversionedStoreType{memory.New(memory.Options{})}, &MockedMetricsType{}), true, now.Add(20 * time.Minute), now.Add(-40 * time.Minute), http.StatusOK, true, true, now.Add(1 * time.Hour)},
+		{&erredStore, true, time.Time{}, time.Time{}, http.StatusInternalServerError, false, false, time.Time{}},
+		// note: a session deleted while it is extended, e.g. by a concurrent logout, must not be passed on
+		{&ConcurrentStoreType{memory.New(memory.Options{}), func(memoryStore *memory.Service) error {
+			return memoryStore.DeleteUserSession("test")
+		}}, true, now.Add(20 * time.Minute), now.Add(-40 * time.Minute), http.StatusOK, false, false, time.Time{}},
+		{&ConcurrentStoreType{memory.New(memory.Options{}), func(memoryStore *memory.Service) error {
+			return store.ErrSessionNotFound
+		}}, true, now.Add(20 * time.Minute), now.Add(-40 * time.Minute), http.StatusOK, false, false, time.Time{}},
+		// note: a concurrent request may have moved the session's creation time past its absolute deadline
+		{&ConcurrentStoreType{memory.New(memory.Options{}), func(memoryStore *memory.Service) error {
+			return memoryStore.SaveUserSession(&user.Session{ID: "test", UserID: inputUserID, JSON: inputJSON, ExpiresAt: now.Add(20 * time.Minute), CreatedAt: now.Add(-5 * time.Hour)})
+		}}, true, now.Add(20 * time.Minute), now.Add(-40 * time.Minute), http.StatusOK, false, false, time.Time{}},
+		{&ConcurrentStoreType{memory.New(memory.Options{}), func(memoryStore *memory.Service) error {
+			return MockedTestErr
+		}}, true, now.Add(20 * time.Minute), now.Add(-40 * time.Minute), http.StatusInternalServerError, false, false, time.Time{}},
+	}
+
+	for idx, tt := range tests {
+		// note: the mocked auth decodes every session id to "test"
+		if tt.expiresAt.After(now) {
+			tt.store.SaveUserSession(&user.Session{ID: "test", UserID: inputUserID, JSON: inputJSON, ExpiresAt: tt.expiresAt, CreatedAt: tt.createdAt})
+		}
+		s := New(tt.store, &mockedAuth, transport.New(transport.Options{}), options)
+
+		var called bool
+		var handled *user.Session
+		handler := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			handled = UserSessionFromContext(r.Context())
+		}))
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.cookie {
+			r.AddCookie(&http.Cookie{Name: transport.DefaultCookieName, Value: "signedSessionID"})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		extended := len(w.Result().Cookies()) > 0
+		assertSession := (handled != nil) == tt.expectedSession
+		if handled != nil {
+			offset := handled.ExpiresAt.Sub(tt.expectedExpiresAt)
+			assertSession = offset > -1*time.Second && offset < 1*time.Second
+		}
+
+		if w.Code != tt.expectedStatus || called != (tt.expectedStatus == http.StatusOK) || !assertSession || extended != tt.expectedExtended {
+			t.Errorf("test #%d failed; expected status: %d, expected session: %t, expected extended: %t, received status: %d, received session: %v, received extended: %t", idx+1, tt.expectedStatus, tt.expectedSession, tt.expectedExtended, w.Code, handled, extended)
+		}
+
+		if closer, ok := tt.store.(interface{ Close() error }); ok {
+			closer.Close()
+		}
+	}
+
+	if a := UserSessionFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()); a != nil {
+		t.Errorf("test failed; expected no session in a context the middleware didn't fill, received: %v", a)
+	}
+}
@@ -1,127 +1,633 @@
 package sessions
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/adam-hanna/sessions/auth"
+	"github.com/adam-hanna/sessions/metrics"
 	"github.com/adam-hanna/sessions/store"
 	"github.com/adam-hanna/sessions/transport"
 	"github.com/adam-hanna/sessions/user"
+	"github.com/google/uuid"
 )
 
 const (
 	// DefaultExpirationDuration sets the default session expiration duration
 	DefaultExpirationDuration = 3 * 24 * time.Hour // 3 days
+	// DefaultMaxUpdateRetries sets the default number of times an update is retried after a version conflict
+	DefaultMaxUpdateRetries = 3
+	// DefaultLockRetryDuration sets the default duration to wait before trying to lock a locked session, again
+	DefaultLockRetryDuration = 50 * time.Millisecond
 )
 
+// ErrAbsoluteTimeout is thrown when extending a session that has reached its absolute lifetime, see \
+// Options.AbsoluteTimeoutDuration
+var ErrAbsoluteTimeout = errors.New("session has reached its absolute lifetime")
+
 // Service provides session service for http servers
 type Service struct {
 	store     store.ServiceInterface
 	auth      auth.ServiceInterface
 	transport transport.ServiceInterface
 	options   Options
+
+	// recent caches validated sessions under DegradedPolicyFailOpen
+	recent *recentSessions
 }
 
 // Options defines the behavior of the session service
 type Options struct {
 	ExpirationDuration time.Duration
+	// IdleTimeoutDuration, if set, is how long a session stays valid unless it is extended, instead of \
+	// ExpirationDuration: IssueUserSession and ExtendUserSession set its ExpiresAt this far ahead
+	IdleTimeoutDuration time.Duration
+	// AbsoluteTimeoutDuration, if set, is the maximum lifetime of a session since it was issued, however often it \
+	// is extended. GetUserSession rejects sessions that have reached it, and ExtendUserSession never extends a \
+	// session past it
+	AbsoluteTimeoutDuration time.Duration
+	// SlidingExpirationThreshold is the fraction of a session's idle timeout that must have elapsed before \
+	// Middleware extends it, e.g. 0.5 extends a session with a 1 hour idle timeout once it has been idle for more \
+	// than 30 minutes. Defaults to DefaultSlidingExpirationThreshold
+	SlidingExpirationThreshold float64
+	// Metrics, if set, counts issued, fetched, extended, cleared, missing, tampered, errored and degraded \
+	// sessions. See metrics.New for a ready-made prometheus implementation
+	Metrics metrics.ServiceInterface
+
+	// DegradedPolicy defines how GetUserSession behaves when the store fails to fetch a session, e.g. because \
+	// redis is down. Defaults to DegradedPolicyPropagate
+	DegradedPolicy DegradedPolicy
+	// DegradedCacheDuration is how long a validated session may be served while the store is failing, under \
+	// DegradedPolicyFailOpen
+	DegradedCacheDuration time.Duration
+	// DegradedCacheMaxEntries is the maximum number of validated sessions kept under DegradedPolicyFailOpen
+	DegradedCacheMaxEntries int
+
+	// MaxUpdateRetries is the number of times UpdateUserSession and ExtendUserSession fetch the session again and \
+	// retry, when it was saved by a concurrent request in the meantime. A negative value disables retries
+	MaxUpdateRetries int
+
+	// LockRetryDuration is the duration LockUserSession waits before trying to lock a locked session, again
+	LockRetryDuration time.Duration
+
+	// MaxSessionsPerUser, if positive, caps the number of valid sessions a user may hold, e.g. to enforce per-seat \
+	// licensing. IssueUserSession enforces it according to SessionLimitPolicy. The store must implement \
+	// store.LimitServiceInterface
+	MaxSessionsPerUser int
+	// SessionLimitPolicy defines what IssueUserSession does when a user already holds MaxSessionsPerUser \
+	// sessions. Defaults to SessionLimitPolicyReject
+	SessionLimitPolicy SessionLimitPolicy
 }
 
+// IssueOptions defines a session issued with IssueUserSessionWithOptions
+type IssueOptions struct {
+	// Duration, if set, is the session's idle timeout instead of the service's, e.g. 30 days for a "remember me" \
+	// session. It is stored with the session, so ExtendUserSession extends the session by it, too. The session \
+	// never outlives Options.AbsoluteTimeoutDuration, though
+	Duration time.Duration
+	// BrowserSession, if set, writes the session's cookie without an expiry, so the browser discards it when it \
+	// closes, e.g. on a shared kiosk. The session still expires in the store
+	BrowserSession bool
+	// Data is metadata of the session, e.g. the device it was issued to, and is set as the session's Data. The \
+	// store must support fields, see user.Session
+	Data map[string]string
+}
+
+// SessionLimitPolicy defines what IssueUserSession does when a user already holds Options.MaxSessionsPerUser \
+// sessions
+type SessionLimitPolicy int
+
+const (
+	// SessionLimitPolicyReject refuses the new session with store.ErrSessionLimitReached. This is the default
+	SessionLimitPolicyReject SessionLimitPolicy = iota
+	// SessionLimitPolicyEvictOldest deletes the user's sessions that were issued first, to make room for the new one
+	SessionLimitPolicyEvictOldest
+	// SessionLimitPolicyEvictLeastRecentlyUsed deletes the user's sessions that were least recently read with \
+	// GetUserSession, to make room for the new one. Note that every GetUserSession then writes to the store
+	SessionLimitPolicyEvictLeastRecentlyUsed
+)
+
 // New returns a new session service
 func New(store store.ServiceInterface, auth auth.ServiceInterface, transport transport.ServiceInterface, options Options) *Service {
 	setDefaultOptions(&options)
-	return &Service{
+	s := &Service{
 		store:     store,
 		auth:      auth,
 		transport: transport,
 		options:   options,
 	}
+
+	if options.DegradedPolicy == DegradedPolicyFailOpen {
+		s.recent = newRecentSessions(options.DegradedCacheDuration, options.DegradedCacheMaxEntries)
+	}
+
+	return s
 }
 
 // IssueUserSession grants a new user session, writes that session info to the store \
 // and writes the session on the http.ResponseWriter.
 //
-// This method should be called when a user logs in, for example.
+// This method should be called when a user logs in, for example. If Options.MaxSessionsPerUser is set and the \
+// user already holds that many sessions, the user's least valuable sessions are evicted or \
+// store.ErrSessionLimitReached is returned, according to Options.SessionLimitPolicy.
 func (s *Service) IssueUserSession(userID string, json string, w http.ResponseWriter) (*user.Session, error) {
-	userSession := user.New(userID, json, s.options.ExpirationDuration)
+	return s.IssueUserSessionContext(context.Background(), userID, json, w)
+}
+
+// IssueUserSessionContext is like IssueUserSession, but passes ctx to the store
+func (s *Service) IssueUserSessionContext(ctx context.Context, userID string, json string, w http.ResponseWriter) (*user.Session, error) {
+	return s.IssueUserSessionWithOptionsContext(ctx, userID, json, IssueOptions{}, w)
+}
+
+// IssueUserSessionWithOptions is like IssueUserSession, but issues a session that deviates from the service's \
+// options, e.g. a short-lived session on a shared kiosk, or a long-lived "remember me" session. See IssueOptions.
+func (s *Service) IssueUserSessionWithOptions(userID string, json string, options IssueOptions, w http.ResponseWriter) (*user.Session, error) {
+	return s.IssueUserSessionWithOptionsContext(context.Background(), userID, json, options, w)
+}
+
+// IssueUserSessionWithOptionsContext is like IssueUserSessionWithOptions, but passes ctx to the store
+func (s *Service) IssueUserSessionWithOptionsContext(ctx context.Context, userID string, json string, options IssueOptions, w http.ResponseWriter) (*user.Session, error) {
+	userSession := user.New(userID, json, 0)
+	userSession.Duration = options.Duration
+	userSession.BrowserSession = options.BrowserSession
+	for name, value := range options.Data {
+		userSession.Set(name, value)
+	}
+	userSession.ExpiresAt = s.expiresAt(userSession, userSession.CreatedAt)
 
 	// sign the session id
-	signedSessionID, err := s.auth.SignAndBase64Encode(userSession.ID)
+	signedSessionID, err := s.signAndBase64Encode(ctx, userSession.ID)
 	if err != nil {
+		s.countEvent(metrics.EventErrored)
 		return nil, err
 	}
 
 	// save the session in the store
-	if err = s.store.SaveUserSession(userSession); err != nil {
+	if err = s.saveIssuedUserSession(ctx, userSession); err != nil {
+		if err == store.ErrSessionLimitReached {
+			s.countEvent(metrics.EventLimited)
+		} else {
+			s.countEvent(metrics.EventErrored)
+		}
 		return nil, err
 	}
 
 	// set the session on the responseWriter
-	return userSession, s.transport.SetSessionOnResponse(signedSessionID, userSession, w)
+	if err = s.setSessionOnResponse(ctx, signedSessionID, userSession, w); err != nil {
+		s.countEvent(metrics.EventErrored)
+		return userSession, err
+	}
+
+	s.countEvent(metrics.EventIssued)
+	return userSession, nil
 }
 
 // ClearUserSession is used to remove the user session from the store and clear the cookies on the ResponseWriter.
 //
 // This method should be called when a user logs out, for example.
 func (s *Service) ClearUserSession(userSession *user.Session, w http.ResponseWriter) error {
+	return s.ClearUserSessionContext(context.Background(), userSession, w)
+}
+
+// ClearUserSessionContext is like ClearUserSession, but passes ctx to the store
+func (s *Service) ClearUserSessionContext(ctx context.Context, userSession *user.Session, w http.ResponseWriter) error {
+	if s.recent != nil {
+		s.recent.remove(userSession.ID)
+	}
+
 	// delete the session from the store
-	if err := s.store.DeleteUserSession(userSession.ID); err != nil {
+	if err := s.deleteUserSession(ctx, userSession.ID); err != nil {
+		s.countEvent(metrics.EventErrored)
 		return err
 	}
 
 	// delete the session from the response
-	return s.transport.DeleteSessionFromResponse(w)
+	if err := s.deleteSessionFromResponse(ctx, w); err != nil {
+		s.countEvent(metrics.EventErrored)
+		return err
+	}
+
+	s.countEvent(metrics.EventCleared)
+	return nil
 }
 
 // GetUserSession returns a user session from a request. This method only returns valid sessions. Therefore, \
 // sessions that have expired, or that fail signature verification will return a nil pointer to a user.Session
+//
+// The request's context is passed to the store, so the lookup is abandoned if the client goes away.
 func (s *Service) GetUserSession(r *http.Request) (*user.Session, error) {
+	return s.GetUserSessionContext(r.Context(), r)
+}
+
+// GetUserSessionContext is like GetUserSession, but passes ctx to the store instead of the request's context
+func (s *Service) GetUserSessionContext(ctx context.Context, r *http.Request) (*user.Session, error) {
 	// read the session from the request
-	signedSessionID, err := s.transport.FetchSessionIDFromRequest(r)
+	signedSessionID, err := s.fetchSessionIDFromRequest(ctx, r)
 	if err != nil {
 		if err == transport.ErrNoSessionOnRequest {
+			s.countEvent(metrics.EventMissing)
 			// note a nil user.Session pointer indicates a 401 unauthorized
 			return nil, nil
 		}
-
+		s.countEvent(metrics.EventErrored)
 		return nil, err
 	}
 
 	// decode the signedSessionID
-	sessionID, err := s.auth.VerifyAndDecode(signedSessionID)
+	sessionID, err := s.verifyAndDecode(ctx, signedSessionID)
 	if err != nil {
 		if err == auth.ErrInvalidSession {
+			s.countEvent(metrics.EventTampered)
 			return nil, nil
 		}
-
+		s.countEvent(metrics.EventErrored)
 		return nil, err
 	}
 
 	// try fetching a valid session from the store
-	return s.store.FetchValidUserSession(sessionID)
+	userSession, err := s.fetchValidUserSession(ctx, sessionID)
+	if err != nil && s.options.DegradedPolicy != DegradedPolicyPropagate {
+		s.countEvent(metrics.EventDegraded)
+		return s.degradedUserSession(sessionID), nil
+	}
+
+	// note: the store keeps a session until its ExpiresAt, which may lie past its absolute deadline if \
+	// Options.AbsoluteTimeoutDuration was shortened since it was extended
+	if userSession != nil && s.reachedAbsoluteTimeout(userSession, time.Now()) {
+		userSession = nil
+	}
+
+	switch {
+	case err != nil:
+		s.countEvent(metrics.EventErrored)
+	case userSession == nil:
+		s.countEvent(metrics.EventMissing)
+	default:
+		s.countEvent(metrics.EventFetched)
+		if s.recent != nil {
+			s.recent.add(userSession)
+		}
+		s.touchUserSession(ctx, userSession)
+	}
+
+	return userSession, err
 }
 
-// ExtendUserSession extends the ExpiresAt of a session by the Options.ExpirationDuration
+// ExtendUserSession extends the ExpiresAt of a session by its own Duration, if it was issued with one, or else by \
+// the Options.IdleTimeoutDuration, or Options.ExpirationDuration, but no further than the session's absolute deadline, see \
+// Options.AbsoluteTimeoutDuration. If the session has reached its deadline, ErrAbsoluteTimeout is returned. \
+// Sessions saved before their CreatedAt was recorded get it set to the time of their first extension.
 //
 // Note that this function must be called, manually! Extension of user session expiry's does not happen automatically!
 func (s *Service) ExtendUserSession(userSession *user.Session, r *http.Request, w http.ResponseWriter) error {
-	newExpiresAt := time.Now().Add(s.options.ExpirationDuration).UTC()
+	return s.ExtendUserSessionContext(r.Context(), userSession, r, w)
+}
 
-	// update the provided user session
-	userSession.ExpiresAt = newExpiresAt
+// ExtendUserSessionContext is like ExtendUserSession, but passes ctx to the store instead of the request's context
+func (s *Service) ExtendUserSessionContext(ctx context.Context, userSession *user.Session, r *http.Request, w http.ResponseWriter) error {
+	now := time.Now().UTC()
+	extend := func(userSession *user.Session) error {
+		if userSession.CreatedAt.IsZero() {
+			userSession.CreatedAt = now
+		}
+		if s.reachedAbsoluteTimeout(userSession, now) {
+			return ErrAbsoluteTimeout
+		}
+
+		userSession.ExpiresAt = s.expiresAt(userSession, now)
+		return nil
+	}
 
 	// save the session in the store with the extended expiry
-	if err := s.store.SaveUserSession(userSession); err != nil {
-		return err
+	// note: if the store versions sessions, a concurrent change to the session must not be overwritten
+	versioned := false
+	if _, ok := s.store.(store.VersionedServiceInterface); ok {
+		updatedUserSession, err := s.updateUserSession(ctx, userSession, extend)
+		switch {
+		case err == store.ErrNotSupported:
+			// note: decorating stores, like instrument, implement SaveUserSessionIfVersion even if the store they \
+			// wrap doesn't version sessions
+		case err != nil:
+			s.countEvent(metrics.EventErrored)
+			return err
+		case updatedUserSession == nil:
+			// note: the session was deleted in the meantime, e.g. by a concurrent logout
+			s.countEvent(metrics.EventErrored)
+			return store.ErrVersionConflict
+		default:
+			// update the provided user session
+			*userSession = *updatedUserSession
+			versioned = true
+		}
+	}
+	if !versioned {
+		// note: extend a copy, so the caller's session is left alone if the save fails
+		extendedUserSession := userSession.Copy()
+		if err := extend(extendedUserSession); err != nil {
+			s.countEvent(metrics.EventErrored)
+			return err
+		}
+
+		if err := s.saveUserSession(ctx, extendedUserSession); err != nil {
+			s.countEvent(metrics.EventErrored)
+			return err
+		}
+
+		// update the provided user session
+		*userSession = *extendedUserSession
 	}
 
 	// fetch the signed session id from the request
-	signedSessionID, err := s.transport.FetchSessionIDFromRequest(r)
+	signedSessionID, err := s.fetchSessionIDFromRequest(ctx, r)
+	if err != nil {
+		s.countEvent(metrics.EventErrored)
+		return err
+	}
+
+	// finally, set the session on the responseWriter
+	if err := s.setSessionOnResponse(ctx, signedSessionID, userSession, w); err != nil {
+		s.countEvent(metrics.EventErrored)
+		return err
+	}
+
+	s.countEvent(metrics.EventExtended)
+	return nil
+}
+
+// RegenerateUserSession moves a session to a new, freshly signed id, and deletes its old id from the store, so an \
+// id that was planted in or leaked from the browser before is useless afterwards. The session keeps its UserID, \
+// JSON, Data, expiry and absolute deadline. The provided user session is updated with the new id.
+//
+// This method should be called whenever a user's privileges change, e.g. when they log in with a session issued \
+// to an anonymous visitor, or confirm their password before a sensitive action. The store must implement \
+// store.RegenerateServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) RegenerateUserSession(userSession *user.Session, r *http.Request, w http.ResponseWriter) error {
+	return s.RegenerateUserSessionContext(r.Context(), userSession, r, w)
+}
+
+// RegenerateUserSessionContext is like RegenerateUserSession, but passes ctx to the store instead of the request's \
+// context
+func (s *Service) RegenerateUserSessionContext(ctx context.Context, userSession *user.Session, r *http.Request, w http.ResponseWriter) error {
+	regenerateStore, ok := s.store.(store.RegenerateServiceInterface)
+	if !ok {
+		s.countEvent(metrics.EventErrored)
+		return store.ErrNotSupported
+	}
+
+	regeneratedUserSession := userSession.Copy()
+	regeneratedUserSession.ID = uuid.New().String()
+
+	// sign the new session id
+	signedSessionID, err := s.signAndBase64Encode(ctx, regeneratedUserSession.ID)
 	if err != nil {
+		s.countEvent(metrics.EventErrored)
+		return err
+	}
+
+	// move the session to its new id in the store
+	if err := regenerateStore.RegenerateUserSession(ctx, userSession.ID, regeneratedUserSession); err != nil {
+		s.countEvent(metrics.EventErrored)
 		return err
 	}
+	if s.recent != nil {
+		s.recent.remove(userSession.ID)
+		s.recent.add(regeneratedUserSession)
+	}
+
+	// update the provided user session
+	*userSession = *regeneratedUserSession
 
 	// finally, set the session on the responseWriter
-	return s.transport.SetSessionOnResponse(signedSessionID, userSession, w)
+	if err := s.setSessionOnResponse(ctx, signedSessionID, userSession, w); err != nil {
+		s.countEvent(metrics.EventErrored)
+		return err
+	}
+
+	s.countEvent(metrics.EventRegenerated)
+	return nil
+}
+
+// UpdateUserSession fetches the session of the request, calls update with it and saves the updated session, e.g. \
+// to add an item to a shopping cart stored in the session's JSON. If the session was saved by a concurrent request \
+// in the meantime, it is fetched again and update is called again, up to Options.MaxUpdateRetries times, so \
+// concurrent updates never overwrite each other. update must therefore not have side effects other than \
+// modifying the session. The store must implement store.VersionedServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+//
+// Like GetUserSession, a nil pointer to a user.Session is returned if the request has no valid session. If update \
+// returns an error, the session is not saved and the error is returned. If the session is still being changed \
+// concurrently once the retries are exhausted, store.ErrVersionConflict is returned.
+func (s *Service) UpdateUserSession(r *http.Request, update func(userSession *user.Session) error) (*user.Session, error) {
+	return s.UpdateUserSessionContext(r.Context(), r, update)
+}
+
+// UpdateUserSessionContext is like UpdateUserSession, but passes ctx to the store instead of the request's context
+func (s *Service) UpdateUserSessionContext(ctx context.Context, r *http.Request, update func(userSession *user.Session) error) (*user.Session, error) {
+	// note: decorating stores, like instrument, implement store.VersionedServiceInterface even if the store they \
+	// wrap doesn't version sessions. They return store.ErrNotSupported from the first save, before anything is \
+	// written, and it is returned as is, since saving the session without its version could lose concurrent updates.
+	if _, ok := s.store.(store.VersionedServiceInterface); !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	userSession, err := s.GetUserSessionContext(ctx, r)
+	if err != nil || userSession == nil {
+		return nil, err
+	}
+
+	return s.updateUserSession(ctx, userSession, update)
+}
+
+// LockUserSession locks userSession for ttl, so only one request per session proceeds through a workflow, like a \
+// checkout or MFA enrolment, at a time. If the session is locked, LockUserSession waits until the lock is released \
+// or expires, or ctx is done, in which case ctx's error is returned. The store must implement \
+// store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// unlock releases the lock; it returns store.ErrLockNotHeld if the lock expired before, so ttl should exceed the \
+// duration of the workflow. token is the lock's fencing token, which increases with every lock acquired from the \
+// store. Pass it along to resources the workflow writes to, so they can reject writes with a lower token than \
+// they have already seen, from a request whose lock expired while it was paused.
+func (s *Service) LockUserSession(ctx context.Context, userSession *user.Session, ttl time.Duration) (unlock func() error, token int64, err error) {
+	lockStore, ok := s.store.(store.LockServiceInterface)
+	if !ok {
+		return nil, 0, store.ErrNotSupported
+	}
+
+	for {
+		token, err = lockStore.LockUserSession(ctx, userSession.ID, ttl)
+		if err == nil {
+			break
+		}
+		if err != store.ErrLocked {
+			return nil, 0, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(s.options.LockRetryDuration):
+		}
+	}
+
+	unlock = func() error {
+		// note: the lock must be released even if the request's context is done by now
+		return lockStore.UnlockUserSession(context.Background(), userSession.ID, token)
+	}
+
+	return unlock, token, nil
+}
+
+// SetUserSessionField sets a named field of a session's Data in the store, without rewriting the session's other \
+// fields, and on userSession. Independent parts of an app can each own their own fields this way, without \
+// overwriting each other's changes. The store must implement store.FieldServiceInterface, otherwise \
+// store.ErrNotSupported is returned. If the session no longer exists, store.ErrSessionNotFound is returned.
+func (s *Service) SetUserSessionField(userSession *user.Session, name string, value string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	if err := fieldStore.SetUserSessionField(userSession.ID, name, value); err != nil {
+		return err
+	}
+
+	userSession.Set(name, value)
+	if s.recent != nil {
+		s.recent.add(userSession)
+	}
+
+	return nil
+}
+
+// DeleteUserSessionField deletes a named field of a session's Data from the store, without rewriting the \
+// session's other fields, and from userSession. The store must implement store.FieldServiceInterface, otherwise \
+// store.ErrNotSupported is returned. If the session no longer exists, store.ErrSessionNotFound is returned.
+func (s *Service) DeleteUserSessionField(userSession *user.Session, name string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	if err := fieldStore.DeleteUserSessionField(userSession.ID, name); err != nil {
+		return err
+	}
+
+	userSession.Delete(name)
+	if s.recent != nil {
+		s.recent.add(userSession)
+	}
+
+	return nil
+}
+
+// ListUserSessions returns all valid sessions of a user. The store must implement store.UserServiceInterface, \
+// otherwise store.ErrNotSupported is returned.
+func (s *Service) ListUserSessions(userID string) ([]*user.Session, error) {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	return userStore.ListUserSessions(userID)
+}
+
+// DeleteAllUserSessions deletes all sessions of a user from the store, e.g. to log a user out of all devices. \
+// The store must implement store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Note that cookies on the user's other devices are not cleared, but they no longer reference a valid session.
+func (s *Service) DeleteAllUserSessions(userID string) error {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	if s.recent != nil {
+		s.recent.removeUser(userID)
+	}
+
+	return userStore.DeleteAllUserSessions(userID)
+}
+
+// ActiveSessionCount returns the number of valid sessions in the store, e.g. for capacity dashboards. The store \
+// must implement store.ScanServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// The count is computed by scanning the whole store, so it is approximate: sessions issued or cleared while \
+// counting may or may not be counted, and some stores may scan a session twice.
+func (s *Service) ActiveSessionCount() (int, error) {
+	scanStore, ok := s.store.(store.ScanServiceInterface)
+	if !ok {
+		return 0, store.ErrNotSupported
+	}
+
+	count := 0
+	it := store.NewIterator(scanStore, 0)
+	for it.Next() {
+		count++
+	}
+
+	return count, it.Err()
+}
+
+// Health returns an error if the session service can't serve requests, because its store can't be reached. The \
+// store must implement store.HealthServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) Health(ctx context.Context) error {
+	healthStore, ok := s.store.(store.HealthServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return healthStore.Ping(ctx)
+}
+
+// HealthHandler returns an http.Handler for readiness probes. It responds with 200 OK if Health returns nil, and \
+// with 503 Service Unavailable otherwise. The error itself isn't written, so the handler can be exposed without \
+// leaking the store's addresses.
+func (s *Service) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		if err := s.Health(r.Context()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(http.StatusText(http.StatusServiceUnavailable)))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(http.StatusText(http.StatusOK)))
+	})
+}
+
+// DeleteMatchingUserSessions deletes every valid session for which match returns true from the store, e.g. to \
+// revoke all sessions issued before a password leak, and returns the number of deleted sessions. The store must \
+// implement store.ScanServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Sessions issued while deleting may or may not be matched.
+func (s *Service) DeleteMatchingUserSessions(match func(userSession *user.Session) bool) (int, error) {
+	scanStore, ok := s.store.(store.ScanServiceInterface)
+	if !ok {
+		return 0, store.ErrNotSupported
+	}
+
+	// note: a session may be scanned twice, but should only be counted once
+	deleted := make(map[string]bool)
+	it := store.NewIterator(scanStore, 0)
+	for it.Next() {
+		userSession := it.Session()
+		if deleted[userSession.ID] || !match(userSession) {
+			continue
+		}
+
+		if s.recent != nil {
+			s.recent.remove(userSession.ID)
+		}
+		if err := s.store.DeleteUserSession(userSession.ID); err != nil {
+			return len(deleted), err
+		}
+		deleted[userSession.ID] = true
+	}
+
+	return len(deleted), it.Err()
 }
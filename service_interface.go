@@ -1,7 +1,9 @@
 package sessions
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/adam-hanna/sessions/user"
 )
@@ -12,4 +14,24 @@ type ServiceInterface interface {
 	ClearUserSession(userSession *user.Session, w http.ResponseWriter) error
 	GetUserSession(r *http.Request) (*user.Session, error)
 	ExtendUserSession(userSession *user.Session, r *http.Request, w http.ResponseWriter) error
+	IssueUserSessionContext(ctx context.Context, userID string, json string, w http.ResponseWriter) (*user.Session, error)
+	IssueUserSessionWithOptions(userID string, json string, options IssueOptions, w http.ResponseWriter) (*user.Session, error)
+	IssueUserSessionWithOptionsContext(ctx context.Context, userID string, json string, options IssueOptions, w http.ResponseWriter) (*user.Session, error)
+	ClearUserSessionContext(ctx context.Context, userSession *user.Session, w http.ResponseWriter) error
+	GetUserSessionContext(ctx context.Context, r *http.Request) (*user.Session, error)
+	ExtendUserSessionContext(ctx context.Context, userSession *user.Session, r *http.Request, w http.ResponseWriter) error
+	RegenerateUserSession(userSession *user.Session, r *http.Request, w http.ResponseWriter) error
+	RegenerateUserSessionContext(ctx context.Context, userSession *user.Session, r *http.Request, w http.ResponseWriter) error
+	UpdateUserSession(r *http.Request, update func(userSession *user.Session) error) (*user.Session, error)
+	UpdateUserSessionContext(ctx context.Context, r *http.Request, update func(userSession *user.Session) error) (*user.Session, error)
+	LockUserSession(ctx context.Context, userSession *user.Session, ttl time.Duration) (unlock func() error, token int64, err error)
+	SetUserSessionField(userSession *user.Session, name string, value string) error
+	DeleteUserSessionField(userSession *user.Session, name string) error
+	ListUserSessions(userID string) ([]*user.Session, error)
+	DeleteAllUserSessions(userID string) error
+	ActiveSessionCount() (int, error)
+	DeleteMatchingUserSessions(match func(userSession *user.Session) bool) (int, error)
+	Health(ctx context.Context) error
+	HealthHandler() http.Handler
+	Middleware(next http.Handler) http.Handler
 }
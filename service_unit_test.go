@@ -3,12 +3,20 @@
 package sessions
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/adam-hanna/sessions/auth"
+	"github.com/adam-hanna/sessions/metrics"
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/store/instrument"
+	"github.com/adam-hanna/sessions/store/memory"
+	"github.com/adam-hanna/sessions/transport"
 	"github.com/adam-hanna/sessions/user"
 )
 
@@ -16,6 +24,7 @@ var (
 	MockedTestErr = errors.New("test err")
 
 	mockedStore     = MockedStoreType{}
+	mockedUserStore = MockedUserStoreType{}
 	mockedAuth      = MockedAuthType{}
 	mockedTransport = MockedTransportType{}
 
@@ -23,7 +32,7 @@ var (
 	erredAuth      = ErredAuthType{}
 	erredTransport = ErredTransportType{}
 
-	opts = Options{ExpirationDuration: DefaultExpirationDuration}
+	opts = Options{ExpirationDuration: DefaultExpirationDuration, MaxUpdateRetries: DefaultMaxUpdateRetries, LockRetryDuration: DefaultLockRetryDuration, SlidingExpirationThreshold: DefaultSlidingExpirationThreshold}
 
 	inputUserID = "testID"
 	inputJSON   = "testJSON"
@@ -87,6 +96,139 @@ func (d *ErredStoreType) FetchValidUserSession(sessionID string) (*user.Session,
 	return nil, MockedTestErr
 }
 
+type MockedUserStoreType struct {
+	MockedStoreType
+}
+
+func (g *MockedUserStoreType) ListUserSessions(userID string) ([]*user.Session, error) {
+	return []*user.Session{userSession}, nil
+}
+
+func (g *MockedUserStoreType) DeleteAllUserSessions(userID string) error {
+	return nil
+}
+
+type contextKey string
+
+// MockedContextStoreType records the context value passed to the store
+type MockedContextStoreType struct {
+	MockedStoreType
+	received interface{}
+}
+
+func (h *MockedContextStoreType) SaveUserSessionContext(ctx context.Context, userSession *user.Session) error {
+	h.received = ctx.Value(contextKey("test"))
+	return nil
+}
+
+func (h *MockedContextStoreType) DeleteUserSessionContext(ctx context.Context, sessionID string) error {
+	h.received = ctx.Value(contextKey("test"))
+	return nil
+}
+
+func (h *MockedContextStoreType) FetchValidUserSessionContext(ctx context.Context, sessionID string) (*user.Session, error) {
+	h.received = ctx.Value(contextKey("test"))
+	return userSession, nil
+}
+
+// MockedContextAuthType records the context value passed to the auth service
+type MockedContextAuthType struct {
+	MockedAuthType
+	received interface{}
+}
+
+func (l *MockedContextAuthType) SignAndBase64EncodeContext(ctx context.Context, sessionID string) (string, error) {
+	l.received = ctx.Value(contextKey("test"))
+	return "test", nil
+}
+
+func (l *MockedContextAuthType) VerifyAndDecodeContext(ctx context.Context, signed string) (string, error) {
+	l.received = ctx.Value(contextKey("test"))
+	return "test", nil
+}
+
+// MockedContextTransportType records the context value passed to the transport service
+type MockedContextTransportType struct {
+	MockedTransportType
+	received interface{}
+}
+
+func (m *MockedContextTransportType) SetSessionOnResponseContext(ctx context.Context, signedSessionID string, userSession *user.Session, w http.ResponseWriter) error {
+	m.received = ctx.Value(contextKey("test"))
+	return nil
+}
+
+func (m *MockedContextTransportType) DeleteSessionFromResponseContext(ctx context.Context, w http.ResponseWriter) error {
+	m.received = ctx.Value(contextKey("test"))
+	return nil
+}
+
+func (m *MockedContextTransportType) FetchSessionIDFromRequestContext(ctx context.Context, r *http.Request) (string, error) {
+	m.received = ctx.Value(contextKey("test"))
+	return "test", nil
+}
+
+type MissingStoreType struct {
+	MockedStoreType
+}
+
+func (i *MissingStoreType) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	return nil, nil
+}
+
+// EchoStoreType returns a session with the requested id
+type EchoStoreType struct {
+	MockedStoreType
+}
+
+func (l *EchoStoreType) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	return &user.Session{ID: sessionID, UserID: inputUserID, ExpiresAt: time.Now().Add(1 * time.Hour)}, nil
+}
+
+// UnversionedStoreType hides every optional capability of the store it embeds
+type UnversionedStoreType struct {
+	store.ServiceInterface
+}
+
+// ConcurrentStoreType runs concurrent once, before the first versioned save of a session, to change the store \
+// behind the saving request's back. If concurrent returns an error, the save fails with it
+type ConcurrentStoreType struct {
+	*memory.Service
+	concurrent func(memoryStore *memory.Service) error
+}
+
+func (n *ConcurrentStoreType) SaveUserSessionIfVersion(userSession *user.Session, version int64) error {
+	if n.concurrent != nil {
+		concurrent := n.concurrent
+		n.concurrent = nil
+		if err := concurrent(n.Service); err != nil {
+			return err
+		}
+	}
+
+	return n.Service.SaveUserSessionIfVersion(userSession, version)
+}
+
+type TamperedAuthType struct {
+	MockedAuthType
+}
+
+func (j *TamperedAuthType) VerifyAndDecode(signed string) (string, error) {
+	return "", auth.ErrInvalidSession
+}
+
+// MockedMetricsType records the counted events
+type MockedMetricsType struct {
+	events []string
+}
+
+func (k *MockedMetricsType) CountEvent(event string) {
+	k.events = append(k.events, event)
+}
+
+func (k *MockedMetricsType) ObserveStoreOperation(operation string, duration time.Duration, err error) {
+}
+
 type MockedTransportType struct {
 }
 
@@ -324,8 +466,9 @@ func TestExtendUserSession(t *testing.T) {
 	var w http.ResponseWriter
 
 	var tests = []struct {
-		input       Service
-		expectedErr error
+		input             Service
+		expectedErr       error
+		expectedExtension bool
 	}{
 		{
 			Service{
@@ -335,6 +478,8 @@ func TestExtendUserSession(t *testing.T) {
 				options:   opts,
 			},
 			MockedTestErr,
+			// note: the session was not saved, so the provided session must not be extended
+			false,
 		},
 		{
 			Service{
@@ -344,6 +489,7 @@ func TestExtendUserSession(t *testing.T) {
 				options:   opts,
 			},
 			MockedTestErr,
+			true,
 		},
 		{
 			Service{
@@ -353,22 +499,763 @@ func TestExtendUserSession(t *testing.T) {
 				options:   opts,
 			},
 			nil,
+			true,
 		},
 	}
 
 	for idx, tt := range tests {
 		// let's use a test user session bc we don't want to mess with the one defined above
+		expiresAt := time.Now().UTC()
 		testUserSession := &user.Session{
-			ExpiresAt: time.Now().UTC(),
+			ExpiresAt: expiresAt,
 		}
 		e := tt.input.ExtendUserSession(testUserSession, r, w)
 		assertErr := e == tt.expectedErr
 
 		newExpiresAt := time.Now().Add(tt.input.options.ExpirationDuration).UTC()
 		assertExtension := newExpiresAt.Sub(testUserSession.ExpiresAt) < 1*time.Second
+		if !tt.expectedExtension {
+			assertExtension = testUserSession.ExpiresAt.Equal(expiresAt) && testUserSession.CreatedAt.IsZero()
+		}
 
 		if !assertExtension || !assertErr {
 			t.Errorf("test #%d failed; input service: %v, assertSession: %t, assertErr: %t, expected expires at: %v, expectedErr: %v, received expires at: %v, received err: %v", idx+1, tt.input, assertExtension, assertErr, newExpiresAt, tt.expectedErr, testUserSession.ExpiresAt, e)
 		}
 	}
 }
+
+// TestDecoratedStore tests that sessions are extended through decorating stores that implement \
+// store.VersionedServiceInterface even if the store they wrap doesn't version sessions
+func TestDecoratedStore(t *testing.T) {
+	r := &http.Request{}
+	memoryStore := memory.New(memory.Options{})
+	defer memoryStore.Close()
+	memoryStore.SaveUserSession(&user.Session{ID: "test", UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Minute)})
+
+	var tests = []struct {
+		store       store.ServiceInterface
+		expectedErr error
+	}{
+		{&UnversionedStoreType{memoryStore}, nil},
+		{instrument.New(&UnversionedStoreType{memoryStore}, &MockedMetricsType{}), nil},
+		{instrument.New(memoryStore, &MockedMetricsType{}), nil},
+	}
+
+	for idx, tt := range tests {
+		s := New(tt.store, &mockedAuth, &mockedTransport, opts)
+		userSession, _ := memoryStore.FetchValidUserSession("test")
+		userSession.ExpiresAt = time.Now().Add(1 * time.Minute)
+
+		e := s.ExtendUserSession(userSession, r, nil)
+		stored, _ := memoryStore.FetchValidUserSession("test")
+
+		if e != tt.expectedErr || stored == nil || time.Until(stored.ExpiresAt) < time.Hour || time.Until(userSession.ExpiresAt) < time.Hour {
+			t.Errorf("test #%d failed; expected the session to be extended, expected err: %v, received session: %v, received stored session: %v, received err: %v", idx+1, tt.expectedErr, userSession, stored, e)
+		}
+	}
+
+	// note: without versions, concurrent updates could overwrite each other, so updates are refused
+	s := New(instrument.New(&UnversionedStoreType{memoryStore}, &MockedMetricsType{}), &mockedAuth, &mockedTransport, opts)
+	if a, e := s.UpdateUserSession(r, func(userSession *user.Session) error { userSession.JSON = "updated"; return nil }); a != nil || e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received session: %v, received err: %v", store.ErrNotSupported, a, e)
+	}
+	if stored, _ := memoryStore.FetchValidUserSession("test"); stored == nil || stored.JSON != "json" {
+		t.Errorf("test failed; expected the session not to be updated, received: %v", stored)
+	}
+}
+
+// TestLockUserSession tests that only one request per session holds the lock at a time
+func TestLockUserSession(t *testing.T) {
+	memoryStore := memory.New(memory.Options{})
+	defer memoryStore.Close()
+	s := New(memoryStore, &mockedAuth, &mockedTransport, Options{LockRetryDuration: 1 * time.Millisecond})
+	userSession := &user.Session{ID: "test"}
+
+	unlock, token, err := s.LockUserSession(context.Background(), userSession, 1*time.Hour)
+	if err != nil || token <= 0 {
+		t.Fatalf("test failed; expected the session to be locked, received token: %d, err: %v", token, err)
+	}
+
+	// note: a second request waits until the lock is released, or its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, e := s.LockUserSession(ctx, userSession, 1*time.Hour); e != context.DeadlineExceeded {
+		t.Errorf("test failed; expected err: %v, received: %v", context.DeadlineExceeded, e)
+	}
+
+	locked := make(chan int64)
+	go func() {
+		secondUnlock, secondToken, _ := s.LockUserSession(context.Background(), userSession, 1*time.Hour)
+		secondUnlock()
+		locked <- secondToken
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if e := unlock(); e != nil {
+		t.Errorf("test failed; expected err to be nil when unlocking, received: %v", e)
+	}
+	if secondToken := <-locked; secondToken <= token {
+		t.Errorf("test failed; expected a larger token than %d, received: %d", token, secondToken)
+	}
+	if e := unlock(); e != store.ErrLockNotHeld {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrLockNotHeld, e)
+	}
+
+	notSupported := New(&mockedStore, &mockedAuth, &mockedTransport, opts)
+	if _, _, e := notSupported.LockUserSession(context.Background(), userSession, 1*time.Hour); e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrNotSupported, e)
+	}
+}
+
+// TestUserSessionFields tests the SetUserSessionField and DeleteUserSessionField functions
+func TestUserSessionFields(t *testing.T) {
+	memoryStore := memory.New(memory.Options{})
+	defer memoryStore.Close()
+	userSession := &user.Session{ID: "test", UserID: "userID", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	memoryStore.SaveUserSession(userSession)
+	s := New(memoryStore, &mockedAuth, &mockedTransport, opts)
+
+	var tests = []struct {
+		input        func() error
+		expectedErr  error
+		expectedData map[string]string
+	}{
+		{func() error { return s.SetUserSessionField(userSession, "cart", "1") }, nil, map[string]string{"cart": "1"}},
+		{func() error { return s.SetUserSessionField(userSession, "theme", "dark") }, nil, map[string]string{"cart": "1", "theme": "dark"}},
+		{func() error { return s.DeleteUserSessionField(userSession, "cart") }, nil, map[string]string{"theme": "dark"}},
+		{func() error {
+			return s.SetUserSessionField(&user.Session{ID: "missing"}, "cart", "1")
+		}, store.ErrSessionNotFound, map[string]string{"theme": "dark"}},
+	}
+
+	for idx, tt := range tests {
+		e := tt.input()
+		stored, _ := memoryStore.FetchValidUserSession(userSession.ID)
+
+		if e != tt.expectedErr || stored == nil || !reflect.DeepEqual(stored.Data, tt.expectedData) || !reflect.DeepEqual(userSession.Data, tt.expectedData) {
+			t.Errorf("test #%d failed; expected data: %v, expectedErr: %v, received session: %v, received stored session: %v, received err: %v", idx+1, tt.expectedData, tt.expectedErr, userSession, stored, e)
+		}
+	}
+
+	notSupported := New(&mockedStore, &mockedAuth, &mockedTransport, opts)
+	if e := notSupported.SetUserSessionField(userSession, "cart", "1"); e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrNotSupported, e)
+	}
+	if e := notSupported.DeleteUserSessionField(userSession, "theme"); e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrNotSupported, e)
+	}
+}
+
+// TestListUserSessions tests the ListUserSessions function
+// TestUpdateUserSession tests the UpdateUserSession function
+func TestUpdateUserSession(t *testing.T) {
+	r := &http.Request{}
+	memoryStore := memory.New(memory.Options{})
+	defer memoryStore.Close()
+	memoryStore.SaveUserSession(&user.Session{ID: "test", UserID: "userID", JSON: "0", ExpiresAt: time.Now().Add(1 * time.Hour)})
+	s := New(memoryStore, &mockedAuth, &mockedTransport, opts)
+
+	// note: the concurrent update saves the session once, so the first attempt of the update conflicts
+	concurrentlySaved := false
+	var tests = []struct {
+		input        func(userSession *user.Session) error
+		expectedJSON string
+		expectedErr  error
+	}{
+		{func(userSession *user.Session) error { userSession.JSON = "1"; return nil }, "1", nil},
+		{func(userSession *user.Session) error { userSession.JSON = "erred"; return MockedTestErr }, "1", MockedTestErr},
+		{func(userSession *user.Session) error {
+			if !concurrentlySaved {
+				concurrentlySaved = true
+				memoryStore.SaveUserSession(&user.Session{ID: "test", UserID: "userID", JSON: "2", ExpiresAt: time.Now().Add(1 * time.Hour)})
+			}
+			userSession.JSON += "3"
+			return nil
+		}, "23", nil},
+	}
+
+	for idx, tt := range tests {
+		a, e := s.UpdateUserSession(r, tt.input)
+		stored, _ := memoryStore.FetchValidUserSession("test")
+
+		if e != tt.expectedErr || stored == nil || stored.JSON != tt.expectedJSON || (e == nil && (a == nil || a.JSON != tt.expectedJSON || a.Version != stored.Version)) {
+			t.Errorf("test #%d failed; expected json: %s, expectedErr: %v, received session: %v, received stored session: %v, received err: %v", idx+1, tt.expectedJSON, tt.expectedErr, a, stored, e)
+		}
+	}
+
+	noRetries := New(memoryStore, &mockedAuth, &mockedTransport, Options{MaxUpdateRetries: -1})
+	if a, e := noRetries.UpdateUserSession(r, func(userSession *user.Session) error {
+		memoryStore.SaveUserSession(&user.Session{ID: "test", UserID: "userID", JSON: "4", ExpiresAt: time.Now().Add(1 * time.Hour)})
+		return nil
+	}); a != nil || e != store.ErrVersionConflict {
+		t.Errorf("test failed; expected err: %v, received session: %v, received err: %v", store.ErrVersionConflict, a, e)
+	}
+
+	stale := &user.Session{ID: "test", UserID: "userID", JSON: "stale", ExpiresAt: time.Now()}
+	if e := s.ExtendUserSession(stale, r, nil); e != nil || stale.JSON != "4" || time.Until(stale.ExpiresAt) < time.Hour {
+		t.Errorf("test failed; expected the stored session to be extended, received session: %v, received err: %v", stale, e)
+	}
+
+	memoryStore.DeleteUserSession("test")
+	if a, e := s.UpdateUserSession(r, func(userSession *user.Session) error { return nil }); a != nil || e != nil {
+		t.Errorf("test failed; expected no session, received session: %v, received err: %v", a, e)
+	}
+
+	notSupported := New(&mockedStore, &mockedAuth, &mockedTransport, opts)
+	if _, e := notSupported.UpdateUserSession(r, func(userSession *user.Session) error { return nil }); e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrNotSupported, e)
+	}
+}
+
+func TestListUserSessions(t *testing.T) {
+	var tests = []struct {
+		input            Service
+		expectedSessions []*user.Session
+		expectedErr      error
+	}{
+		{
+			Service{
+				store:     &mockedStore,
+				auth:      &mockedAuth,
+				transport: &mockedTransport,
+				options:   opts,
+			},
+			nil,
+			store.ErrNotSupported,
+		},
+		{
+			Service{
+				store:     &mockedUserStore,
+				auth:      &mockedAuth,
+				transport: &mockedTransport,
+				options:   opts,
+			},
+			[]*user.Session{userSession},
+			nil,
+		},
+	}
+
+	for idx, tt := range tests {
+		a, e := tt.input.ListUserSessions(inputUserID)
+		assertErr := e == tt.expectedErr
+		assertSessions := reflect.DeepEqual(a, tt.expectedSessions)
+
+		if !assertSessions || !assertErr {
+			t.Errorf("test #%d failed; input service: %v, assertSessions: %t, assertErr: %t, expected sessions: %v, expectedErr: %v, received sessions: %v, received err: %v", idx+1, tt.input, assertSessions, assertErr, tt.expectedSessions, tt.expectedErr, a, e)
+		}
+	}
+}
+
+// TestDeleteAllUserSessions tests the DeleteAllUserSessions function
+func TestDeleteAllUserSessions(t *testing.T) {
+	var tests = []struct {
+		input       Service
+		expectedErr error
+	}{
+		{
+			Service{
+				store:     &mockedStore,
+				auth:      &mockedAuth,
+				transport: &mockedTransport,
+				options:   opts,
+			},
+			store.ErrNotSupported,
+		},
+		{
+			Service{
+				store:     &mockedUserStore,
+				auth:      &mockedAuth,
+				transport: &mockedTransport,
+				options:   opts,
+			},
+			nil,
+		},
+	}
+
+	for idx, tt := range tests {
+		e := tt.input.DeleteAllUserSessions(inputUserID)
+		assertErr := e == tt.expectedErr
+
+		if !assertErr {
+			t.Errorf("test #%d failed; input service: %v, assertErr: %t, expectedErr: %v, received err: %v", idx+1, tt.input, assertErr, tt.expectedErr, e)
+		}
+	}
+}
+
+// TestActiveSessionCount tests the ActiveSessionCount function
+func TestActiveSessionCount(t *testing.T) {
+	memoryStore := memory.New(memory.Options{})
+	defer memoryStore.Close()
+	for _, id := range []string{"a", "b", "c"} {
+		memoryStore.SaveUserSession(&user.Session{ID: id, UserID: inputUserID, ExpiresAt: time.Now().Add(1 * time.Hour)})
+	}
+
+	var tests = []struct {
+		input         store.ServiceInterface
+		expectedCount int
+		expectedErr   error
+	}{
+		{&mockedStore, 0, store.ErrNotSupported},
+		{memoryStore, 3, nil},
+	}
+
+	for idx, tt := range tests {
+		s := New(tt.input, &mockedAuth, &mockedTransport, opts)
+		a, e := s.ActiveSessionCount()
+
+		if a != tt.expectedCount || e != tt.expectedErr {
+			t.Errorf("test #%d failed; expected count: %d, expectedErr: %v, received count: %d, received err: %v", idx+1, tt.expectedCount, tt.expectedErr, a, e)
+		}
+	}
+}
+
+// TestDeleteMatchingUserSessions tests the DeleteMatchingUserSessions function
+func TestDeleteMatchingUserSessions(t *testing.T) {
+	memoryStore := memory.New(memory.Options{})
+	defer memoryStore.Close()
+	for _, id := range []string{"a", "b", "c"} {
+		memoryStore.SaveUserSession(&user.Session{ID: id, UserID: "user" + id, ExpiresAt: time.Now().Add(1 * time.Hour)})
+	}
+	s := New(memoryStore, &mockedAuth, &mockedTransport, opts)
+
+	var tests = []struct {
+		input         func(userSession *user.Session) bool
+		expectedCount int
+		expectedErr   error
+		expectedLeft  int
+	}{
+		{func(userSession *user.Session) bool { return false }, 0, nil, 3},
+		{func(userSession *user.Session) bool { return userSession.UserID == "usera" }, 1, nil, 2},
+		{func(userSession *user.Session) bool { return true }, 2, nil, 0},
+	}
+
+	for idx, tt := range tests {
+		a, e := s.DeleteMatchingUserSessions(tt.input)
+		left, _ := s.ActiveSessionCount()
+
+		if a != tt.expectedCount || e != tt.expectedErr || left != tt.expectedLeft {
+			t.Errorf("test #%d failed; expected count: %d, expectedErr: %v, expected left: %d, received count: %d, received err: %v, received left: %d", idx+1, tt.expectedCount, tt.expectedErr, tt.expectedLeft, a, e, left)
+		}
+	}
+
+	notSupported := New(&mockedStore, &mockedAuth, &mockedTransport, opts)
+	if _, e := notSupported.DeleteMatchingUserSessions(func(userSession *user.Session) bool { return true }); e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrNotSupported, e)
+	}
+}
+
+// TestHealth tests the Health and HealthHandler functions
+func TestHealth(t *testing.T) {
+	memoryStore := memory.New(memory.Options{})
+	defer memoryStore.Close()
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var tests = []struct {
+		input          store.ServiceInterface
+		ctx            context.Context
+		expectedErr    error
+		expectedStatus int
+	}{
+		{&mockedStore, context.Background(), store.ErrNotSupported, http.StatusServiceUnavailable},
+		{memoryStore, context.Background(), nil, http.StatusOK},
+		{memoryStore, cancelledCtx, context.Canceled, http.StatusServiceUnavailable},
+	}
+
+	for idx, tt := range tests {
+		s := New(tt.input, &mockedAuth, &mockedTransport, opts)
+		e := s.Health(tt.ctx)
+
+		w := httptest.NewRecorder()
+		s.HealthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil).WithContext(tt.ctx))
+
+		if e != tt.expectedErr || w.Code != tt.expectedStatus {
+			t.Errorf("test #%d failed; expected err: %v, expected status: %d, received err: %v, received status: %d", idx+1, tt.expectedErr, tt.expectedStatus, e, w.Code)
+		}
+	}
+}
+
+// TestContextPropagation tests that the context is passed to the stores, auth and transport services that support \
+// it
+func TestContextPropagation(t *testing.T) {
+	var w http.ResponseWriter
+	ctx := context.WithValue(context.Background(), contextKey("test"), "value")
+	r := (&http.Request{}).WithContext(ctx)
+
+	var tests = []struct {
+		run               func(s *Service) error
+		expectedAuthValue interface{}
+	}{
+		{func(s *Service) error {
+			_, err := s.IssueUserSessionContext(ctx, inputUserID, inputJSON, w)
+			return err
+		}, "value"},
+		{func(s *Service) error {
+			return s.ClearUserSessionContext(ctx, userSession, w)
+		}, nil},
+		{func(s *Service) error {
+			// note: GetUserSession uses the request's context
+			_, err := s.GetUserSession(r)
+			return err
+		}, "value"},
+		{func(s *Service) error {
+			return s.ExtendUserSession(&user.Session{}, r, w)
+		}, nil},
+	}
+
+	for idx, tt := range tests {
+		contextStore := &MockedContextStoreType{}
+		contextAuth := &MockedContextAuthType{}
+		contextTransport := &MockedContextTransportType{}
+		s := &Service{
+			store:     contextStore,
+			auth:      contextAuth,
+			transport: contextTransport,
+			options:   opts,
+		}
+
+		e := tt.run(s)
+		if e != nil || contextStore.received != "value" || contextAuth.received != tt.expectedAuthValue || contextTransport.received != "value" {
+			t.Errorf("test #%d failed; expected the context to be passed to the store, auth and transport services, received values: %v, %v, %v, received err: %v", idx+1, contextStore.received, contextAuth.received, contextTransport.received, e)
+		}
+	}
+}
+
+// TestMetrics tests that session events are counted
+func TestMetrics(t *testing.T) {
+	var w http.ResponseWriter
+	r := &http.Request{}
+
+	var tests = []struct {
+		store         store.ServiceInterface
+		auth          auth.ServiceInterface
+		run           func(s *Service) error
+		expectedEvent string
+	}{
+		{
+			&mockedStore,
+			&mockedAuth,
+			func(s *Service) error {
+				_, err := s.IssueUserSession(inputUserID, inputJSON, w)
+				return err
+			},
+			metrics.EventIssued,
+		},
+		{
+			&erredStore,
+			&mockedAuth,
+			func(s *Service) error {
+				_, err := s.IssueUserSession(inputUserID, inputJSON, w)
+				return err
+			},
+			metrics.EventErrored,
+		},
+		{
+			&mockedStore,
+			&mockedAuth,
+			func(s *Service) error {
+				return s.ClearUserSession(userSession, w)
+			},
+			metrics.EventCleared,
+		},
+		{
+			&mockedStore,
+			&mockedAuth,
+			func(s *Service) error {
+				_, err := s.GetUserSession(r)
+				return err
+			},
+			metrics.EventFetched,
+		},
+		{
+			&MissingStoreType{},
+			&mockedAuth,
+			func(s *Service) error {
+				_, err := s.GetUserSession(r)
+				return err
+			},
+			metrics.EventMissing,
+		},
+		{
+			&mockedStore,
+			&TamperedAuthType{},
+			func(s *Service) error {
+				_, err := s.GetUserSession(r)
+				return err
+			},
+			metrics.EventTampered,
+		},
+		{
+			&mockedStore,
+			&mockedAuth,
+			func(s *Service) error {
+				return s.ExtendUserSession(&user.Session{}, r, w)
+			},
+			metrics.EventExtended,
+		},
+		{
+			&erredStore,
+			&mockedAuth,
+			func(s *Service) error {
+				return s.ExtendUserSession(&user.Session{}, r, w)
+			},
+			metrics.EventErrored,
+		},
+	}
+
+	for idx, tt := range tests {
+		m := &MockedMetricsType{}
+		s := New(tt.store, tt.auth, &mockedTransport, Options{Metrics: m})
+
+		tt.run(s)
+		if len(m.events) != 1 || m.events[0] != tt.expectedEvent {
+			t.Errorf("test #%d failed; expected events: [%s], received: %v", idx+1, tt.expectedEvent, m.events)
+		}
+	}
+}
+
+// TestDegradedPolicy tests GetUserSession when the store fails
+func TestDegradedPolicy(t *testing.T) {
+	r := &http.Request{}
+
+	var tests = []struct {
+		input           DegradedPolicy
+		expectedSession bool
+		expectedErr     error
+	}{
+		{DegradedPolicyPropagate, false, MockedTestErr},
+		{DegradedPolicyFailClosed, false, nil},
+		{DegradedPolicyFailOpen, true, nil},
+	}
+
+	for idx, tt := range tests {
+		m := &MockedMetricsType{}
+		s := New(&EchoStoreType{}, &mockedAuth, &mockedTransport, Options{DegradedPolicy: tt.input, Metrics: m})
+
+		// note: validate the session while the store is healthy
+		if _, e := s.GetUserSession(r); e != nil {
+			t.Fatalf("Err getting user session: %v", e)
+		}
+
+		s.store = &erredStore
+		a, e := s.GetUserSession(r)
+
+		if (a != nil) != tt.expectedSession || e != tt.expectedErr {
+			t.Errorf("test #%d failed; expected session: %t, expected err: %v, received session: %v, received err: %v", idx+1, tt.expectedSession, tt.expectedErr, a, e)
+		}
+		if tt.input != DegradedPolicyPropagate && m.events[len(m.events)-1] != metrics.EventDegraded {
+			t.Errorf("test #%d failed; expected event: %s, received events: %v", idx+1, metrics.EventDegraded, m.events)
+		}
+	}
+
+	// note: a cleared session must not be served while failing open
+	s := New(&EchoStoreType{}, &mockedAuth, &mockedTransport, Options{DegradedPolicy: DegradedPolicyFailOpen})
+	a, _ := s.GetUserSession(r)
+	s.ClearUserSession(a, nil)
+	s.store = &erredStore
+	if a, e := s.GetUserSession(r); a != nil || e != nil {
+		t.Errorf("test failed; expected no session after clearing it, received session: %v, received err: %v", a, e)
+	}
+}
+
+// TestSessionLimit tests that IssueUserSession enforces MaxSessionsPerUser according to the SessionLimitPolicy
+func TestSessionLimit(t *testing.T) {
+	var w http.ResponseWriter
+	ctx := context.Background()
+
+	var tests = []struct {
+		policy            SessionLimitPolicy
+		expectedErr       error
+		expectedRemaining []int
+		expectedEvents    []string
+	}{
+		{SessionLimitPolicyReject, store.ErrSessionLimitReached, []int{0, 1}, []string{metrics.EventLimited}},
+		{SessionLimitPolicyEvictOldest, nil, []int{1, 2}, []string{metrics.EventEvicted, metrics.EventIssued}},
+		{SessionLimitPolicyEvictLeastRecentlyUsed, nil, []int{0, 2}, []string{metrics.EventEvicted, metrics.EventIssued}},
+	}
+
+	for idx, tt := range tests {
+		memoryStore := memory.New(memory.Options{})
+		m := &MockedMetricsType{}
+		s := New(memoryStore, &mockedAuth, &mockedTransport, Options{MaxSessionsPerUser: 2, SessionLimitPolicy: tt.policy, Metrics: m})
+
+		var userSessions []*user.Session
+		for i := 0; i < 2; i++ {
+			userSession, err := s.IssueUserSession(inputUserID, inputJSON, w)
+			if err != nil {
+				t.Fatalf("test #%d failed; expected err to be nil when issuing, received: %v", idx+1, err)
+			}
+			userSessions = append(userSessions, userSession)
+		}
+		// note: use the first session, so it is the most recently used one
+		s.touchUserSession(ctx, userSessions[0])
+
+		userSession, e := s.IssueUserSession(inputUserID, inputJSON, w)
+		userSessions = append(userSessions, userSession)
+
+		var remaining []int
+		for i, userSession := range userSessions {
+			if userSession == nil {
+				continue
+			}
+			if a, _ := memoryStore.FetchValidUserSession(userSession.ID); a != nil {
+				remaining = append(remaining, i)
+			}
+		}
+
+		events := m.events[2:]
+		if e != tt.expectedErr || !reflect.DeepEqual(remaining, tt.expectedRemaining) || !reflect.DeepEqual(events, tt.expectedEvents) {
+			t.Errorf("test #%d failed; expected err: %v, expected remaining: %v, expected events: %v, received err: %v, received remaining: %v, received events: %v", idx+1, tt.expectedErr, tt.expectedRemaining, tt.expectedEvents, e, remaining, events)
+		}
+
+		memoryStore.Close()
+	}
+
+	s := New(&mockedStore, &mockedAuth, &mockedTransport, Options{MaxSessionsPerUser: 1})
+	if _, e := s.IssueUserSession(inputUserID, inputJSON, w); e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrNotSupported, e)
+	}
+}
+
+// TestTimeouts tests that sessions expire after the idle timeout, and never outlive the absolute timeout
+func TestTimeouts(t *testing.T) {
+	var w http.ResponseWriter
+	r := &http.Request{}
+	now := time.Now().UTC()
+
+	var tests = []struct {
+		options           Options
+		createdAt         time.Time
+		expectedExtendErr error
+		expectedExpiresAt time.Time
+		expectedFetched   bool
+	}{
+		{Options{IdleTimeoutDuration: 1 * time.Hour}, now.Add(-3 * time.Hour), nil, now.Add(1 * time.Hour), true},
+		{Options{IdleTimeoutDuration: 1 * time.Hour, AbsoluteTimeoutDuration: 4 * time.Hour}, now.Add(-2 * time.Hour), nil, now.Add(1 * time.Hour), true},
+		{Options{IdleTimeoutDuration: 1 * time.Hour, AbsoluteTimeoutDuration: 4 * time.Hour}, now.Add(-210 * time.Minute), nil, now.Add(30 * time.Minute), true},
+		{Options{IdleTimeoutDuration: 1 * time.Hour, AbsoluteTimeoutDuration: 4 * time.Hour}, now.Add(-5 * time.Hour), ErrAbsoluteTimeout, now.Add(1 * time.Hour), false},
+		{Options{ExpirationDuration: 2 * time.Hour, AbsoluteTimeoutDuration: 4 * time.Hour}, time.Time{}, nil, now.Add(2 * time.Hour), true},
+	}
+
+	for idx, tt := range tests {
+		memoryStore := memory.New(memory.Options{})
+		s := New(memoryStore, &mockedAuth, &mockedTransport, tt.options)
+
+		// note: the mocked auth decodes every session id to "test"
+		stored := &user.Session{ID: "test", UserID: inputUserID, JSON: inputJSON, ExpiresAt: now.Add(1 * time.Hour), CreatedAt: tt.createdAt}
+		memoryStore.SaveUserSession(stored)
+
+		fetched, e := s.GetUserSession(r)
+		if e != nil || (fetched != nil) != tt.expectedFetched {
+			t.Errorf("test #%d failed; expected fetched: %t, received session: %v, received err: %v", idx+1, tt.expectedFetched, fetched, e)
+		}
+
+		extended := stored.Copy()
+		e = s.ExtendUserSession(extended, r, w)
+		offset := extended.ExpiresAt.Sub(tt.expectedExpiresAt)
+		if e != tt.expectedExtendErr || (e == nil && (offset < -1*time.Second || offset > 1*time.Second || extended.CreatedAt.IsZero())) {
+			t.Errorf("test #%d failed; expected err: %v, expected expires at: %v, received err: %v, received session: %v", idx+1, tt.expectedExtendErr, tt.expectedExpiresAt, e, extended)
+		}
+
+		memoryStore.Close()
+	}
+
+	s := New(&mockedStore, &mockedAuth, &mockedTransport, Options{IdleTimeoutDuration: 1 * time.Hour, AbsoluteTimeoutDuration: 30 * time.Minute})
+	issued, e := s.IssueUserSession(inputUserID, inputJSON, w)
+	if e != nil || issued.CreatedAt.IsZero() || issued.ExpiresAt != issued.CreatedAt.Add(30*time.Minute) {
+		t.Errorf("test failed; expected the session to expire at its absolute deadline, received session: %v, received err: %v", issued, e)
+	}
+}
+
+// TestIssueUserSessionWithOptions tests that sessions keep the duration, cookie kind and data they were issued with, \
+// and are extended by their own duration
+func TestIssueUserSessionWithOptions(t *testing.T) {
+	var w http.ResponseWriter
+	r := &http.Request{}
+	now := time.Now()
+
+	var tests = []struct {
+		serviceOptions    Options
+		input             IssueOptions
+		expectedExpiresAt time.Time
+	}{
+		{Options{IdleTimeoutDuration: 1 * time.Hour}, IssueOptions{}, now.Add(1 * time.Hour)},
+		{Options{IdleTimeoutDuration: 1 * time.Hour}, IssueOptions{Duration: 5 * time.Minute, BrowserSession: true}, now.Add(5 * time.Minute)},
+		{Options{IdleTimeoutDuration: 1 * time.Hour}, IssueOptions{Duration: 30 * 24 * time.Hour, Data: map[string]string{"device": "phone"}}, now.Add(30 * 24 * time.Hour)},
+		{Options{IdleTimeoutDuration: 1 * time.Hour, AbsoluteTimeoutDuration: 7 * 24 * time.Hour}, IssueOptions{Duration: 30 * 24 * time.Hour}, now.Add(7 * 24 * time.Hour)},
+	}
+
+	for idx, tt := range tests {
+		memoryStore := memory.New(memory.Options{})
+		s := New(memoryStore, &mockedAuth, &mockedTransport, tt.serviceOptions)
+
+		issued, e := s.IssueUserSessionWithOptions(inputUserID, inputJSON, tt.input, w)
+		if e != nil {
+			t.Fatalf("test #%d failed; expected err to be nil when issuing, received: %v", idx+1, e)
+		}
+		stored, _ := memoryStore.FetchValidUserSession(issued.ID)
+
+		// note: the session is extended by its own duration, not the service's
+		e = s.ExtendUserSession(issued, r, w)
+
+		for _, a := range []*user.Session{stored, issued} {
+			offset := a.ExpiresAt.Sub(tt.expectedExpiresAt)
+
+			if e != nil || a == nil || offset < -1*time.Second || offset > 1*time.Second || a.Duration != tt.input.Duration || a.BrowserSession != tt.input.BrowserSession || (len(tt.input.Data) > 0 && !reflect.DeepEqual(a.Data, tt.input.Data)) {
+				t.Errorf("test #%d failed; expected expires at: %v, expected options: %v, received session: %v, received err: %v", idx+1, tt.expectedExpiresAt, tt.input, a, e)
+			}
+		}
+
+		memoryStore.Close()
+	}
+}
+
+// TestRegenerateUserSession tests that a session is moved to a new id with the same user, JSON and expiry, that \
+// the old id is deleted, and that the new id is set on the response
+func TestRegenerateUserSession(t *testing.T) {
+	r := &http.Request{}
+	createdAt := time.Now().Add(-1 * time.Hour).UTC()
+
+	var tests = []struct {
+		store         store.ServiceInterface
+		auth          auth.ServiceInterface
+		transport     transport.ServiceInterface
+		expectedErr   error
+		expectedMoved bool
+		expectedEvent string
+	}{
+		{memory.New(memory.Options{}), &mockedAuth, transport.New(transport.Options{}), nil, true, metrics.EventRegenerated},
+		{memory.New(memory.Options{}), &erredAuth, transport.New(transport.Options{}), MockedTestErr, false, metrics.EventErrored},
+		// note: the session is moved even if its new id can't be set on the response
+		{memory.New(memory.Options{}), &mockedAuth, &erredTransport, MockedTestErr, true, metrics.EventErrored},
+		{&mockedStore, &mockedAuth, transport.New(transport.Options{}), store.ErrNotSupported, false, metrics.EventErrored},
+	}
+
+	for idx, tt := range tests {
+		previousUserSession := &user.Session{ID: "previousSessionID", UserID: inputUserID, JSON: inputJSON, ExpiresAt: time.Now().Add(1 * time.Hour).UTC(), CreatedAt: createdAt}
+		tt.store.SaveUserSession(previousUserSession)
+		m := &MockedMetricsType{}
+		s := New(tt.store, tt.auth, tt.transport, Options{Metrics: m})
+
+		regeneratedUserSession := previousUserSession.Copy()
+		w := httptest.NewRecorder()
+		e := s.RegenerateUserSession(regeneratedUserSession, r, w)
+
+		moved := regeneratedUserSession.ID != previousUserSession.ID
+		assertSession := regeneratedUserSession.UserID == previousUserSession.UserID && regeneratedUserSession.JSON == previousUserSession.JSON && regeneratedUserSession.ExpiresAt.Equal(previousUserSession.ExpiresAt) && regeneratedUserSession.CreatedAt.Equal(previousUserSession.CreatedAt)
+		if memoryStore, ok := tt.store.(*memory.Service); ok {
+			previous, _ := memoryStore.FetchValidUserSession(previousUserSession.ID)
+			regenerated, _ := memoryStore.FetchValidUserSession(regeneratedUserSession.ID)
+			assertSession = assertSession && (previous == nil) == moved && regenerated != nil && regenerated.JSON == previousUserSession.JSON
+
+			memoryStore.Close()
+		}
+		cookies := w.Result().Cookies()
+		assertCookie := (len(cookies) == 1 && cookies[0].Value == "test") == (tt.expectedErr == nil)
+
+		if e != tt.expectedErr || moved != tt.expectedMoved || !assertSession || !assertCookie || !reflect.DeepEqual(m.events, []string{tt.expectedEvent}) {
+			t.Errorf("test #%d failed; expected err: %v, expected moved: %t, expected event: %s, received err: %v, received session: %v, received cookies: %v, received events: %v", idx+1, tt.expectedErr, tt.expectedMoved, tt.expectedEvent, e, regeneratedUserSession, cookies, m.events)
+		}
+	}
+}
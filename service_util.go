@@ -1,5 +1,17 @@
 package sessions
 
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/adam-hanna/sessions/auth"
+	"github.com/adam-hanna/sessions/metrics"
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/transport"
+	"github.com/adam-hanna/sessions/user"
+)
+
 // setDefaultOptions sets default values for nil fields
 // note @adam-hanna: this utility function should be improved. The fields and types of the options struct \
 // 			         should not be hardcoded!
@@ -8,6 +20,214 @@ func setDefaultOptions(options *Options) {
 	if options.ExpirationDuration == emptyOptions.ExpirationDuration {
 		options.ExpirationDuration = DefaultExpirationDuration
 	}
+	if options.MaxUpdateRetries == emptyOptions.MaxUpdateRetries {
+		options.MaxUpdateRetries = DefaultMaxUpdateRetries
+	}
+	if options.LockRetryDuration <= emptyOptions.LockRetryDuration {
+		options.LockRetryDuration = DefaultLockRetryDuration
+	}
+	if options.SlidingExpirationThreshold <= emptyOptions.SlidingExpirationThreshold {
+		options.SlidingExpirationThreshold = DefaultSlidingExpirationThreshold
+	}
+	// note: the degraded cache is only used when failing open
+	if options.DegradedPolicy == DegradedPolicyFailOpen {
+		if options.DegradedCacheDuration <= emptyOptions.DegradedCacheDuration {
+			options.DegradedCacheDuration = DefaultDegradedCacheDuration
+		}
+		if options.DegradedCacheMaxEntries <= emptyOptions.DegradedCacheMaxEntries {
+			options.DegradedCacheMaxEntries = DefaultDegradedCacheMaxEntries
+		}
+	}
 
 	return
 }
+
+// idleTimeout returns how long a session stays valid unless it is extended: its own duration, if it has one, or \
+// the service's
+func (s *Service) idleTimeout(userSession *user.Session) time.Duration {
+	if userSession.Duration > 0 {
+		return userSession.Duration
+	}
+	if s.options.IdleTimeoutDuration > 0 {
+		return s.options.IdleTimeoutDuration
+	}
+
+	return s.options.ExpirationDuration
+}
+
+// expiresAt returns the expiry of a session that is issued or extended at now: the idle timeout from now, but no \
+// later than the session's absolute deadline
+func (s *Service) expiresAt(userSession *user.Session, now time.Time) time.Time {
+	expiresAt := now.Add(s.idleTimeout(userSession))
+	if s.options.AbsoluteTimeoutDuration > 0 && !userSession.CreatedAt.IsZero() {
+		if deadline := userSession.CreatedAt.Add(s.options.AbsoluteTimeoutDuration); deadline.Before(expiresAt) {
+			expiresAt = deadline
+		}
+	}
+
+	return expiresAt.UTC()
+}
+
+// reachedAbsoluteTimeout returns whether a session has reached its absolute deadline at now. Sessions without a \
+// CreatedAt have no deadline
+func (s *Service) reachedAbsoluteTimeout(userSession *user.Session, now time.Time) bool {
+	if s.options.AbsoluteTimeoutDuration <= 0 || userSession.CreatedAt.IsZero() {
+		return false
+	}
+
+	return !now.Before(userSession.CreatedAt.Add(s.options.AbsoluteTimeoutDuration))
+}
+
+// saveUserSession saves a session in the store, passing ctx along if the store supports it
+func (s *Service) saveUserSession(ctx context.Context, userSession *user.Session) error {
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		return contextStore.SaveUserSessionContext(ctx, userSession)
+	}
+	return s.store.SaveUserSession(userSession)
+}
+
+// saveIssuedUserSession saves a newly issued session in the store, enforcing Options.MaxSessionsPerUser. If it is \
+// set, the store must implement store.LimitServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) saveIssuedUserSession(ctx context.Context, userSession *user.Session) error {
+	if s.options.MaxSessionsPerUser <= 0 {
+		return s.saveUserSession(ctx, userSession)
+	}
+
+	limitStore, ok := s.store.(store.LimitServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	evict := s.options.SessionLimitPolicy != SessionLimitPolicyReject
+	evictedSessionIDs, err := limitStore.SaveUserSessionWithLimit(ctx, userSession, s.options.MaxSessionsPerUser, evict)
+	for _, sessionID := range evictedSessionIDs {
+		if s.recent != nil {
+			s.recent.remove(sessionID)
+		}
+		s.countEvent(metrics.EventEvicted)
+	}
+
+	return err
+}
+
+// touchUserSession ranks a session as its user's most recently used one, if sessions are evicted by least recent \
+// use
+func (s *Service) touchUserSession(ctx context.Context, userSession *user.Session) {
+	if s.options.MaxSessionsPerUser <= 0 || s.options.SessionLimitPolicy != SessionLimitPolicyEvictLeastRecentlyUsed {
+		return
+	}
+
+	// note: a failed touch only makes the session more likely to be evicted, so the request goes on
+	if limitStore, ok := s.store.(store.LimitServiceInterface); ok {
+		limitStore.TouchUserSession(ctx, userSession)
+	}
+}
+
+// deleteUserSession deletes a session from the store, passing ctx along if the store supports it
+func (s *Service) deleteUserSession(ctx context.Context, sessionID string) error {
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		return contextStore.DeleteUserSessionContext(ctx, sessionID)
+	}
+	return s.store.DeleteUserSession(sessionID)
+}
+
+// fetchValidUserSession fetches a session from the store, passing ctx along if the store supports it
+func (s *Service) fetchValidUserSession(ctx context.Context, sessionID string) (*user.Session, error) {
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		return contextStore.FetchValidUserSessionContext(ctx, sessionID)
+	}
+	return s.store.FetchValidUserSession(sessionID)
+}
+
+// signAndBase64Encode signs a session id, passing ctx along if the auth service supports it
+func (s *Service) signAndBase64Encode(ctx context.Context, sessionID string) (string, error) {
+	if contextAuth, ok := s.auth.(auth.ContextServiceInterface); ok {
+		return contextAuth.SignAndBase64EncodeContext(ctx, sessionID)
+	}
+	return s.auth.SignAndBase64Encode(sessionID)
+}
+
+// verifyAndDecode verifies a signed session id, passing ctx along if the auth service supports it
+func (s *Service) verifyAndDecode(ctx context.Context, signed string) (string, error) {
+	if contextAuth, ok := s.auth.(auth.ContextServiceInterface); ok {
+		return contextAuth.VerifyAndDecodeContext(ctx, signed)
+	}
+	return s.auth.VerifyAndDecode(signed)
+}
+
+// setSessionOnResponse writes a session on a response, passing ctx along if the transport service supports it
+func (s *Service) setSessionOnResponse(ctx context.Context, signedSessionID string, userSession *user.Session, w http.ResponseWriter) error {
+	if contextTransport, ok := s.transport.(transport.ContextServiceInterface); ok {
+		return contextTransport.SetSessionOnResponseContext(ctx, signedSessionID, userSession, w)
+	}
+	return s.transport.SetSessionOnResponse(signedSessionID, userSession, w)
+}
+
+// deleteSessionFromResponse deletes the session from a response, passing ctx along if the transport service \
+// supports it
+func (s *Service) deleteSessionFromResponse(ctx context.Context, w http.ResponseWriter) error {
+	if contextTransport, ok := s.transport.(transport.ContextServiceInterface); ok {
+		return contextTransport.DeleteSessionFromResponseContext(ctx, w)
+	}
+	return s.transport.DeleteSessionFromResponse(w)
+}
+
+// fetchSessionIDFromRequest reads the signed session id from a request, passing ctx along if the transport service \
+// supports it
+func (s *Service) fetchSessionIDFromRequest(ctx context.Context, r *http.Request) (string, error) {
+	if contextTransport, ok := s.transport.(transport.ContextServiceInterface); ok {
+		return contextTransport.FetchSessionIDFromRequestContext(ctx, r)
+	}
+	return s.transport.FetchSessionIDFromRequest(r)
+}
+
+// updateUserSession calls update with a copy of userSession and saves it if the stored session's version did not \
+// change. On a version conflict, the session is fetched again and update is called with the fresh session, up to \
+// Options.MaxUpdateRetries times. A nil pointer is returned if the session no longer exists. The store must \
+// implement store.VersionedServiceInterface.
+func (s *Service) updateUserSession(ctx context.Context, userSession *user.Session, update func(userSession *user.Session) error) (*user.Session, error) {
+	versionedStore := s.store.(store.VersionedServiceInterface)
+
+	// note: work on a copy, so the caller's session is left alone if the update fails
+	updatedUserSession := *userSession.Copy()
+	for attempt := 0; ; attempt++ {
+		version := updatedUserSession.Version
+		if err := update(&updatedUserSession); err != nil {
+			return nil, err
+		}
+
+		err := versionedStore.SaveUserSessionIfVersion(&updatedUserSession, version)
+		if err == nil {
+			if s.recent != nil {
+				s.recent.add(&updatedUserSession)
+			}
+			return &updatedUserSession, nil
+		}
+		if err != store.ErrVersionConflict || attempt >= s.options.MaxUpdateRetries {
+			return nil, err
+		}
+
+		freshUserSession, err := s.fetchValidUserSession(ctx, updatedUserSession.ID)
+		if err != nil || freshUserSession == nil {
+			return nil, err
+		}
+		updatedUserSession = *freshUserSession
+	}
+}
+
+// countEvent counts a session event, if metrics are enabled
+func (s *Service) countEvent(event string) {
+	if s.options.Metrics != nil {
+		s.options.Metrics.CountEvent(event)
+	}
+}
+
+// degradedUserSession returns the session to serve when the store failed to fetch it, according to the degraded \
+// policy. A nil pointer treats the request as if it had no valid session.
+func (s *Service) degradedUserSession(sessionID string) *user.Session {
+	if s.options.DegradedPolicy != DegradedPolicyFailOpen || s.recent == nil {
+		return nil
+	}
+
+	return s.recent.get(sessionID)
+}
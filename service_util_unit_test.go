@@ -14,8 +14,12 @@ func TestSetDefaultOptions(t *testing.T) {
 		input    Options
 		expected Options
 	}{
-		{Options{}, Options{ExpirationDuration: DefaultExpirationDuration}},
-		{Options{ExpirationDuration: 1 * time.Second}, Options{ExpirationDuration: 1 * time.Second}},
+		{Options{}, Options{ExpirationDuration: DefaultExpirationDuration, MaxUpdateRetries: DefaultMaxUpdateRetries, LockRetryDuration: DefaultLockRetryDuration, SlidingExpirationThreshold: DefaultSlidingExpirationThreshold}},
+		{Options{ExpirationDuration: 1 * time.Second}, Options{ExpirationDuration: 1 * time.Second, MaxUpdateRetries: DefaultMaxUpdateRetries, LockRetryDuration: DefaultLockRetryDuration, SlidingExpirationThreshold: DefaultSlidingExpirationThreshold}},
+		{Options{DegradedPolicy: DegradedPolicyFailOpen}, Options{ExpirationDuration: DefaultExpirationDuration, DegradedPolicy: DegradedPolicyFailOpen, DegradedCacheDuration: DefaultDegradedCacheDuration, DegradedCacheMaxEntries: DefaultDegradedCacheMaxEntries, MaxUpdateRetries: DefaultMaxUpdateRetries, LockRetryDuration: DefaultLockRetryDuration, SlidingExpirationThreshold: DefaultSlidingExpirationThreshold}},
+		{Options{MaxUpdateRetries: -1}, Options{ExpirationDuration: DefaultExpirationDuration, MaxUpdateRetries: -1, LockRetryDuration: DefaultLockRetryDuration, SlidingExpirationThreshold: DefaultSlidingExpirationThreshold}},
+		{Options{SlidingExpirationThreshold: 0.25}, Options{ExpirationDuration: DefaultExpirationDuration, MaxUpdateRetries: DefaultMaxUpdateRetries, LockRetryDuration: DefaultLockRetryDuration, SlidingExpirationThreshold: 0.25}},
+		{Options{LockRetryDuration: 1 * time.Second}, Options{ExpirationDuration: DefaultExpirationDuration, MaxUpdateRetries: DefaultMaxUpdateRetries, LockRetryDuration: 1 * time.Second, SlidingExpirationThreshold: DefaultSlidingExpirationThreshold}},
 	}
 
 	for idx, tt := range tests {
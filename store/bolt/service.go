@@ -0,0 +1,507 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+	bbolt "go.etcd.io/bbolt"
+)
+
+const (
+	// DefaultFileMode sets the default file mode of the database file
+	DefaultFileMode os.FileMode = 0600
+	// DefaultOpenTimeoutDuration sets the default duration to wait for the database file lock
+	DefaultOpenTimeoutDuration = 1 * time.Second
+	// DefaultSweepIntervalDuration sets the default duration between sweeps of expired sessions
+	DefaultSweepIntervalDuration = 1 * time.Minute
+)
+
+var (
+	// sessionsBucket maps session ids to encoded sessions
+	sessionsBucket = []byte("sessions")
+	// expiryBucket indexes session ids by expiry. Keys are the big-endian expiry in unix seconds followed by \
+	// the session id, so a cursor walks the index from the oldest expiry forward
+	expiryBucket = []byte("expiry")
+	// usersBucket indexes session ids by user. Keys are the big-endian length of the user id, the user id and \
+	// the session id, so a cursor can seek to all of a user's sessions
+	usersBucket = []byte("users")
+)
+
+// Service is a session store backed by a single-file bbolt database
+type Service struct {
+	// DB is the underlying *bbolt.DB
+	DB *bbolt.DB
+
+	locks store.LocalLocks
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+// Options defines the behavior of the session store
+type Options struct {
+	FileMode os.FileMode
+	// OpenTimeoutDuration is the duration to wait for the lock on the database file. Only one process may open \
+	// the file at a time
+	OpenTimeoutDuration time.Duration
+	// SweepIntervalDuration is the duration between background sweeps that delete expired sessions
+	SweepIntervalDuration time.Duration
+}
+
+// record is the encoded form of a session in the sessions bucket
+type record struct {
+	UserID           string
+	JSON             string
+	ExpiresAtSeconds int64
+	// CreatedAtSeconds is 0 for sessions that were saved without a creation time
+	CreatedAtSeconds int64
+	DurationSeconds  int64
+	BrowserSession   bool
+	Version          int64
+	Data             map[string]string
+}
+
+// New opens, or creates, the database file at path and starts the background expiry sweeper.
+// Close should be called to stop the sweeper and close the database file.
+func New(path string, options Options) (*Service, error) {
+	setDefaultOptions(&options)
+
+	db, err := bbolt.Open(path, options.FileMode, &bbolt.Options{Timeout: options.OpenTimeoutDuration})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{sessionsBucket, expiryBucket, usersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &Service{
+		DB:   db,
+		done: make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.sweep(options.SweepIntervalDuration)
+
+	return s, nil
+}
+
+// SaveUserSession saves a user session in the store and increments its version
+func (s *Service) SaveUserSession(userSession *user.Session) error {
+	return s.DB.Update(func(tx *bbolt.Tx) error {
+		return saveSession(tx, userSession)
+	})
+}
+
+// SaveUserSessionIfVersion saves a user session in the store, like SaveUserSession, but only if the stored \
+// session's version is version. Otherwise, store.ErrVersionConflict is returned. See \
+// store.VersionedServiceInterface.
+func (s *Service) SaveUserSessionIfVersion(userSession *user.Session, version int64) error {
+	return s.DB.Update(func(tx *bbolt.Tx) error {
+		storedVersion, err := getVersion(tx, userSession.ID)
+		if err != nil {
+			return err
+		}
+		if storedVersion != version {
+			return store.ErrVersionConflict
+		}
+
+		return saveSession(tx, userSession)
+	})
+}
+
+// SetUserSessionField sets a named field of a stored session's Data and increments its version. If the session \
+// does not exist, store.ErrSessionNotFound is returned. See store.FieldServiceInterface.
+func (s *Service) SetUserSessionField(sessionID string, name string, value string) error {
+	return s.DB.Update(func(tx *bbolt.Tx) error {
+		return updateField(tx, sessionID, func(rec *record) {
+			if rec.Data == nil {
+				rec.Data = make(map[string]string)
+			}
+			rec.Data[name] = value
+		})
+	})
+}
+
+// DeleteUserSessionField deletes a named field from a stored session's Data and increments its version. If the \
+// session does not exist, store.ErrSessionNotFound is returned. See store.FieldServiceInterface.
+func (s *Service) DeleteUserSessionField(sessionID string, name string) error {
+	return s.DB.Update(func(tx *bbolt.Tx) error {
+		return updateField(tx, sessionID, func(rec *record) {
+			delete(rec.Data, name)
+		})
+	})
+}
+
+// RegenerateUserSession saves userSession, which carries a new id, and deletes the session with sessionID, in one \
+// transaction. See store.RegenerateServiceInterface.
+func (s *Service) RegenerateUserSession(ctx context.Context, sessionID string, userSession *user.Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.DB.Update(func(tx *bbolt.Tx) error {
+		if err := saveSession(tx, userSession); err != nil {
+			return err
+		}
+
+		return deleteSession(tx, sessionID)
+	})
+}
+
+// LockUserSession acquires the lock of a session for ttl, and returns the lock's fencing token. Locks are held in \
+// process memory, so they only exclude the requests of this process, which is the only one that can open the \
+// database file. See store.LockServiceInterface.
+func (s *Service) LockUserSession(ctx context.Context, sessionID string, ttl time.Duration) (int64, error) {
+	return s.locks.LockUserSession(ctx, sessionID, ttl)
+}
+
+// UnlockUserSession releases the lock of a session if it is still held with token. See store.LockServiceInterface.
+func (s *Service) UnlockUserSession(ctx context.Context, sessionID string, token int64) error {
+	return s.locks.UnlockUserSession(ctx, sessionID, token)
+}
+
+// DeleteUserSession deletes a user session from the store
+func (s *Service) DeleteUserSession(sessionID string) error {
+	return s.DB.Update(func(tx *bbolt.Tx) error {
+		return deleteSession(tx, sessionID)
+	})
+}
+
+// FetchValidUserSession returns a valid user session or an err if the session has expired or does not exist. \
+// If a valid session does not exist, this function should return a nil pointer
+func (s *Service) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	var rec *record
+	if err := s.DB.View(func(tx *bbolt.Tx) error {
+		var err error
+		rec, err = getRecord(tx, sessionID)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	// note: the sweeper may not have deleted an expired session, yet
+	if rec == nil || rec.ExpiresAtSeconds <= time.Now().Unix() {
+		return nil, nil
+	}
+
+	return rec.userSession(sessionID), nil
+}
+
+// DeleteExpired deletes all expired sessions from the store and returns the number of deleted sessions
+func (s *Service) DeleteExpired() (int, error) {
+	var deleted int
+	nowSeconds := time.Now().Unix()
+
+	err := s.DB.Update(func(tx *bbolt.Tx) error {
+		// note: the index is ordered by expiry, so we can stop at the first unexpired session. Keys are \
+		// collected first because deleting while iterating a cursor can skip keys
+		var sessionIDs []string
+		c := tx.Bucket(expiryBucket).Cursor()
+		for k, _ := c.First(); k != nil && expiresAtSeconds(k) <= nowSeconds; k, _ = c.Next() {
+			sessionIDs = append(sessionIDs, string(k[8:]))
+		}
+
+		for _, sessionID := range sessionIDs {
+			if err := deleteSession(tx, sessionID); err != nil {
+				return err
+			}
+		}
+		deleted = len(sessionIDs)
+
+		return nil
+	})
+
+	return deleted, err
+}
+
+// ListUserSessions returns all valid sessions of a user
+func (s *Service) ListUserSessions(userID string) ([]*user.Session, error) {
+	nowSeconds := time.Now().Unix()
+	userSessions := []*user.Session{}
+
+	err := s.DB.View(func(tx *bbolt.Tx) error {
+		prefix := userKey(userID, "")
+		c := tx.Bucket(usersBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			sessionID := string(k[len(prefix):])
+			rec, err := getRecord(tx, sessionID)
+			if err != nil {
+				return err
+			}
+			if rec == nil || rec.ExpiresAtSeconds <= nowSeconds {
+				continue
+			}
+
+			userSessions = append(userSessions, rec.userSession(sessionID))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return userSessions, nil
+}
+
+// DeleteAllUserSessions deletes all sessions of a user
+func (s *Service) DeleteAllUserSessions(userID string) error {
+	return s.DB.Update(func(tx *bbolt.Tx) error {
+		var sessionIDs []string
+		prefix := userKey(userID, "")
+		c := tx.Bucket(usersBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			sessionIDs = append(sessionIDs, string(k[len(prefix):]))
+		}
+
+		for _, sessionID := range sessionIDs {
+			if err := deleteSession(tx, sessionID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Scan returns up to count valid sessions, in session id order, starting after the session id cursor, and the \
+// cursor of the next batch. See store.ScanServiceInterface.
+func (s *Service) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	if count <= 0 {
+		count = store.DefaultScanCount
+	}
+
+	nowSeconds := time.Now().Unix()
+	userSessions := []*user.Session{}
+	var next string
+
+	err := s.DB.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(sessionsBucket).Cursor()
+		k, v := c.Seek([]byte(cursor))
+		// note: the cursor is the last session id of the previous batch
+		if k != nil && cursor != "" && string(k) == cursor {
+			k, v = c.Next()
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			if len(userSessions) == count {
+				next = userSessions[len(userSessions)-1].ID
+				return nil
+			}
+
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if rec.ExpiresAtSeconds <= nowSeconds {
+				continue
+			}
+
+			userSessions = append(userSessions, rec.userSession(string(k)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return userSessions, next, nil
+}
+
+// Ping returns an error if the database file has been closed
+func (s *Service) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.DB.View(func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
+
+// Close stops the background expiry sweeper and closes the database file
+func (s *Service) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+
+	return s.DB.Close()
+}
+
+// sweep periodically deletes expired sessions until the store is closed
+func (s *Service) sweep(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			// note: a failed sweep is retried on the next tick
+			s.DeleteExpired()
+		}
+	}
+}
+
+// getRecord returns the decoded session with sessionID, or a nil pointer if it does not exist
+func getRecord(tx *bbolt.Tx, sessionID string) (*record, error) {
+	value := tx.Bucket(sessionsBucket).Get([]byte(sessionID))
+	if value == nil {
+		return nil, nil
+	}
+
+	rec := &record{}
+	if err := json.Unmarshal(value, rec); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// userSession returns the session with sessionID that the record encodes
+func (rec *record) userSession(sessionID string) *user.Session {
+	return &user.Session{
+		ID:             sessionID,
+		UserID:         rec.UserID,
+		JSON:           rec.JSON,
+		ExpiresAt:      time.Unix(rec.ExpiresAtSeconds, 0),
+		CreatedAt:      store.CreatedAtTime(rec.CreatedAtSeconds),
+		Duration:       time.Duration(rec.DurationSeconds) * time.Second,
+		BrowserSession: rec.BrowserSession,
+		Version:        rec.Version,
+		Data:           rec.Data,
+	}
+}
+
+// saveSession stores a session with its incremented version, and its index entries
+func saveSession(tx *bbolt.Tx, userSession *user.Session) error {
+	version, err := getVersion(tx, userSession.ID)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(record{
+		UserID:           userSession.UserID,
+		JSON:             userSession.JSON,
+		ExpiresAtSeconds: userSession.ExpiresAt.Unix(),
+		CreatedAtSeconds: store.CreatedAtSeconds(userSession.CreatedAt),
+		DurationSeconds:  int64(userSession.Duration / time.Second),
+		BrowserSession:   userSession.BrowserSession,
+		Version:          version + 1,
+		Data:             userSession.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	// note: the previous expiry index entry must be removed, or the sweeper would delete the re-saved session
+	if err := deleteSession(tx, userSession.ID); err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(sessionsBucket).Put([]byte(userSession.ID), value); err != nil {
+		return err
+	}
+	if err := tx.Bucket(usersBucket).Put(userKey(userSession.UserID, userSession.ID), nil); err != nil {
+		return err
+	}
+	if err := tx.Bucket(expiryBucket).Put(expiryKey(userSession.ExpiresAt.Unix(), userSession.ID), nil); err != nil {
+		return err
+	}
+
+	// note: the transaction may still be rolled back, but then its error is returned to the caller, too
+	userSession.Version = version + 1
+	return nil
+}
+
+// updateField calls update with a stored session's record and stores it with its incremented version. The \
+// session's expiry and user don't change, so its index entries are kept
+func updateField(tx *bbolt.Tx, sessionID string, update func(rec *record)) error {
+	rec, err := getRecord(tx, sessionID)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.ExpiresAtSeconds <= time.Now().Unix() {
+		return store.ErrSessionNotFound
+	}
+
+	update(rec)
+	rec.Version++
+
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(sessionsBucket).Put([]byte(sessionID), value)
+}
+
+// getVersion returns the version of a stored session, or 0 if it does not exist or has expired
+func getVersion(tx *bbolt.Tx, sessionID string) (int64, error) {
+	rec, err := getRecord(tx, sessionID)
+	if err != nil || rec == nil || rec.ExpiresAtSeconds <= time.Now().Unix() {
+		return 0, err
+	}
+
+	return rec.Version, nil
+}
+
+// deleteSession deletes a session and its index entries
+func deleteSession(tx *bbolt.Tx, sessionID string) error {
+	rec, err := getRecord(tx, sessionID)
+	if err != nil || rec == nil {
+		return err
+	}
+
+	if err := tx.Bucket(expiryBucket).Delete(expiryKey(rec.ExpiresAtSeconds, sessionID)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(usersBucket).Delete(userKey(rec.UserID, sessionID)); err != nil {
+		return err
+	}
+	return tx.Bucket(sessionsBucket).Delete([]byte(sessionID))
+}
+
+// expiryKey returns the expiry index key of a session
+func expiryKey(expiresAtSeconds int64, sessionID string) []byte {
+	key := make([]byte, 8+len(sessionID))
+	binary.BigEndian.PutUint64(key, uint64(expiresAtSeconds))
+	copy(key[8:], sessionID)
+	return key
+}
+
+// userKey returns the user index key of a session. With an empty sessionID, it returns the prefix shared by \
+// all of the user's index keys
+func userKey(userID string, sessionID string) []byte {
+	key := make([]byte, 4+len(userID)+len(sessionID))
+	binary.BigEndian.PutUint32(key, uint32(len(userID)))
+	copy(key[4:], userID)
+	copy(key[4+len(userID):], sessionID)
+	return key
+}
+
+// expiresAtSeconds returns the expiry of an expiry index key
+func expiresAtSeconds(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key[:8]))
+}
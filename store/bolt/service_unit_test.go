@@ -0,0 +1,431 @@
+// +build unit
+
+package bolt
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// note: this will fail to compile if the bolt store does not implement the store interfaces
+var (
+	_ store.ServiceInterface           = (*Service)(nil)
+	_ store.ScanServiceInterface       = (*Service)(nil)
+	_ store.VersionedServiceInterface  = (*Service)(nil)
+	_ store.FieldServiceInterface      = (*Service)(nil)
+	_ store.LockServiceInterface       = (*Service)(nil)
+	_ store.HealthServiceInterface     = (*Service)(nil)
+	_ store.RegenerateServiceInterface = (*Service)(nil)
+)
+
+var (
+	validUserSession = &user.Session{
+		ID:        "validSessionID",
+		UserID:    "validUserID",
+		JSON:      "validJSON",
+		ExpiresAt: time.Unix(time.Now().Add(1*time.Hour).Unix(), 0),
+	}
+	expiredUserSession = &user.Session{
+		ID:        "expiredSessionID",
+		UserID:    "expiredUserID",
+		JSON:      "expiredJSON",
+		ExpiresAt: time.Unix(time.Now().Add(-100*time.Hour).Unix(), 0),
+	}
+)
+
+// newTestService returns a store backed by a file in a new temporary directory, and a func to clean it up
+func newTestService(t *testing.T) (*Service, func()) {
+	dir, err := ioutil.TempDir("", "sessions-bolt")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+
+	s, err := New(filepath.Join(dir, "sessions.db"), Options{})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("could not create store: %v", err)
+	}
+
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestNew tests that sessions survive closing and re-opening the database file
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions-bolt")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sessions.db")
+
+	s, err := New(path, Options{})
+	if err != nil {
+		t.Fatalf("test failed; expected err to be nil, received err: %v", err)
+	}
+	s.SaveUserSession(validUserSession)
+	s.Close()
+
+	s, err = New(path, Options{})
+	if err != nil {
+		t.Fatalf("test failed; expected err to be nil, received err: %v", err)
+	}
+	defer s.Close()
+
+	a, e := s.FetchValidUserSession(validUserSession.ID)
+	if e != nil || a == nil || !reflect.DeepEqual(*a, *validUserSession) {
+		t.Errorf("test failed; expected session: %v, received session: %v, received err: %v", validUserSession, a, e)
+	}
+}
+
+// TestFetchValidUserSession tests the SaveUserSession and FetchValidUserSession functions
+func TestFetchValidUserSession(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	s.SaveUserSession(validUserSession)
+	s.SaveUserSession(expiredUserSession)
+
+	tests := []struct {
+		input               string
+		expectedUserSession *user.Session
+		expectedErr         error
+	}{
+		{validUserSession.ID, validUserSession, nil},
+		{expiredUserSession.ID, nil, nil},
+		{"missingSessionID", nil, nil},
+	}
+
+	for idx, tt := range tests {
+		a, e := s.FetchValidUserSession(tt.input)
+		assertErr := e == tt.expectedErr
+		var assertUserSession bool
+		if a == nil {
+			assertUserSession = tt.expectedUserSession == nil
+		} else {
+			assertUserSession = tt.expectedUserSession != nil && reflect.DeepEqual(*a, *tt.expectedUserSession)
+		}
+
+		if !assertErr || !assertUserSession {
+			t.Errorf("test #%d failed; assert err: %t, assert user session: %t, received err: %v, received user session: %v, expected err: %v, expected user session: %v, input: %v\n", idx+1, assertErr, assertUserSession, e, a, tt.expectedErr, tt.expectedUserSession, tt.input)
+		}
+	}
+}
+
+// TestDeleteUserSession tests the DeleteUserSession function
+func TestDeleteUserSession(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	s.SaveUserSession(validUserSession)
+
+	tests := []struct {
+		input         string
+		expectToExist bool
+	}{
+		{validUserSession.ID, false},
+		{"missingSessionID", false},
+	}
+
+	for idx, tt := range tests {
+		if e := s.DeleteUserSession(tt.input); e != nil {
+			t.Errorf("Err in test #%d when deleting user session, expected err to be nil, received err: %v, input: %s\n", idx+1, e, tt.input)
+		}
+
+		a, _ := s.FetchValidUserSession(tt.input)
+		exists := a != nil
+		if exists != tt.expectToExist {
+			t.Errorf("test #%d failed; expected exists: %t, received exists: %t, input: %s\n", idx+1, tt.expectToExist, exists, tt.input)
+		}
+	}
+}
+
+// TestDeleteExpired tests the DeleteExpired function
+func TestDeleteExpired(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	s.SaveUserSession(validUserSession)
+	s.SaveUserSession(expiredUserSession)
+
+	// note: re-saving a session with a new expiry must move its expiry index entry
+	resavedUserSession := *expiredUserSession
+	resavedUserSession.ID = "resavedSessionID"
+	s.SaveUserSession(&resavedUserSession)
+	resavedUserSession.ExpiresAt = validUserSession.ExpiresAt
+	s.SaveUserSession(&resavedUserSession)
+
+	n, e := s.DeleteExpired()
+	if e != nil || n != 1 {
+		t.Errorf("test failed; expected 1 deleted session and a nil err, received: %d, err: %v", n, e)
+	}
+
+	for _, sessionID := range []string{validUserSession.ID, resavedUserSession.ID} {
+		if a, _ := s.FetchValidUserSession(sessionID); a == nil {
+			t.Errorf("test failed; expected session %s to exist after DeleteExpired", sessionID)
+		}
+	}
+}
+
+// TestListUserSessions tests the ListUserSessions and DeleteAllUserSessions functions
+func TestListUserSessions(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	// note: user ids that are prefixes of each other must not be confused
+	otherUserSession := &user.Session{
+		ID:        "otherSessionID",
+		UserID:    validUserSession.UserID + "Other",
+		ExpiresAt: validUserSession.ExpiresAt,
+	}
+	s.SaveUserSession(validUserSession)
+	s.SaveUserSession(otherUserSession)
+
+	a, e := s.ListUserSessions(validUserSession.UserID)
+	if e != nil || len(a) != 1 || !reflect.DeepEqual(*a[0], *validUserSession) {
+		t.Errorf("test failed; expected sessions: [%v], received sessions: %v, received err: %v", validUserSession, a, e)
+	}
+
+	if e := s.DeleteAllUserSessions(validUserSession.UserID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting all user sessions, received: %v", e)
+	}
+
+	if a, _ := s.ListUserSessions(validUserSession.UserID); len(a) != 0 {
+		t.Errorf("test failed; expected no sessions after deleting all user sessions, received: %v", a)
+	}
+	if a, _ := s.FetchValidUserSession(otherUserSession.ID); a == nil {
+		t.Errorf("test failed; expected other user's session to remain after deleting all user sessions")
+	}
+}
+
+// TestScan tests that scanning returns every valid session
+func TestScan(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	for idx := 0; idx < 25; idx++ {
+		s.SaveUserSession(&user.Session{ID: fmt.Sprintf("sessionID%02d", idx), UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)})
+	}
+	s.SaveUserSession(expiredUserSession)
+
+	var tests = []struct {
+		inputCount       int
+		expectedSessions int
+	}{
+		{0, 25},
+		{1, 25},
+		{10, 25},
+		{100, 25},
+	}
+
+	for idx, tt := range tests {
+		scanned := make(map[string]bool)
+		var cursor string
+		var batches int
+		for {
+			userSessions, next, err := s.Scan(cursor, tt.inputCount)
+			if err != nil {
+				t.Fatalf("test #%d failed; expected err to be nil, received: %v", idx+1, err)
+			}
+			for _, userSession := range userSessions {
+				scanned[userSession.ID] = true
+			}
+
+			batches++
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		if len(scanned) != tt.expectedSessions || scanned[expiredUserSession.ID] {
+			t.Errorf("test #%d failed; input count: %d, expected sessions: %d, received: %d in %d batches", idx+1, tt.inputCount, tt.expectedSessions, len(scanned), batches)
+		}
+	}
+}
+
+// TestSaveUserSessionIfVersion tests that a session is only saved if its stored version matches
+func TestSaveUserSessionIfVersion(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	userSession := &user.Session{ID: "versionedSessionID", UserID: "userID", JSON: "json", ExpiresAt: time.Unix(time.Now().Add(1*time.Hour).Unix(), 0)}
+
+	var tests = []struct {
+		inputVersion    int64
+		expectedErr     error
+		expectedVersion int64
+	}{
+		{1, store.ErrVersionConflict, 0},
+		{0, nil, 1},
+		{0, store.ErrVersionConflict, 1},
+		{1, nil, 2},
+	}
+
+	for idx, tt := range tests {
+		e := s.SaveUserSessionIfVersion(userSession, tt.inputVersion)
+
+		var version int64
+		if a, _ := s.FetchValidUserSession(userSession.ID); a != nil {
+			version = a.Version
+		}
+
+		if e != tt.expectedErr || version != tt.expectedVersion {
+			t.Errorf("test #%d failed; input version: %d, expected err: %v, expected version: %d, received err: %v, received version: %d", idx+1, tt.inputVersion, tt.expectedErr, tt.expectedVersion, e, version)
+		}
+	}
+
+	if e := s.SaveUserSession(userSession); e != nil || userSession.Version != 3 {
+		t.Errorf("test failed; expected saving to increment the version to 3, received: %d, err: %v", userSession.Version, e)
+	}
+}
+
+// TestUserSessionFields tests that data fields are saved, and set and deleted one by one
+func TestUserSessionFields(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	userSession := &user.Session{ID: "fieldsSessionID", UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	userSession.Set("cart", "1")
+	s.SaveUserSession(userSession)
+
+	var tests = []struct {
+		input           func() error
+		expectedErr     error
+		expectedData    map[string]string
+		expectedVersion int64
+	}{
+		{func() error { return nil }, nil, map[string]string{"cart": "1"}, 1},
+		{func() error { return s.SetUserSessionField(userSession.ID, "theme", "dark") }, nil, map[string]string{"cart": "1", "theme": "dark"}, 2},
+		{func() error { return s.DeleteUserSessionField(userSession.ID, "cart") }, nil, map[string]string{"theme": "dark"}, 3},
+		{func() error { return s.SetUserSessionField("missingSessionID", "cart", "1") }, store.ErrSessionNotFound, map[string]string{"theme": "dark"}, 3},
+		{func() error { return s.DeleteUserSessionField("missingSessionID", "cart") }, store.ErrSessionNotFound, map[string]string{"theme": "dark"}, 3},
+	}
+
+	for idx, tt := range tests {
+		e := tt.input()
+		a, _ := s.FetchValidUserSession(userSession.ID)
+
+		if e != tt.expectedErr || a == nil || !reflect.DeepEqual(a.Data, tt.expectedData) || a.Version != tt.expectedVersion {
+			t.Errorf("test #%d failed; expected err: %v, expected data: %v, expected version: %d, received err: %v, received session: %v", idx+1, tt.expectedErr, tt.expectedData, tt.expectedVersion, e, a)
+		}
+	}
+
+	// note: the index entries are kept, so the session is still listed
+	if userSessions, e := s.ListUserSessions(userSession.UserID); e != nil || len(userSessions) != 1 || userSessions[0].Data["theme"] != "dark" {
+		t.Errorf("test failed; expected 1 listed session, received: %v, err: %v", userSessions, e)
+	}
+}
+
+// TestPing tests that pinging fails once the database file is closed
+func TestPing(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	if e := s.Ping(context.Background()); e != nil {
+		t.Errorf("test failed; expected err to be nil, received: %v", e)
+	}
+
+	s.Close()
+	if e := s.Ping(context.Background()); e == nil {
+		t.Errorf("test failed; expected an err after closing the store")
+	}
+}
+
+// TestCreatedAt tests that the creation time of a session is stored, and that sessions without one keep none
+func TestCreatedAt(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	var tests = []struct {
+		input time.Time
+	}{
+		{time.Unix(time.Now().Add(-1*time.Hour).Unix(), 0)},
+		{time.Time{}},
+	}
+
+	for idx, tt := range tests {
+		userSession := &user.Session{ID: "createdAtSessionID", UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour), CreatedAt: tt.input}
+		s.SaveUserSession(userSession)
+
+		a, e := s.FetchValidUserSession(userSession.ID)
+		if e != nil || a == nil || !a.CreatedAt.Equal(tt.input) {
+			t.Errorf("test #%d failed; expected created at: %v, received session: %v, received err: %v", idx+1, tt.input, a, e)
+		}
+	}
+}
+
+// TestDuration tests that the duration and cookie kind of a session are stored
+func TestDuration(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	var tests = []struct {
+		duration       time.Duration
+		browserSession bool
+	}{
+		{0, false},
+		{30 * 24 * time.Hour, false},
+		{5 * time.Minute, true},
+	}
+
+	for idx, tt := range tests {
+		userSession := &user.Session{ID: "durationSessionID", UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour), Duration: tt.duration, BrowserSession: tt.browserSession}
+		s.SaveUserSession(userSession)
+
+		a, e := s.FetchValidUserSession(userSession.ID)
+		if e != nil || a == nil || a.Duration != tt.duration || a.BrowserSession != tt.browserSession {
+			t.Errorf("test #%d failed; expected duration: %v, expected browser session: %t, received session: %v, received err: %v", idx+1, tt.duration, tt.browserSession, a, e)
+		}
+	}
+}
+
+// TestRegenerateUserSession tests that a session is moved to its new id, and that its old id is deleted
+func TestRegenerateUserSession(t *testing.T) {
+	s, cleanup := newTestService(t)
+	defer cleanup()
+
+	oldUserSession := &user.Session{ID: "oldSessionID", UserID: "regeneratedUserID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	s.SaveUserSession(oldUserSession)
+
+	newUserSession := *oldUserSession
+	newUserSession.ID = "newSessionID"
+	if e := s.RegenerateUserSession(context.Background(), oldUserSession.ID, &newUserSession); e != nil {
+		t.Fatalf("test failed; expected err to be nil, received: %v", e)
+	}
+
+	var tests = []struct {
+		input        string
+		expectedJSON string
+	}{
+		{oldUserSession.ID, ""},
+		{newUserSession.ID, oldUserSession.JSON},
+	}
+
+	for idx, tt := range tests {
+		a, e := s.FetchValidUserSession(tt.input)
+		var json string
+		if a != nil {
+			json = a.JSON
+		}
+
+		if e != nil || json != tt.expectedJSON {
+			t.Errorf("test #%d failed; input: %s, expected json: %s, received json: %s, received err: %v", idx+1, tt.input, tt.expectedJSON, json, e)
+		}
+	}
+
+	if userSessions, _ := s.ListUserSessions(oldUserSession.UserID); len(userSessions) != 1 || userSessions[0].ID != newUserSession.ID {
+		t.Errorf("test failed; expected only the new session to be listed, received: %v", userSessions)
+	}
+}
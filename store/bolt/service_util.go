@@ -0,0 +1,17 @@
+package bolt
+
+// setDefaultOptions sets default values for nil fields
+func setDefaultOptions(options *Options) {
+	emptyOptions := Options{}
+	if options.FileMode == emptyOptions.FileMode {
+		options.FileMode = DefaultFileMode
+	}
+	if options.OpenTimeoutDuration <= emptyOptions.OpenTimeoutDuration {
+		options.OpenTimeoutDuration = DefaultOpenTimeoutDuration
+	}
+	if options.SweepIntervalDuration <= emptyOptions.SweepIntervalDuration {
+		options.SweepIntervalDuration = DefaultSweepIntervalDuration
+	}
+
+	return
+}
@@ -0,0 +1,29 @@
+// +build unit
+
+package bolt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSetDefaultOptions tests the setDefaultOptions function
+func TestSetDefaultOptions(t *testing.T) {
+	var tests = []struct {
+		input    Options
+		expected Options
+	}{
+		{Options{}, Options{FileMode: DefaultFileMode, OpenTimeoutDuration: DefaultOpenTimeoutDuration, SweepIntervalDuration: DefaultSweepIntervalDuration}},
+		{Options{FileMode: 0644, OpenTimeoutDuration: 1 * time.Second, SweepIntervalDuration: 1 * time.Second}, Options{FileMode: 0644, OpenTimeoutDuration: 1 * time.Second, SweepIntervalDuration: 1 * time.Second}},
+	}
+
+	for idx, tt := range tests {
+		setDefaultOptions(&tt.input)
+		assert := reflect.DeepEqual(tt.expected, tt.input)
+
+		if !assert {
+			t.Errorf("test #%d failed; assert: %t, expected: %v, received: %v\n", idx+1, assert, tt.expected, tt.input)
+		}
+	}
+}
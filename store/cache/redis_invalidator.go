@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultInvalidationChannel is the default redis pub/sub channel invalidations are published on
+	DefaultInvalidationChannel = "sessions:invalidations"
+	// redisInvalidatorRetryDuration is the duration to wait before re-subscribing after the subscription failed
+	redisInvalidatorRetryDuration = 1 * time.Second
+)
+
+// RedisInvalidator broadcasts session invalidations over a redis pub/sub channel
+type RedisInvalidator struct {
+	pool       *redis.Pool
+	channel    string
+	instanceID string
+
+	mu     sync.Mutex
+	psc    *redis.PubSubConn
+	closed bool
+	done   chan struct{}
+}
+
+// NewRedisInvalidator returns an invalidator that publishes on, and subscribes to, channel using connections from \
+// pool. If channel is empty, DefaultInvalidationChannel is used. Listening holds one connection of the pool.
+func NewRedisInvalidator(pool *redis.Pool, channel string) *RedisInvalidator {
+	if channel == "" {
+		channel = DefaultInvalidationChannel
+	}
+
+	return &RedisInvalidator{
+		pool:       pool,
+		channel:    channel,
+		instanceID: uuid.New().String(),
+		done:       make(chan struct{}),
+	}
+}
+
+// Publish notifies the other instances that a session was saved or deleted
+func (i *RedisInvalidator) Publish(sessionID string) error {
+	c := i.pool.Get()
+	defer c.Close()
+
+	// note: messages are prefixed with the instance id, so an instance can ignore its own invalidations
+	_, err := c.Do("PUBLISH", i.channel, i.instanceID+":"+sessionID)
+	return err
+}
+
+// Listen calls invalidate with the id of every session published by another instance, until Close is called. If \
+// the subscription fails, Listen re-subscribes and calls invalidate with an empty session id, since messages may \
+// have been missed in the meantime.
+func (i *RedisInvalidator) Listen(invalidate func(sessionID string)) {
+	for {
+		i.subscribe(invalidate)
+
+		select {
+		case <-i.done:
+			return
+		case <-time.After(redisInvalidatorRetryDuration):
+		}
+	}
+}
+
+// Close stops listening
+func (i *RedisInvalidator) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+	close(i.done)
+
+	// note: unsubscribing makes the blocked Receive in subscribe return
+	if i.psc != nil {
+		return i.psc.Unsubscribe()
+	}
+
+	return nil
+}
+
+// subscribe subscribes to the channel and handles messages until the subscription ends
+func (i *RedisInvalidator) subscribe(invalidate func(sessionID string)) {
+	psc := &redis.PubSubConn{Conn: i.pool.Get()}
+	defer psc.Close()
+
+	i.mu.Lock()
+	if i.closed {
+		i.mu.Unlock()
+		return
+	}
+	if err := psc.Subscribe(i.channel); err != nil {
+		i.mu.Unlock()
+		return
+	}
+	i.psc = psc
+	i.mu.Unlock()
+
+	defer func() {
+		i.mu.Lock()
+		i.psc = nil
+		i.mu.Unlock()
+	}()
+
+	for {
+		// note: wait for messages without the connection's read timeout, e.g. store.Options.ReadTimeoutDuration
+		switch v := psc.ReceiveWithTimeout(0).(type) {
+		case redis.Message:
+			parts := strings.SplitN(string(v.Data), ":", 2)
+			if len(parts) == 2 && parts[0] != i.instanceID {
+				invalidate(parts[1])
+			}
+		case redis.Subscription:
+			switch {
+			case v.Kind == "subscribe":
+				// note: anything published before we (re-)subscribed has been missed
+				invalidate("")
+			case v.Count == 0:
+				return
+			}
+		case error:
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// +build integration
+
+package cache
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/gomodule/redigo/redis"
+)
+
+// TestRedisInvalidator tests that invalidations are delivered to the other instances only
+func TestRedisInvalidator(t *testing.T) {
+	// note: like the redis store, fall back to the default address
+	address := os.Getenv("REDIS_URL")
+	if address == "" {
+		address = store.DefaultConnectionAddress
+	}
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address)
+		},
+	}
+	defer pool.Close()
+
+	publisher := NewRedisInvalidator(pool, "sessions:invalidations:test")
+	subscriber := NewRedisInvalidator(pool, "sessions:invalidations:test")
+
+	publisherIDs := make(chan string, 10)
+	subscriberIDs := make(chan string, 10)
+	go publisher.Listen(func(sessionID string) { publisherIDs <- sessionID })
+	go subscriber.Listen(func(sessionID string) { subscriberIDs <- sessionID })
+
+	// note: an empty id is sent once subscribed
+	for _, ids := range []chan string{publisherIDs, subscriberIDs} {
+		select {
+		case id := <-ids:
+			if id != "" {
+				t.Errorf("test failed; expected empty session id on subscribe, received: %s", id)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("test failed; timed out waiting for subscription")
+		}
+	}
+
+	if e := publisher.Publish("sessionID"); e != nil {
+		t.Fatalf("test failed; expected err to be nil when publishing, received: %v", e)
+	}
+
+	select {
+	case id := <-subscriberIDs:
+		if id != "sessionID" {
+			t.Errorf("test failed; expected session id: sessionID, received: %s", id)
+		}
+	case <-time.After(1 * time.Second):
+		t.Errorf("test failed; timed out waiting for invalidation")
+	}
+
+	select {
+	case id := <-publisherIDs:
+		t.Errorf("test failed; expected publisher to ignore its own invalidation, received: %s", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if e := publisher.Close(); e != nil {
+		t.Errorf("test failed; expected err to be nil when closing the publisher, received: %v", e)
+	}
+	if e := subscriber.Close(); e != nil {
+		t.Errorf("test failed; expected err to be nil when closing the subscriber, received: %v", e)
+	}
+}
@@ -0,0 +1,492 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+const (
+	// DefaultMaxEntries sets the default maximum number of sessions kept in the cache
+	DefaultMaxEntries = 10000
+	// DefaultMaxAgeDuration sets the default maximum duration a session is served from the cache before it is \
+	// fetched from the store, again
+	DefaultMaxAgeDuration = 1 * time.Minute
+)
+
+// Service is a session store that caches recently fetched sessions in a bounded, in-process LRU in front of \
+// another store
+type Service struct {
+	store   store.ServiceInterface
+	options Options
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// fetches holds the fetches from the store in progress, by session id
+	fetches map[string]*fetch
+}
+
+// Options defines the behavior of the caching store
+type Options struct {
+	// MaxEntries is the maximum number of sessions kept in the cache. The least recently used session is evicted \
+	// when the cache is full
+	MaxEntries int
+	// MaxAgeDuration is the maximum duration a session is served from the cache before it is fetched from the \
+	// store, again. It bounds how stale a cached session can be if an invalidation is lost
+	MaxAgeDuration time.Duration
+	// Invalidator, if set, broadcasts saves and deletes to the other app instances so they evict the session \
+	// from their caches
+	Invalidator InvalidatorInterface
+}
+
+// entry is a cached session
+type entry struct {
+	userSession user.Session
+	cachedAt    time.Time
+}
+
+// fetch tracks the fetches of a session from the store in progress
+type fetch struct {
+	// count is the number of fetches in progress
+	count int
+	// generation is incremented whenever the session is cached by a save, or evicted, so a fetch that raced with \
+	// it doesn't cache the stale session it read from the store before
+	generation uint64
+}
+
+// New returns a new caching store in front of s. If an Invalidator is set, New starts listening for \
+// invalidations; Close should be called to stop it.
+func New(s store.ServiceInterface, options Options) *Service {
+	setDefaultOptions(&options)
+
+	c := &Service{
+		store:   s,
+		options: options,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		fetches: make(map[string]*fetch),
+	}
+
+	if options.Invalidator != nil {
+		go options.Invalidator.Listen(c.invalidate)
+	}
+
+	return c
+}
+
+// SaveUserSession saves a user session in the store and the cache, and invalidates it on the other instances
+func (s *Service) SaveUserSession(userSession *user.Session) error {
+	return s.SaveUserSessionContext(context.Background(), userSession)
+}
+
+// SaveUserSessionContext is like SaveUserSession, but passes ctx to the store if it supports it
+func (s *Service) SaveUserSessionContext(ctx context.Context, userSession *user.Session) error {
+	var err error
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		err = contextStore.SaveUserSessionContext(ctx, userSession)
+	} else {
+		err = s.store.SaveUserSession(userSession)
+	}
+	if err != nil {
+		// note: the stored session is now unknown, so don't serve it from the cache
+		s.evict(userSession.ID)
+		return err
+	}
+
+	s.add(userSession)
+	return s.publish(userSession.ID)
+}
+
+// SaveUserSessionIfVersion saves a user session in the store and the cache if the stored session's version is \
+// version, and invalidates it on the other instances. The store must implement store.VersionedServiceInterface, \
+// otherwise store.ErrNotSupported is returned.
+func (s *Service) SaveUserSessionIfVersion(userSession *user.Session, version int64) error {
+	versionedStore, ok := s.store.(store.VersionedServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	if err := versionedStore.SaveUserSessionIfVersion(userSession, version); err != nil {
+		// note: after a conflict, the cached session is stale, so the next fetch must go to the store
+		s.evict(userSession.ID)
+		return err
+	}
+
+	s.add(userSession)
+	return s.publish(userSession.ID)
+}
+
+// SaveUserSessionWithLimit saves a new user session in the store and the cache if its user holds fewer than limit \
+// other valid sessions, or, if evict is set, after the store deleted the user's lowest ranked sessions. Evicted \
+// sessions are removed from the cache and invalidated on the other instances. The store must implement \
+// store.LimitServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) SaveUserSessionWithLimit(ctx context.Context, userSession *user.Session, limit int, evict bool) ([]string, error) {
+	limitStore, ok := s.store.(store.LimitServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	evictedSessionIDs, err := limitStore.SaveUserSessionWithLimit(ctx, userSession, limit, evict)
+	for _, sessionID := range evictedSessionIDs {
+		s.evict(sessionID)
+		if err := s.publish(sessionID); err != nil {
+			return evictedSessionIDs, err
+		}
+	}
+	if err != nil {
+		s.evict(userSession.ID)
+		return evictedSessionIDs, err
+	}
+
+	s.add(userSession)
+	return evictedSessionIDs, s.publish(userSession.ID)
+}
+
+// TouchUserSession ranks a session as its user's most recently used session in the store. The store must \
+// implement store.LimitServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) TouchUserSession(ctx context.Context, userSession *user.Session) error {
+	limitStore, ok := s.store.(store.LimitServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return limitStore.TouchUserSession(ctx, userSession)
+}
+
+// RegenerateUserSession moves a session to a new id in the store and the cache, evicts the old id from the cache and \
+// invalidates both ids on the other instances. The store must implement store.RegenerateServiceInterface, \
+// otherwise store.ErrNotSupported is returned.
+func (s *Service) RegenerateUserSession(ctx context.Context, sessionID string, userSession *user.Session) error {
+	regenerateStore, ok := s.store.(store.RegenerateServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	// note: the old id is evicted even if the store failed, since it may have been deleted anyways
+	err := regenerateStore.RegenerateUserSession(ctx, sessionID, userSession)
+	s.evict(sessionID)
+	if err := s.publish(sessionID); err != nil {
+		return err
+	}
+	if err != nil {
+		s.evict(userSession.ID)
+		return err
+	}
+
+	s.add(userSession)
+	return s.publish(userSession.ID)
+}
+
+// SetUserSessionField sets a named field of a stored session's Data, evicts the session from the cache and \
+// invalidates it on the other instances. The store must implement store.FieldServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+func (s *Service) SetUserSessionField(sessionID string, name string, value string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.updateField(sessionID, func() error {
+		return fieldStore.SetUserSessionField(sessionID, name, value)
+	})
+}
+
+// DeleteUserSessionField deletes a named field from a stored session's Data, evicts the session from the cache \
+// and invalidates it on the other instances. The store must implement store.FieldServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+func (s *Service) DeleteUserSessionField(sessionID string, name string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.updateField(sessionID, func() error {
+		return fieldStore.DeleteUserSessionField(sessionID, name)
+	})
+}
+
+// LockUserSession acquires the lock of a session in the store. Locks are never cached, so every instance \
+// contends for the same lock. The store must implement store.LockServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+func (s *Service) LockUserSession(ctx context.Context, sessionID string, ttl time.Duration) (int64, error) {
+	lockStore, ok := s.store.(store.LockServiceInterface)
+	if !ok {
+		return 0, store.ErrNotSupported
+	}
+
+	return lockStore.LockUserSession(ctx, sessionID, ttl)
+}
+
+// UnlockUserSession releases the lock of a session in the store. The store must implement \
+// store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) UnlockUserSession(ctx context.Context, sessionID string, token int64) error {
+	lockStore, ok := s.store.(store.LockServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return lockStore.UnlockUserSession(ctx, sessionID, token)
+}
+
+// Ping checks whether the store can be reached. The store must implement store.HealthServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+func (s *Service) Ping(ctx context.Context) error {
+	healthStore, ok := s.store.(store.HealthServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return healthStore.Ping(ctx)
+}
+
+// DeleteUserSession deletes a user session from the store and the cache, and invalidates it on the other instances
+func (s *Service) DeleteUserSession(sessionID string) error {
+	return s.DeleteUserSessionContext(context.Background(), sessionID)
+}
+
+// DeleteUserSessionContext is like DeleteUserSession, but passes ctx to the store if it supports it
+func (s *Service) DeleteUserSessionContext(ctx context.Context, sessionID string) error {
+	s.evict(sessionID)
+
+	var err error
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		err = contextStore.DeleteUserSessionContext(ctx, sessionID)
+	} else {
+		err = s.store.DeleteUserSession(sessionID)
+	}
+	// note: evict again, so a fetch that read the session from the store before it was deleted doesn't cache it
+	s.evict(sessionID)
+	if err != nil {
+		return err
+	}
+
+	return s.publish(sessionID)
+}
+
+// FetchValidUserSession returns a valid user session from the cache, or from the store if it isn't cached. \
+// If a valid session does not exist, this function returns a nil pointer
+func (s *Service) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	return s.FetchValidUserSessionContext(context.Background(), sessionID)
+}
+
+// FetchValidUserSessionContext is like FetchValidUserSession, but passes ctx to the store if it supports it
+func (s *Service) FetchValidUserSessionContext(ctx context.Context, sessionID string) (*user.Session, error) {
+	userSession, generation := s.get(sessionID)
+	if userSession != nil {
+		return userSession, nil
+	}
+
+	var err error
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		userSession, err = contextStore.FetchValidUserSessionContext(ctx, sessionID)
+	} else {
+		userSession, err = s.store.FetchValidUserSession(sessionID)
+	}
+	if err != nil {
+		userSession = nil
+	}
+
+	s.addIfGeneration(sessionID, userSession, generation)
+	return userSession, err
+}
+
+// ListUserSessions returns all valid sessions of a user from the store. The store must implement \
+// store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) ListUserSessions(userID string) ([]*user.Session, error) {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	return userStore.ListUserSessions(userID)
+}
+
+// DeleteAllUserSessions deletes all sessions of a user from the store and the cache, and invalidates them on the \
+// other instances. The store must implement store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) DeleteAllUserSessions(userID string) error {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	userSessions, err := userStore.ListUserSessions(userID)
+	if err != nil {
+		return err
+	}
+	if err := userStore.DeleteAllUserSessions(userID); err != nil {
+		return err
+	}
+
+	for _, userSession := range userSessions {
+		s.evict(userSession.ID)
+		if err := s.publish(userSession.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Scan returns a batch of valid sessions from the store, bypassing the cache. The store must implement \
+// store.ScanServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	scanStore, ok := s.store.(store.ScanServiceInterface)
+	if !ok {
+		return nil, "", store.ErrNotSupported
+	}
+
+	return scanStore.Scan(cursor, count)
+}
+
+// Close stops listening for invalidations
+func (s *Service) Close() error {
+	if s.options.Invalidator == nil {
+		return nil
+	}
+
+	return s.options.Invalidator.Close()
+}
+
+// updateField evicts a session whose field is changed by update, and invalidates it on the other instances
+func (s *Service) updateField(sessionID string, update func() error) error {
+	// note: the session's other fields may have changed in the store, too, so it is fetched again rather than \
+	// patched in the cache
+	s.evict(sessionID)
+	err := update()
+	// note: evict again, so a fetch that read the session from the store before it was updated doesn't cache it
+	s.evict(sessionID)
+	if err != nil {
+		return err
+	}
+
+	return s.publish(sessionID)
+}
+
+// get returns a copy of a cached session. If it isn't cached, has expired or is too old, get returns a nil \
+// pointer and the session's fetch generation, and the caller must pass the session it fetches from the store, or \
+// a nil pointer, to addIfGeneration
+func (s *Service) get(sessionID string) (*user.Session, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[sessionID]; ok {
+		e := element.Value.(*entry)
+		now := time.Now()
+		if e.userSession.ExpiresAt.After(now) && now.Sub(e.cachedAt) <= s.options.MaxAgeDuration {
+			s.lru.MoveToFront(element)
+			return e.userSession.Copy(), 0
+		}
+		s.removeElement(element)
+	}
+
+	f, ok := s.fetches[sessionID]
+	if !ok {
+		f = &fetch{}
+		s.fetches[sessionID] = f
+	}
+	f.count++
+
+	return nil, f.generation
+}
+
+// add caches a copy of a saved session, evicting the least recently used session if the cache is full
+func (s *Service) add(userSession *user.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bumpGeneration(userSession.ID)
+	s.put(userSession)
+}
+
+// addIfGeneration ends a fetch of a session started by get, and caches a copy of the fetched session, like add, \
+// unless the session was saved or evicted since its fetch was at generation, since the fetch may have read the \
+// session before it was changed or deleted
+func (s *Service) addIfGeneration(sessionID string, userSession *user.Session, generation uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.fetches[sessionID]
+	f.count--
+	if f.count == 0 {
+		delete(s.fetches, sessionID)
+	}
+
+	if userSession == nil || f.generation != generation {
+		return
+	}
+	s.put(userSession)
+}
+
+// bumpGeneration marks the fetches of a session in progress as stale. The caller must hold the lock
+func (s *Service) bumpGeneration(sessionID string) {
+	if f, ok := s.fetches[sessionID]; ok {
+		f.generation++
+	}
+}
+
+// put caches a copy of a session, evicting the least recently used session if the cache is full. The caller must \
+// hold the lock
+func (s *Service) put(userSession *user.Session) {
+	if element, ok := s.entries[userSession.ID]; ok {
+		s.removeElement(element)
+	}
+	if !userSession.ExpiresAt.After(time.Now()) {
+		return
+	}
+
+	s.entries[userSession.ID] = s.lru.PushFront(&entry{
+		userSession: *userSession.Copy(),
+		cachedAt:    time.Now(),
+	})
+
+	for s.lru.Len() > s.options.MaxEntries {
+		s.removeElement(s.lru.Back())
+	}
+}
+
+// evict removes a session from the cache
+func (s *Service) evict(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bumpGeneration(sessionID)
+	if element, ok := s.entries[sessionID]; ok {
+		s.removeElement(element)
+	}
+}
+
+// removeElement removes an element from the cache. The caller must hold the lock
+func (s *Service) removeElement(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.entries, element.Value.(*entry).userSession.ID)
+}
+
+// invalidate evicts a session invalidated by another instance. An empty session id evicts all sessions
+func (s *Service) invalidate(sessionID string) {
+	if sessionID != "" {
+		s.evict(sessionID)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.fetches {
+		f.generation++
+	}
+	s.lru.Init()
+	s.entries = make(map[string]*list.Element)
+}
+
+// publish invalidates a session on the other instances, if an Invalidator is set
+func (s *Service) publish(sessionID string) error {
+	if s.options.Invalidator == nil {
+		return nil
+	}
+
+	return s.options.Invalidator.Publish(sessionID)
+}
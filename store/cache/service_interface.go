@@ -0,0 +1,13 @@
+package cache
+
+// InvalidatorInterface broadcasts session invalidations between app instances that share a store
+type InvalidatorInterface interface {
+	// Publish notifies the other instances that a session was saved or deleted
+	Publish(sessionID string) error
+	// Listen calls invalidate with the id of every session published by another instance, until Close is \
+	// called. invalidate is called with an empty session id when invalidations may have been missed, e.g. after \
+	// a reconnect, in which case all cached sessions should be evicted.
+	Listen(invalidate func(sessionID string))
+	// Close stops listening
+	Close() error
+}
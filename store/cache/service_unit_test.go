@@ -0,0 +1,365 @@
+// +build unit
+
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/store/memory"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// note: this will fail to compile if the caching store does not implement the store interfaces
+var (
+	_ store.ServiceInterface           = (*Service)(nil)
+	_ store.ContextServiceInterface    = (*Service)(nil)
+	_ store.UserServiceInterface       = (*Service)(nil)
+	_ store.ScanServiceInterface       = (*Service)(nil)
+	_ store.VersionedServiceInterface  = (*Service)(nil)
+	_ store.FieldServiceInterface      = (*Service)(nil)
+	_ store.LockServiceInterface       = (*Service)(nil)
+	_ store.HealthServiceInterface     = (*Service)(nil)
+	_ store.LimitServiceInterface      = (*Service)(nil)
+	_ store.RegenerateServiceInterface = (*Service)(nil)
+	_ InvalidatorInterface             = (*RedisInvalidator)(nil)
+)
+
+// countingStore counts the fetches that reach the store
+type countingStore struct {
+	*memory.Service
+
+	mu      sync.Mutex
+	fetches int
+}
+
+func (c *countingStore) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	c.mu.Lock()
+	c.fetches++
+	c.mu.Unlock()
+	return c.Service.FetchValidUserSession(sessionID)
+}
+
+func (c *countingStore) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetches
+}
+
+// interleavedStore runs interleave after it read a session, before it returns it, like a write that races with a \
+// fetch
+type interleavedStore struct {
+	*memory.Service
+
+	interleave func()
+}
+
+func (i *interleavedStore) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	userSession, err := i.Service.FetchValidUserSession(sessionID)
+	if i.interleave != nil {
+		interleave := i.interleave
+		i.interleave = nil
+		interleave()
+	}
+	return userSession, err
+}
+
+// fakeBus is an in-process InvalidatorInterface shared by several caches
+type fakeBus struct {
+	mu        sync.Mutex
+	listeners map[*fakeInvalidator]func(sessionID string)
+}
+
+type fakeInvalidator struct {
+	bus *fakeBus
+}
+
+func (f *fakeInvalidator) Publish(sessionID string) error {
+	f.bus.mu.Lock()
+	defer f.bus.mu.Unlock()
+	for listener, invalidate := range f.bus.listeners {
+		if listener != f {
+			invalidate(sessionID)
+		}
+	}
+	return nil
+}
+
+func (f *fakeInvalidator) Listen(invalidate func(sessionID string)) {
+	f.bus.mu.Lock()
+	defer f.bus.mu.Unlock()
+	f.bus.listeners[f] = invalidate
+}
+
+func (f *fakeInvalidator) Close() error {
+	return nil
+}
+
+func newUserSession(id string) *user.Session {
+	return &user.Session{
+		ID:        id,
+		UserID:    "userID",
+		JSON:      "json",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+}
+
+// TestFetchValidUserSession tests that fetched sessions are served from the cache
+func TestFetchValidUserSession(t *testing.T) {
+	inner := &countingStore{Service: memory.New(memory.Options{})}
+	defer inner.Close()
+	s := New(inner, Options{})
+
+	userSession := newUserSession("sessionID")
+	inner.SaveUserSession(userSession)
+
+	var tests = []struct {
+		input           string
+		expectedExists  bool
+		expectedFetches int
+	}{
+		{userSession.ID, true, 1},
+		{userSession.ID, true, 1},
+		{"missingSessionID", false, 2},
+		{"missingSessionID", false, 3},
+	}
+
+	for idx, tt := range tests {
+		a, e := s.FetchValidUserSession(tt.input)
+		exists := a != nil
+
+		if e != nil || exists != tt.expectedExists || inner.count() != tt.expectedFetches {
+			t.Errorf("test #%d failed; input: %s, expected exists: %t, expected fetches: %d, received exists: %t, received fetches: %d, received err: %v", idx+1, tt.input, tt.expectedExists, tt.expectedFetches, exists, inner.count(), e)
+		}
+	}
+}
+
+// TestSaveUserSessionIfVersion tests that a version conflict evicts the stale cached session
+func TestSaveUserSessionIfVersion(t *testing.T) {
+	inner := &countingStore{Service: memory.New(memory.Options{})}
+	defer inner.Close()
+	s := New(inner, Options{})
+
+	userSession := newUserSession("sessionID")
+	s.SaveUserSession(userSession)
+	staleUserSession := *userSession
+
+	// note: another instance saves the session, without invalidating this cache
+	inner.SaveUserSession(userSession)
+
+	if e := s.SaveUserSessionIfVersion(&staleUserSession, staleUserSession.Version); e != store.ErrVersionConflict {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrVersionConflict, e)
+	}
+
+	a, e := s.FetchValidUserSession(userSession.ID)
+	if e != nil || a == nil || a.Version != userSession.Version || inner.count() != 1 {
+		t.Errorf("test failed; expected the fresh session from the store, received: %v, fetches: %d, err: %v", a, inner.count(), e)
+	}
+
+	if e := s.SaveUserSessionIfVersion(a, a.Version); e != nil || a.Version != userSession.Version+1 {
+		t.Errorf("test failed; expected the session to be saved with version %d, received: %d, err: %v", userSession.Version+1, a.Version, e)
+	}
+}
+
+// TestUserSessionFields tests that changing a field evicts the session, and that cached data can't be changed \
+// without saving it
+func TestUserSessionFields(t *testing.T) {
+	inner := &countingStore{Service: memory.New(memory.Options{})}
+	defer inner.Close()
+	s := New(inner, Options{})
+
+	userSession := newUserSession("sessionID")
+	userSession.Set("cart", "1")
+	s.SaveUserSession(userSession)
+	userSession.Set("cart", "changed")
+
+	a, _ := s.FetchValidUserSession(userSession.ID)
+	if value, _ := a.Get("cart"); value != "1" || inner.count() != 0 {
+		t.Errorf("test failed; expected the cached session, received: %v, fetches: %d", a, inner.count())
+	}
+	a.Set("cart", "changed")
+
+	var tests = []struct {
+		input           func() error
+		expectedCart    string
+		expectedFetches int
+	}{
+		{func() error { return nil }, "1", 0},
+		{func() error { return s.SetUserSessionField(userSession.ID, "cart", "2") }, "2", 1},
+		{func() error { return s.DeleteUserSessionField(userSession.ID, "cart") }, "", 2},
+	}
+
+	for idx, tt := range tests {
+		if e := tt.input(); e != nil {
+			t.Errorf("test #%d failed; expected err to be nil, received: %v", idx+1, e)
+		}
+
+		a, _ := s.FetchValidUserSession(userSession.ID)
+		if value, _ := a.Get("cart"); value != tt.expectedCart || inner.count() != tt.expectedFetches {
+			t.Errorf("test #%d failed; expected cart: %s, expected fetches: %d, received session: %v, received fetches: %d", idx+1, tt.expectedCart, tt.expectedFetches, a, inner.count())
+		}
+	}
+}
+
+// TestExpiry tests that expired and too old sessions are not served from the cache
+func TestExpiry(t *testing.T) {
+	inner := &countingStore{Service: memory.New(memory.Options{})}
+	defer inner.Close()
+	s := New(inner, Options{MaxAgeDuration: 20 * time.Millisecond})
+
+	userSession := newUserSession("sessionID")
+	userSession.ExpiresAt = time.Now().Add(50 * time.Millisecond)
+	s.SaveUserSession(userSession)
+
+	// note: the session was cached on save
+	if a, _ := s.FetchValidUserSession(userSession.ID); a == nil || inner.count() != 0 {
+		t.Errorf("test failed; expected session to be served from the cache, received session: %v, fetches: %d", a, inner.count())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if a, _ := s.FetchValidUserSession(userSession.ID); a == nil || inner.count() != 1 {
+		t.Errorf("test failed; expected too old session to be fetched from the store, received session: %v, fetches: %d", a, inner.count())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if a, _ := s.FetchValidUserSession(userSession.ID); a != nil {
+		t.Errorf("test failed; expected expired session not to be returned, received session: %v", a)
+	}
+}
+
+// TestMaxEntries tests that the least recently used session is evicted when the cache is full
+func TestMaxEntries(t *testing.T) {
+	inner := &countingStore{Service: memory.New(memory.Options{})}
+	defer inner.Close()
+	s := New(inner, Options{MaxEntries: 2})
+
+	for idx := 0; idx < 3; idx++ {
+		userSession := newUserSession("sessionID" + strconv.Itoa(idx))
+		s.SaveUserSession(userSession)
+		if idx == 1 {
+			// note: touch the first session, so the second is the least recently used
+			s.FetchValidUserSession("sessionID0")
+		}
+	}
+
+	if len(s.entries) != 2 || s.lru.Len() != 2 {
+		t.Errorf("test failed; expected 2 cached sessions, received: %d", len(s.entries))
+	}
+	if _, ok := s.entries["sessionID1"]; ok {
+		t.Errorf("test failed; expected the least recently used session to be evicted")
+	}
+}
+
+// TestInvalidation tests that saves and deletes on one instance evict the session on the others
+func TestInvalidation(t *testing.T) {
+	inner := memory.New(memory.Options{})
+	defer inner.Close()
+
+	bus := &fakeBus{listeners: make(map[*fakeInvalidator]func(sessionID string))}
+	s1 := New(inner, Options{Invalidator: &fakeInvalidator{bus: bus}})
+	s2 := New(inner, Options{Invalidator: &fakeInvalidator{bus: bus}})
+	// note: listeners are registered in goroutines
+	time.Sleep(10 * time.Millisecond)
+
+	userSession := newUserSession("sessionID")
+	s1.SaveUserSession(userSession)
+	s2.FetchValidUserSession(userSession.ID)
+
+	updatedUserSession := *userSession
+	updatedUserSession.JSON = "updatedJSON"
+	s1.SaveUserSession(&updatedUserSession)
+
+	if a, _ := s2.FetchValidUserSession(userSession.ID); a == nil || a.JSON != updatedUserSession.JSON {
+		t.Errorf("test failed; expected the updated session after a save on another instance, received: %v", a)
+	}
+
+	s1.DeleteUserSession(userSession.ID)
+	if a, _ := s2.FetchValidUserSession(userSession.ID); a != nil {
+		t.Errorf("test failed; expected no session after a delete on another instance, received: %v", a)
+	}
+
+	s2.SaveUserSession(userSession)
+	s1.FetchValidUserSession(userSession.ID)
+	if e := s2.DeleteAllUserSessions(userSession.UserID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting all user sessions, received: %v", e)
+	}
+	if a, _ := s1.FetchValidUserSession(userSession.ID); a != nil {
+		t.Errorf("test failed; expected no session after deleting all user sessions on another instance, received: %v", a)
+	}
+}
+
+// TestInterleavedFetch tests that a fetch that read a session from the store before it was deleted or changed \
+// doesn't cache the stale session
+func TestInterleavedFetch(t *testing.T) {
+	inner := &interleavedStore{Service: memory.New(memory.Options{})}
+	defer inner.Close()
+
+	bus := &fakeBus{listeners: make(map[*fakeInvalidator]func(sessionID string))}
+	s1 := New(inner, Options{Invalidator: &fakeInvalidator{bus: bus}})
+	s2 := New(inner, Options{Invalidator: &fakeInvalidator{bus: bus}})
+	// note: listeners are registered in goroutines
+	time.Sleep(10 * time.Millisecond)
+
+	var tests = []struct {
+		interleave   func(sessionID string)
+		expectedCart string
+		expectedNil  bool
+	}{
+		{func(sessionID string) { s1.DeleteUserSession(sessionID) }, "", true},
+		{func(sessionID string) { s2.DeleteUserSession(sessionID) }, "", true},
+		{func(sessionID string) { s1.SetUserSessionField(sessionID, "cart", "1") }, "1", false},
+		{func(sessionID string) { s2.SetUserSessionField(sessionID, "cart", "1") }, "1", false},
+	}
+
+	for idx, tt := range tests {
+		userSession := newUserSession("interleavedSessionID" + strconv.Itoa(idx))
+		inner.SaveUserSession(userSession)
+		inner.interleave = func() { tt.interleave(userSession.ID) }
+
+		s1.FetchValidUserSession(userSession.ID)
+		a, e := s1.FetchValidUserSession(userSession.ID)
+
+		if e != nil || (a == nil) != tt.expectedNil || (a != nil && a.Data["cart"] != tt.expectedCart) {
+			t.Errorf("test #%d failed; expected nil: %t, expected cart: %s, received session: %v, received err: %v", idx+1, tt.expectedNil, tt.expectedCart, a, e)
+		}
+	}
+}
+
+// TestInterleavedFetchOtherSession tests that saving or deleting another session while a session is fetched from \
+// the store doesn't keep the fetched session from being cached
+func TestInterleavedFetchOtherSession(t *testing.T) {
+	inner := &interleavedStore{Service: memory.New(memory.Options{})}
+	defer inner.Close()
+
+	bus := &fakeBus{listeners: make(map[*fakeInvalidator]func(sessionID string))}
+	s1 := New(inner, Options{Invalidator: &fakeInvalidator{bus: bus}})
+	s2 := New(inner, Options{Invalidator: &fakeInvalidator{bus: bus}})
+	// note: listeners are registered in goroutines
+	time.Sleep(10 * time.Millisecond)
+
+	otherUserSession := newUserSession("otherSessionID")
+	var tests = []func(){
+		func() { s1.SaveUserSession(otherUserSession) },
+		func() { s2.SaveUserSession(otherUserSession) },
+		func() { s1.DeleteUserSession(otherUserSession.ID) },
+		func() { s2.DeleteUserSession(otherUserSession.ID) },
+	}
+
+	for idx, tt := range tests {
+		userSession := newUserSession("fetchedSessionID" + strconv.Itoa(idx))
+		inner.SaveUserSession(userSession)
+		inner.interleave = tt
+
+		s1.FetchValidUserSession(userSession.ID)
+		// note: a cached session is served from the cache after it is deleted from the store behind its back
+		inner.DeleteUserSession(userSession.ID)
+		a, e := s1.FetchValidUserSession(userSession.ID)
+
+		if e != nil || a == nil {
+			t.Errorf("test #%d failed; expected the session to be cached, received session: %v, received err: %v", idx+1, a, e)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package cache
+
+// setDefaultOptions sets default values for nil fields
+func setDefaultOptions(options *Options) {
+	emptyOptions := Options{}
+	if options.MaxEntries <= emptyOptions.MaxEntries {
+		options.MaxEntries = DefaultMaxEntries
+	}
+	if options.MaxAgeDuration <= emptyOptions.MaxAgeDuration {
+		options.MaxAgeDuration = DefaultMaxAgeDuration
+	}
+
+	return
+}
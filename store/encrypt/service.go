@@ -0,0 +1,382 @@
+package encrypt
+
+import (
+	"context"
+	"crypto/cipher"
+	"errors"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+var (
+	// ErrNoKeys is thrown when the keyring is empty
+	ErrNoKeys = errors.New("at least one key is required")
+	// ErrInvalidKeyID is thrown when a key id is empty, contains characters other than letters, digits, '-' and \
+	// '_', or is used by more than one key
+	ErrInvalidKeyID = errors.New("invalid or duplicate key id")
+	// ErrUnknownKey is thrown when a value was sealed with a key that is not in the keyring
+	ErrUnknownKey = errors.New("value was sealed with an unknown key")
+	// ErrOpeningSession is thrown when a session's sealed values are malformed or fail authentication
+	ErrOpeningSession = errors.New("error opening session data")
+)
+
+// Service is a session store that seals session payloads with AES-GCM before they are written to another store, \
+// so the other store only ever sees ciphertext
+type Service struct {
+	store   store.ServiceInterface
+	options Options
+
+	primary *key
+	keys    map[string]*key
+}
+
+// Key is a data-encryption key of the keyring
+type Key struct {
+	// ID identifies the key. It is written next to every value the key seals, so it must not change, and must \
+	// only contain letters, digits, '-' and '_'
+	ID string
+	// Secret is the AES key. It must be 16, 24 or 32 bytes long
+	Secret []byte
+}
+
+// Options defines the behavior of the encrypting store.
+//
+// Values are sealed with the first of Keys, and opened with whichever key sealed them. To rotate keys, add the \
+// new key to the front of Keys, and remove the old one once the sessions it sealed have expired.
+type Options struct {
+	Keys []Key
+	// EncryptUserID also seals the session's UserID. A user's id always seals to the same ciphertext under a given \
+	// key, so stores can still index sessions by user; this reveals which sessions belong to the same user, but \
+	// not who the user is
+	EncryptUserID bool
+	// AllowPlaintext makes the store return sessions that were written before encryption was enabled as they are, \
+	// instead of failing to open them
+	AllowPlaintext bool
+}
+
+// key is a key of the keyring
+type key struct {
+	id   string
+	aead cipher.AEAD
+	// nonceKey derives deterministic nonces for user ids
+	nonceKey []byte
+}
+
+// New returns a new encrypting store in front of s
+func New(s store.ServiceInterface, options Options) (*Service, error) {
+	if len(options.Keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	keys := make(map[string]*key, len(options.Keys))
+	for _, k := range options.Keys {
+		if !validKeyID.MatchString(k.ID) || keys[k.ID] != nil {
+			return nil, ErrInvalidKeyID
+		}
+
+		parsedKey, err := newKey(k)
+		if err != nil {
+			return nil, err
+		}
+		keys[k.ID] = parsedKey
+	}
+
+	return &Service{
+		store:   s,
+		options: options,
+		primary: keys[options.Keys[0].ID],
+		keys:    keys,
+	}, nil
+}
+
+// SaveUserSession seals a user session and saves it in the store
+func (s *Service) SaveUserSession(userSession *user.Session) error {
+	return s.SaveUserSessionContext(context.Background(), userSession)
+}
+
+// SaveUserSessionContext is like SaveUserSession, but passes ctx to the store if it supports it
+func (s *Service) SaveUserSessionContext(ctx context.Context, userSession *user.Session) error {
+	sealedUserSession, err := s.seal(userSession)
+	if err != nil {
+		return err
+	}
+
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		err = contextStore.SaveUserSessionContext(ctx, sealedUserSession)
+	} else {
+		err = s.store.SaveUserSession(sealedUserSession)
+	}
+	if err != nil {
+		return err
+	}
+
+	// note: the store may have versioned the sealed copy of the session
+	userSession.Version = sealedUserSession.Version
+	return nil
+}
+
+// SaveUserSessionIfVersion seals a user session and saves it in the store if the stored session's version is \
+// version. The store must implement store.VersionedServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) SaveUserSessionIfVersion(userSession *user.Session, version int64) error {
+	versionedStore, ok := s.store.(store.VersionedServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	sealedUserSession, err := s.seal(userSession)
+	if err != nil {
+		return err
+	}
+	if err := versionedStore.SaveUserSessionIfVersion(sealedUserSession, version); err != nil {
+		return err
+	}
+
+	userSession.Version = sealedUserSession.Version
+	return nil
+}
+
+// SaveUserSessionWithLimit seals a new user session and saves it in the store if its user holds fewer than limit \
+// other valid sessions, or, if evict is set, after the store deleted the user's lowest ranked sessions. The store \
+// must implement store.LimitServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Note that if user ids are sealed, sessions stored under a user id sealed with a previous key are not counted.
+func (s *Service) SaveUserSessionWithLimit(ctx context.Context, userSession *user.Session, limit int, evict bool) ([]string, error) {
+	limitStore, ok := s.store.(store.LimitServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	sealedUserSession, err := s.seal(userSession)
+	if err != nil {
+		return nil, err
+	}
+
+	evictedSessionIDs, err := limitStore.SaveUserSessionWithLimit(ctx, sealedUserSession, limit, evict)
+	if err != nil {
+		return evictedSessionIDs, err
+	}
+
+	userSession.Version = sealedUserSession.Version
+	return evictedSessionIDs, nil
+}
+
+// TouchUserSession ranks a session as its user's most recently used session in the store. The store must \
+// implement store.LimitServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) TouchUserSession(ctx context.Context, userSession *user.Session) error {
+	limitStore, ok := s.store.(store.LimitServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	// note: the store ranks the session under its stored user id
+	touchedUserSession := &user.Session{ID: userSession.ID, UserID: userSession.UserID}
+	if s.options.EncryptUserID {
+		touchedUserSession.UserID = s.primary.sealUserID(userSession.UserID)
+	}
+
+	return limitStore.TouchUserSession(ctx, touchedUserSession)
+}
+
+// RegenerateUserSession seals a session under its new id, saves it in the store and deletes the session with \
+// sessionID. The store must implement store.RegenerateServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) RegenerateUserSession(ctx context.Context, sessionID string, userSession *user.Session) error {
+	regenerateStore, ok := s.store.(store.RegenerateServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	sealedUserSession, err := s.seal(userSession)
+	if err != nil {
+		return err
+	}
+	if err := regenerateStore.RegenerateUserSession(ctx, sessionID, sealedUserSession); err != nil {
+		return err
+	}
+
+	userSession.Version = sealedUserSession.Version
+	return nil
+}
+
+// SetUserSessionField seals a value and sets it as a named field of a stored session's Data. The store must \
+// implement store.FieldServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) SetUserSessionField(sessionID string, name string, value string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	sealedValue, err := s.sealValue(value, dataAdditionalData(sessionID, name))
+	if err != nil {
+		return err
+	}
+
+	return fieldStore.SetUserSessionField(sessionID, name, sealedValue)
+}
+
+// DeleteUserSessionField deletes a named field from a stored session's Data. The store must implement \
+// store.FieldServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) DeleteUserSessionField(sessionID string, name string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return fieldStore.DeleteUserSessionField(sessionID, name)
+}
+
+// LockUserSession acquires the lock of a session in the store. Locks hold no session data, so nothing is sealed. \
+// The store must implement store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) LockUserSession(ctx context.Context, sessionID string, ttl time.Duration) (int64, error) {
+	lockStore, ok := s.store.(store.LockServiceInterface)
+	if !ok {
+		return 0, store.ErrNotSupported
+	}
+
+	return lockStore.LockUserSession(ctx, sessionID, ttl)
+}
+
+// UnlockUserSession releases the lock of a session in the store. The store must implement \
+// store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) UnlockUserSession(ctx context.Context, sessionID string, token int64) error {
+	lockStore, ok := s.store.(store.LockServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return lockStore.UnlockUserSession(ctx, sessionID, token)
+}
+
+// Ping checks whether the store can be reached. The store must implement store.HealthServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+func (s *Service) Ping(ctx context.Context) error {
+	healthStore, ok := s.store.(store.HealthServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return healthStore.Ping(ctx)
+}
+
+// DeleteUserSession deletes a user session from the store
+func (s *Service) DeleteUserSession(sessionID string) error {
+	return s.DeleteUserSessionContext(context.Background(), sessionID)
+}
+
+// DeleteUserSessionContext is like DeleteUserSession, but passes ctx to the store if it supports it
+func (s *Service) DeleteUserSessionContext(ctx context.Context, sessionID string) error {
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		return contextStore.DeleteUserSessionContext(ctx, sessionID)
+	}
+	return s.store.DeleteUserSession(sessionID)
+}
+
+// FetchValidUserSession fetches a user session from the store and opens it. If a valid session does not exist, \
+// this function returns a nil pointer
+func (s *Service) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	return s.FetchValidUserSessionContext(context.Background(), sessionID)
+}
+
+// FetchValidUserSessionContext is like FetchValidUserSession, but passes ctx to the store if it supports it
+func (s *Service) FetchValidUserSessionContext(ctx context.Context, sessionID string) (*user.Session, error) {
+	var userSession *user.Session
+	var err error
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		userSession, err = contextStore.FetchValidUserSessionContext(ctx, sessionID)
+	} else {
+		userSession, err = s.store.FetchValidUserSession(sessionID)
+	}
+	if err != nil || userSession == nil {
+		return nil, err
+	}
+
+	return s.open(userSession)
+}
+
+// ListUserSessions returns all valid sessions of a user from the store. The store must implement \
+// store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) ListUserSessions(userID string) ([]*user.Session, error) {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	userSessions := []*user.Session{}
+	for _, storedUserID := range s.storedUserIDs(userID) {
+		sealedUserSessions, err := userStore.ListUserSessions(storedUserID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sealedUserSession := range sealedUserSessions {
+			userSession, err := s.open(sealedUserSession)
+			if err != nil {
+				return nil, err
+			}
+			userSessions = append(userSessions, userSession)
+		}
+	}
+
+	return userSessions, nil
+}
+
+// DeleteAllUserSessions deletes all sessions of a user from the store. The store must implement \
+// store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) DeleteAllUserSessions(userID string) error {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	for _, storedUserID := range s.storedUserIDs(userID) {
+		if err := userStore.DeleteAllUserSessions(storedUserID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Scan returns a batch of valid, decrypted sessions from the store. The store must implement \
+// store.ScanServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	scanStore, ok := s.store.(store.ScanServiceInterface)
+	if !ok {
+		return nil, "", store.ErrNotSupported
+	}
+
+	sealedUserSessions, next, err := scanStore.Scan(cursor, count)
+	if err != nil {
+		return nil, "", err
+	}
+
+	userSessions := make([]*user.Session, 0, len(sealedUserSessions))
+	for _, sealedUserSession := range sealedUserSessions {
+		userSession, err := s.open(sealedUserSession)
+		if err != nil {
+			return nil, "", err
+		}
+		userSessions = append(userSessions, userSession)
+	}
+
+	return userSessions, next, nil
+}
+
+// storedUserIDs returns the ids a user's sessions may be stored under. If user ids are sealed, a user's sessions \
+// are stored under a different id for each key of the keyring.
+func (s *Service) storedUserIDs(userID string) []string {
+	if !s.options.EncryptUserID {
+		return []string{userID}
+	}
+
+	storedUserIDs := make([]string, 0, len(s.options.Keys)+1)
+	for _, k := range s.options.Keys {
+		storedUserIDs = append(storedUserIDs, s.keys[k.ID].sealUserID(userID))
+	}
+	if s.options.AllowPlaintext {
+		storedUserIDs = append(storedUserIDs, userID)
+	}
+
+	return storedUserIDs
+}
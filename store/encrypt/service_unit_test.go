@@ -0,0 +1,266 @@
+// +build unit
+
+package encrypt
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/store/memory"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// note: this will fail to compile if the encrypting store does not implement the store interfaces
+var (
+	_ store.ServiceInterface           = (*Service)(nil)
+	_ store.ContextServiceInterface    = (*Service)(nil)
+	_ store.UserServiceInterface       = (*Service)(nil)
+	_ store.ScanServiceInterface       = (*Service)(nil)
+	_ store.VersionedServiceInterface  = (*Service)(nil)
+	_ store.FieldServiceInterface      = (*Service)(nil)
+	_ store.LockServiceInterface       = (*Service)(nil)
+	_ store.HealthServiceInterface     = (*Service)(nil)
+	_ store.LimitServiceInterface      = (*Service)(nil)
+	_ store.RegenerateServiceInterface = (*Service)(nil)
+)
+
+var (
+	previousKey = Key{ID: "old", Secret: bytes.Repeat([]byte{1}, 32)}
+	currentKey  = Key{ID: "new", Secret: bytes.Repeat([]byte{2}, 32)}
+)
+
+func newUserSession(id string) *user.Session {
+	return &user.Session{
+		ID:        id,
+		UserID:    "user@example.com",
+		JSON:      `{"email":"user@example.com"}`,
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+}
+
+// TestNew tests the New function
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		input       Options
+		expectedErr error
+	}{
+		{Options{}, ErrNoKeys},
+		{Options{Keys: []Key{{ID: "", Secret: previousKey.Secret}}}, ErrInvalidKeyID},
+		{Options{Keys: []Key{{ID: "a:b", Secret: previousKey.Secret}}}, ErrInvalidKeyID},
+		{Options{Keys: []Key{previousKey, previousKey}}, ErrInvalidKeyID},
+		{Options{Keys: []Key{currentKey, previousKey}}, nil},
+	}
+
+	for idx, tt := range tests {
+		_, e := New(memory.New(memory.Options{}), tt.input)
+
+		if e != tt.expectedErr {
+			t.Errorf("test #%d failed; expected err: %v, received: %v", idx+1, tt.expectedErr, e)
+		}
+	}
+
+	if _, e := New(memory.New(memory.Options{}), Options{Keys: []Key{{ID: "short", Secret: []byte("short")}}}); e == nil {
+		t.Errorf("test failed; expected an err for an invalid secret length")
+	}
+}
+
+// TestSealing tests that sessions are sealed in the store, and opened when fetched
+func TestSealing(t *testing.T) {
+	var tests = []struct {
+		options Options
+	}{
+		{Options{Keys: []Key{previousKey}}},
+		{Options{Keys: []Key{previousKey}, EncryptUserID: true}},
+	}
+
+	for idx, tt := range tests {
+		inner := memory.New(memory.Options{})
+		s, err := New(inner, tt.options)
+		if err != nil {
+			t.Fatalf("Err creating encrypting store: %v", err)
+		}
+
+		userSession := newUserSession("sessionID")
+		if e := s.SaveUserSession(userSession); e != nil {
+			t.Errorf("test #%d failed; expected err to be nil when saving, received: %v", idx+1, e)
+		}
+
+		stored, _ := inner.FetchValidUserSession(userSession.ID)
+		if stored == nil || strings.Contains(stored.JSON, "example.com") || (tt.options.EncryptUserID && strings.Contains(stored.UserID, "example.com")) {
+			t.Errorf("test #%d failed; expected stored session to be sealed, received: %v", idx+1, stored)
+		}
+
+		a, e := s.FetchValidUserSession(userSession.ID)
+		if e != nil || a == nil || a.JSON != userSession.JSON || a.UserID != userSession.UserID {
+			t.Errorf("test #%d failed; expected session: %v, received: %v, received err: %v", idx+1, userSession, a, e)
+		}
+
+		userSessions, e := s.ListUserSessions(userSession.UserID)
+		if e != nil || len(userSessions) != 1 || userSessions[0].JSON != userSession.JSON {
+			t.Errorf("test #%d failed; expected 1 listed session, received: %v, received err: %v", idx+1, userSessions, e)
+		}
+
+		if e := s.SaveUserSessionIfVersion(userSession, 0); e != store.ErrVersionConflict {
+			t.Errorf("test #%d failed; expected err: %v, received: %v", idx+1, store.ErrVersionConflict, e)
+		}
+		if e := s.SaveUserSessionIfVersion(userSession, 1); e != nil || userSession.Version != 2 {
+			t.Errorf("test #%d failed; expected the session to be saved with version 2, received: %d, err: %v", idx+1, userSession.Version, e)
+		}
+
+		userSessions, _, e = s.Scan("", 0)
+		if e != nil || len(userSessions) != 1 || userSessions[0].JSON != userSession.JSON || userSessions[0].UserID != userSession.UserID {
+			t.Errorf("test #%d failed; expected 1 scanned session, received: %v, received err: %v", idx+1, userSessions, e)
+		}
+
+		inner.Close()
+	}
+}
+
+// TestSealingData tests that the fields of a session's Data are sealed one by one, and can't be moved to another \
+// field
+func TestSealingData(t *testing.T) {
+	inner := memory.New(memory.Options{})
+	defer inner.Close()
+	s, _ := New(inner, Options{Keys: []Key{previousKey}})
+
+	userSession := newUserSession("sessionID")
+	userSession.Set("email", "user@example.com")
+	s.SaveUserSession(userSession)
+	s.SetUserSessionField(userSession.ID, "backupEmail", "backup@example.com")
+
+	stored, _ := inner.FetchValidUserSession(userSession.ID)
+	for name, value := range stored.Data {
+		if !strings.HasPrefix(value, sealedPrefix) || strings.Contains(value, "example.com") {
+			t.Errorf("test failed; expected field %s to be sealed, received: %s", name, value)
+		}
+	}
+
+	var tests = []struct {
+		input        func() error
+		expectedData map[string]string
+		expectedErr  error
+	}{
+		{func() error { return nil }, map[string]string{"email": "user@example.com", "backupEmail": "backup@example.com"}, nil},
+		{func() error { return s.DeleteUserSessionField(userSession.ID, "backupEmail") }, map[string]string{"email": "user@example.com"}, nil},
+		{func() error {
+			// note: a sealed value moved to another field must fail authentication
+			return inner.SetUserSessionField(userSession.ID, "backupEmail", stored.Data["email"])
+		}, nil, ErrOpeningSession},
+	}
+
+	for idx, tt := range tests {
+		if e := tt.input(); e != nil {
+			t.Errorf("test #%d failed; expected err to be nil, received: %v", idx+1, e)
+		}
+
+		a, e := s.FetchValidUserSession(userSession.ID)
+		var data map[string]string
+		if a != nil {
+			data = a.Data
+		}
+
+		if e != tt.expectedErr || !reflect.DeepEqual(data, tt.expectedData) {
+			t.Errorf("test #%d failed; expected data: %v, expected err: %v, received data: %v, received err: %v", idx+1, tt.expectedData, tt.expectedErr, data, e)
+		}
+	}
+}
+
+// TestKeyRotation tests that sessions sealed with an older key can still be opened, listed and deleted
+func TestKeyRotation(t *testing.T) {
+	inner := memory.New(memory.Options{})
+	defer inner.Close()
+
+	previousService, _ := New(inner, Options{Keys: []Key{previousKey}, EncryptUserID: true})
+	previousUserSession := newUserSession("previousSessionID")
+	previousService.SaveUserSession(previousUserSession)
+
+	rotatedService, _ := New(inner, Options{Keys: []Key{currentKey, previousKey}, EncryptUserID: true})
+	currentUserSession := newUserSession("currentSessionID")
+	rotatedService.SaveUserSession(currentUserSession)
+
+	stored, _ := inner.FetchValidUserSession(currentUserSession.ID)
+	if stored == nil || !strings.HasPrefix(stored.JSON, sealedPrefix+currentKey.ID+":") {
+		t.Errorf("test failed; expected new session to be sealed with the new key, received: %v", stored)
+	}
+
+	for _, userSession := range []*user.Session{previousUserSession, currentUserSession} {
+		a, e := rotatedService.FetchValidUserSession(userSession.ID)
+		if e != nil || a == nil || a.JSON != userSession.JSON {
+			t.Errorf("test failed; expected session: %v, received: %v, received err: %v", userSession, a, e)
+		}
+	}
+
+	userSessions, e := rotatedService.ListUserSessions(previousUserSession.UserID)
+	if e != nil || len(userSessions) != 2 {
+		t.Errorf("test failed; expected 2 listed sessions, received: %v, received err: %v", userSessions, e)
+	}
+
+	if e := rotatedService.DeleteAllUserSessions(previousUserSession.UserID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting all user sessions, received: %v", e)
+	}
+	if userSessions, _ := rotatedService.ListUserSessions(previousUserSession.UserID); len(userSessions) != 0 {
+		t.Errorf("test failed; expected no sessions after deleting all user sessions, received: %v", userSessions)
+	}
+
+	currentOnlyService, _ := New(inner, Options{Keys: []Key{currentKey}})
+	previousService.SaveUserSession(previousUserSession)
+	if a, e := currentOnlyService.FetchValidUserSession(previousUserSession.ID); a != nil || e != ErrUnknownKey {
+		t.Errorf("test failed; expected err: %v, received: %v, received session: %v", ErrUnknownKey, e, a)
+	}
+}
+
+// TestOpenErrors tests that tampered and plaintext sessions are rejected
+func TestOpenErrors(t *testing.T) {
+	inner := memory.New(memory.Options{})
+	defer inner.Close()
+
+	s, _ := New(inner, Options{Keys: []Key{previousKey}})
+	plaintextService, _ := New(inner, Options{Keys: []Key{previousKey}, AllowPlaintext: true})
+
+	sealedUserSession := newUserSession("sealedSessionID")
+	s.SaveUserSession(sealedUserSession)
+	stored, _ := inner.FetchValidUserSession(sealedUserSession.ID)
+
+	// note: a sealed payload moved to another session must fail authentication
+	movedUserSession := *stored
+	movedUserSession.ID = "movedSessionID"
+	inner.SaveUserSession(&movedUserSession)
+
+	tamperedUserSession := *stored
+	tamperedUserSession.ID = "tamperedSessionID"
+	tamperedUserSession.JSON = stored.JSON[:len(stored.JSON)-2] + "AA"
+	inner.SaveUserSession(&tamperedUserSession)
+
+	plaintextUserSession := newUserSession("plaintextSessionID")
+	inner.SaveUserSession(plaintextUserSession)
+
+	var tests = []struct {
+		service      *Service
+		input        string
+		expectedJSON string
+		expectedErr  error
+	}{
+		{s, movedUserSession.ID, "", ErrOpeningSession},
+		{s, tamperedUserSession.ID, "", ErrOpeningSession},
+		{s, plaintextUserSession.ID, "", ErrOpeningSession},
+		{plaintextService, plaintextUserSession.ID, plaintextUserSession.JSON, nil},
+		{plaintextService, sealedUserSession.ID, sealedUserSession.JSON, nil},
+		{s, "missingSessionID", "", nil},
+	}
+
+	for idx, tt := range tests {
+		a, e := tt.service.FetchValidUserSession(tt.input)
+		var json string
+		if a != nil {
+			json = a.JSON
+		}
+
+		if e != tt.expectedErr || json != tt.expectedJSON {
+			t.Errorf("test #%d failed; input: %s, expected err: %v, expected json: %s, received err: %v, received json: %s", idx+1, tt.input, tt.expectedErr, tt.expectedJSON, e, json)
+		}
+	}
+}
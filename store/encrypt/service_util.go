@@ -0,0 +1,161 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/adam-hanna/sessions/user"
+)
+
+const (
+	// sealedPrefix marks sealed values, which are formatted as sealedPrefix + key id + ":" + base64(nonce + \
+	// ciphertext)
+	sealedPrefix = "enc:"
+	// userIDAdditionalData is authenticated with sealed user ids
+	userIDAdditionalData = "UserID"
+)
+
+var validKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// newKey parses a key of the keyring
+func newKey(k Key) (*key, error) {
+	block, err := aes.NewCipher(k.Secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// note: derive a separate key for nonces, so the aes key is only ever used by gcm
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write([]byte("sessions user id nonce"))
+
+	return &key{
+		id:       k.ID,
+		aead:     aead,
+		nonceKey: mac.Sum(nil),
+	}, nil
+}
+
+// seal returns a copy of a session with its payload and data, and optionally its user id, sealed with the primary \
+// key
+func (s *Service) seal(userSession *user.Session) (*user.Session, error) {
+	sealedUserSession := userSession.Copy()
+
+	var err error
+	// note: the session id is authenticated, so a sealed payload can't be moved to another session
+	if sealedUserSession.JSON, err = s.sealValue(userSession.JSON, jsonAdditionalData(userSession.ID)); err != nil {
+		return nil, err
+	}
+	for name, value := range userSession.Data {
+		if sealedUserSession.Data[name], err = s.sealValue(value, dataAdditionalData(userSession.ID, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.options.EncryptUserID {
+		sealedUserSession.UserID = s.primary.sealUserID(userSession.UserID)
+	}
+
+	return sealedUserSession, nil
+}
+
+// sealValue seals a value with the primary key and a random nonce
+func (s *Service) sealValue(value string, additionalData []byte) (string, error) {
+	nonce := make([]byte, s.primary.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return s.primary.seal(nonce, value, additionalData), nil
+}
+
+// open returns a copy of a session with its sealed values opened
+func (s *Service) open(sealedUserSession *user.Session) (*user.Session, error) {
+	userSession := sealedUserSession.Copy()
+
+	var err error
+	if userSession.JSON, err = s.openValue(sealedUserSession.JSON, jsonAdditionalData(sealedUserSession.ID)); err != nil {
+		return nil, err
+	}
+	for name, value := range sealedUserSession.Data {
+		if userSession.Data[name], err = s.openValue(value, dataAdditionalData(sealedUserSession.ID, name)); err != nil {
+			return nil, err
+		}
+	}
+	if s.options.EncryptUserID {
+		if userSession.UserID, err = s.openValue(sealedUserSession.UserID, []byte(userIDAdditionalData)); err != nil {
+			return nil, err
+		}
+	}
+
+	return userSession, nil
+}
+
+// openValue opens a sealed value with the key that sealed it
+func (s *Service) openValue(value string, additionalData []byte) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		if s.options.AllowPlaintext {
+			return value, nil
+		}
+		return "", ErrOpeningSession
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, sealedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", ErrOpeningSession
+	}
+	k, ok := s.keys[parts[0]]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return "", ErrOpeningSession
+	}
+
+	nonceSize := k.aead.NonceSize()
+	plaintext, err := k.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData)
+	if err != nil {
+		return "", ErrOpeningSession
+	}
+
+	return string(plaintext), nil
+}
+
+// seal seals plaintext with nonce and formats the result
+func (k *key) seal(nonce []byte, plaintext string, additionalData []byte) string {
+	sealed := k.aead.Seal(nonce, nonce, []byte(plaintext), additionalData)
+	return sealedPrefix + k.id + ":" + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// sealUserID seals a user id with a nonce derived from it, so a user id always seals to the same value
+func (k *key) sealUserID(userID string) string {
+	mac := hmac.New(sha256.New, k.nonceKey)
+	mac.Write([]byte(userID))
+	nonce := mac.Sum(nil)[:k.aead.NonceSize()]
+
+	return k.seal(nonce, userID, []byte(userIDAdditionalData))
+}
+
+// jsonAdditionalData returns the data authenticated with a session's sealed payload
+func jsonAdditionalData(sessionID string) []byte {
+	return []byte("JSON:" + sessionID)
+}
+
+// dataAdditionalData returns the data authenticated with a sealed field of a session's Data, so it can't be moved \
+// to another session or field. The session id and field name are separated by a nul byte, which session ids don't \
+// contain
+func dataAdditionalData(sessionID string, name string) []byte {
+	return []byte("Data:" + sessionID + "\x00" + name)
+}
@@ -0,0 +1,33 @@
+// +build unit
+
+package encrypt
+
+import (
+	"testing"
+)
+
+// TestSealUserID tests that user ids seal deterministically per key
+func TestSealUserID(t *testing.T) {
+	previous, _ := newKey(previousKey)
+	current, _ := newKey(currentKey)
+
+	var tests = []struct {
+		inputA        string
+		inputB        string
+		keyA          *key
+		keyB          *key
+		expectedEqual bool
+	}{
+		{"userID", "userID", previous, previous, true},
+		{"userID", "otherUserID", previous, previous, false},
+		{"userID", "userID", previous, current, false},
+	}
+
+	for idx, tt := range tests {
+		equal := tt.keyA.sealUserID(tt.inputA) == tt.keyB.sealUserID(tt.inputB)
+
+		if equal != tt.expectedEqual {
+			t.Errorf("test #%d failed; expected equal: %t, received: %t", idx+1, tt.expectedEqual, equal)
+		}
+	}
+}
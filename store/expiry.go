@@ -0,0 +1,274 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// expiryListenerRetryDuration is the duration to wait before re-subscribing after a subscription failed
+	expiryListenerRetryDuration = 1 * time.Second
+	// shadowKeyGraceDuration is how long a shadow key outlives its session, see Options.ExpiryShadowKeys
+	shadowKeyGraceDuration = 1 * time.Minute
+)
+
+// ExpiryEvent reports that a session expired in the store
+type ExpiryEvent struct {
+	SessionID string
+	// UserID is the id of the user the session belonged to. It is empty unless the store writes shadow keys, see \
+	// Options.ExpiryShadowKeys, and the shadow key was found
+	UserID   string
+	TenantID string
+}
+
+// ExpiryListener reports sessions that expire in a redis store, using redis keyspace notifications. Sessions that \
+// are deleted are not reported.
+//
+// Redis only publishes keyspace notifications if they are enabled, e.g. with "notify-keyspace-events Ex" in \
+// redis.conf, or with ConfigureNotifications. Notifications are delivered to every subscriber, so each instance of \
+// an app that listens receives every event.
+type ExpiryListener struct {
+	store   *Service
+	options ExpiryListenerOptions
+
+	mu       sync.Mutex
+	pscs     map[*redis.PubSubConn]struct{}
+	stopping bool
+	closed   bool
+	done     chan struct{}
+}
+
+// ExpiryListenerOptions defines the behavior of the expiry listener
+type ExpiryListenerOptions struct {
+	// ErrorHandler, if set, is called with errors returned when subscribing to notifications or fetching the user \
+	// id of an expired session. The event is delivered anyways, without a user id
+	ErrorHandler func(error)
+}
+
+// NewExpiryListener returns a listener for the sessions that expire in s's namespace. Listening holds one \
+// connection of the pool, or of each master node's pool in cluster mode.
+func NewExpiryListener(s *Service, options ExpiryListenerOptions) *ExpiryListener {
+	return &ExpiryListener{
+		store:   s,
+		options: options,
+		pscs:    make(map[*redis.PubSubConn]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// ConfigureNotifications enables notifications of expired keys with CONFIG SET, on the redis server or on every \
+// master node in cluster mode. Notification classes that are already enabled are kept. Managed redis services \
+// often disallow CONFIG, in which case notifications must be enabled in the service's settings.
+func (l *ExpiryListener) ConfigureNotifications() error {
+	configure := func(c redis.Conn) error {
+		values, err := redis.Strings(c.Do("CONFIG", "GET", "notify-keyspace-events"))
+		if err != nil {
+			return err
+		}
+
+		flags := ""
+		if len(values) == 2 {
+			flags = values[1]
+		}
+		flags = notificationFlags(flags)
+		if len(values) == 2 && flags == values[1] {
+			return nil
+		}
+
+		_, err = c.Do("CONFIG", "SET", "notify-keyspace-events", flags)
+		return err
+	}
+
+	if l.store.cluster != nil {
+		return l.store.cluster.EachNode(false, func(_ string, c redis.Conn) error {
+			return configure(c)
+		})
+	}
+
+	c := l.store.Pool.Get()
+	defer c.Close()
+
+	return configure(c)
+}
+
+// Listen calls handle with an ExpiryEvent for every session of the store's namespace that expires, until Close is \
+// called. If a subscription fails, Listen re-subscribes; sessions that expire in the meantime are not reported. In \
+// cluster mode, Listen subscribes to every master node, but handle is never called concurrently.
+func (l *ExpiryListener) Listen(handle func(event ExpiryEvent)) {
+	var mu sync.Mutex
+	serializedHandle := func(event ExpiryEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		handle(event)
+	}
+
+	for {
+		l.listenRound(serializedHandle)
+
+		select {
+		case <-l.done:
+			return
+		case <-time.After(expiryListenerRetryDuration):
+		}
+	}
+}
+
+// Close stops listening
+func (l *ExpiryListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	l.unsubscribeLocked()
+
+	return nil
+}
+
+// listenRound subscribes to the notifications of the redis server, or of every master node in cluster mode, and \
+// handles them until a subscription ends
+func (l *ExpiryListener) listenRound(handle func(event ExpiryEvent)) {
+	l.mu.Lock()
+	l.stopping = false
+	l.mu.Unlock()
+
+	if l.store.cluster == nil {
+		c := l.store.Pool.Get()
+		defer c.Close()
+
+		l.subscribe(c, handle)
+		return
+	}
+
+	addresses, err := l.store.masterAddresses()
+	if err != nil {
+		l.handleError(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+
+			// note: end the other subscriptions too, so the next round subscribes to the current master nodes
+			defer l.unsubscribe()
+
+			if err := l.store.cluster.EachNode(false, func(nodeAddress string, c redis.Conn) error {
+				if nodeAddress == address {
+					l.subscribe(c, handle)
+				}
+				return nil
+			}); err != nil {
+				l.handleError(err)
+			}
+		}(address)
+	}
+	wg.Wait()
+}
+
+// subscribe subscribes to expired key notifications on c and handles them until the subscription ends
+func (l *ExpiryListener) subscribe(c redis.Conn, handle func(event ExpiryEvent)) {
+	psc := &redis.PubSubConn{Conn: c}
+
+	l.mu.Lock()
+	if l.closed || l.stopping {
+		l.mu.Unlock()
+		return
+	}
+	if err := psc.PSubscribe(expiredEventsPattern(l.store.database)); err != nil {
+		l.mu.Unlock()
+		l.handleError(err)
+		return
+	}
+	l.pscs[psc] = struct{}{}
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		delete(l.pscs, psc)
+		l.mu.Unlock()
+	}()
+
+	for {
+		// note: wait for notifications without the connection's read timeout, see Options.ReadTimeoutDuration
+		switch v := psc.ReceiveWithTimeout(0).(type) {
+		case redis.Message:
+			l.handleExpiredKey(string(v.Data), handle)
+		case redis.Subscription:
+			if v.Count == 0 {
+				return
+			}
+		case error:
+			l.mu.Lock()
+			stopped := l.closed || l.stopping
+			l.mu.Unlock()
+			if !stopped {
+				l.handleError(v)
+			}
+			return
+		}
+	}
+}
+
+// handleExpiredKey calls handle with an ExpiryEvent if key was the key of a session of the store's namespace
+func (l *ExpiryListener) handleExpiredKey(key string, handle func(event ExpiryEvent)) {
+	sessionID, ok := l.store.sessionIDFromKey(key)
+	if !ok {
+		return
+	}
+
+	event := ExpiryEvent{
+		SessionID: sessionID,
+		TenantID:  l.store.tenantID,
+	}
+
+	if l.store.expiryShadowKeys {
+		shadowKey := l.store.shadowKey(sessionID)
+		if err := l.store.withConn(context.Background(), shadowKey, func(c redis.Conn) error {
+			userID, err := redis.String(c.Do("GET", shadowKey))
+			if err != nil && err != redis.ErrNil {
+				return err
+			}
+
+			event.UserID = userID
+			return nil
+		}); err != nil {
+			l.handleError(err)
+		}
+	}
+
+	handle(event)
+}
+
+// unsubscribe ends the current subscriptions
+func (l *ExpiryListener) unsubscribe() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.unsubscribeLocked()
+}
+
+// unsubscribeLocked ends the current subscriptions, and keeps new ones from starting until the next round. The \
+// caller must hold the lock
+func (l *ExpiryListener) unsubscribeLocked() {
+	l.stopping = true
+	for psc := range l.pscs {
+		// note: unsubscribing makes the blocked Receive in subscribe return
+		psc.PUnsubscribe()
+	}
+}
+
+// handleError calls the ErrorHandler with err, if one is set
+func (l *ExpiryListener) handleError(err error) {
+	if l.options.ErrorHandler != nil {
+		l.options.ErrorHandler(err)
+	}
+}
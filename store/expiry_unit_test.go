@@ -0,0 +1,91 @@
+// +build unit
+
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeNotificationsHandler returns a handler for a fake redis server that accepts subscriptions and holds the \
+// shadow key of sessionID
+func fakeNotificationsHandler(shadowKey string, userID string) func(args []string) interface{} {
+	return func(args []string) interface{} {
+		switch args[0] {
+		case "PSUBSCRIBE":
+			return []interface{}{"psubscribe", args[1], int64(1)}
+		case "PUNSUBSCRIBE":
+			return []interface{}{"punsubscribe", expiredEventsPattern(0), int64(0)}
+		case "GET":
+			if args[1] == shadowKey {
+				return userID
+			}
+			return nil
+		}
+		return fmt.Errorf("ERR unknown command '%s'", args[0])
+	}
+}
+
+// TestExpiryListener tests that expired session keys of the store's namespace are reported with their user id
+func TestExpiryListener(t *testing.T) {
+	f := newFakeRedisServer(t, fakeNotificationsHandler("sessions:acme:expiryShadow:sessionID", "userID"))
+	defer f.close()
+
+	s := New(Options{ConnectionAddress: f.addr(), KeyPrefix: "sessions:", TenantID: "acme", ExpiryShadowKeys: true})
+	defer s.Close()
+
+	var errs []error
+	l := NewExpiryListener(s, ExpiryListenerOptions{ErrorHandler: func(err error) { errs = append(errs, err) }})
+	events := make(chan ExpiryEvent, 10)
+	done := make(chan struct{})
+	go func() {
+		l.Listen(func(event ExpiryEvent) { events <- event })
+		close(done)
+	}()
+
+	for start := time.Now(); f.subscriptions() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("listener did not subscribe")
+		}
+	}
+
+	var tests = []struct {
+		input         string
+		expectedEvent *ExpiryEvent
+	}{
+		{"sessions:acme:sessionID", &ExpiryEvent{SessionID: "sessionID", UserID: "userID", TenantID: "acme"}},
+		{"sessions:acme:userSessions:userID", nil},
+		{"sessions:acme:expiryShadow:sessionID", nil},
+		{"sessions:other:sessionID", nil},
+		{"sessionID", nil},
+		{"sessions:acme:unshadowedSessionID", &ExpiryEvent{SessionID: "unshadowedSessionID", TenantID: "acme"}},
+	}
+
+	for idx, tt := range tests {
+		f.push([]interface{}{"pmessage", expiredEventsPattern(0), "__keyevent@0__:expired", tt.input})
+		if tt.expectedEvent == nil {
+			continue
+		}
+
+		select {
+		case a := <-events:
+			if a != *tt.expectedEvent {
+				t.Errorf("test #%d failed; input: %s, expected event: %v, received: %v", idx+1, tt.input, *tt.expectedEvent, a)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("test #%d failed; input: %s, expected event: %v, received none", idx+1, tt.input, *tt.expectedEvent)
+		}
+	}
+
+	if len(events) != 0 || len(errs) != 0 {
+		t.Errorf("test failed; expected no other events and no errs, received events: %d, errs: %v", len(events), errs)
+	}
+
+	l.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Errorf("test failed; expected Listen to return after Close")
+	}
+}
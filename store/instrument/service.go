@@ -0,0 +1,278 @@
+package instrument
+
+import (
+	"context"
+	"time"
+
+	"github.com/adam-hanna/sessions/metrics"
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// Service is a session store that records the latency and errors of every operation of another store. Expected \
+// outcomes, like a session that is already locked or was saved concurrently, are not counted as errors
+type Service struct {
+	store   store.ServiceInterface
+	metrics metrics.ServiceInterface
+}
+
+// New returns a new instrumented store in front of s, that records its operations with m
+func New(s store.ServiceInterface, m metrics.ServiceInterface) *Service {
+	return &Service{
+		store:   s,
+		metrics: m,
+	}
+}
+
+// SaveUserSession saves a user session in the store
+func (s *Service) SaveUserSession(userSession *user.Session) error {
+	return s.SaveUserSessionContext(context.Background(), userSession)
+}
+
+// SaveUserSessionContext is like SaveUserSession, but passes ctx to the store if it supports it
+func (s *Service) SaveUserSessionContext(ctx context.Context, userSession *user.Session) error {
+	start := time.Now()
+
+	var err error
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		err = contextStore.SaveUserSessionContext(ctx, userSession)
+	} else {
+		err = s.store.SaveUserSession(userSession)
+	}
+
+	s.observe(metrics.OperationSave, start, err)
+	return err
+}
+
+// SaveUserSessionIfVersion saves a user session in the store if the stored session's version is version. The \
+// store must implement store.VersionedServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) SaveUserSessionIfVersion(userSession *user.Session, version int64) error {
+	versionedStore, ok := s.store.(store.VersionedServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	start := time.Now()
+	err := versionedStore.SaveUserSessionIfVersion(userSession, version)
+
+	s.observe(metrics.OperationSaveIfVersion, start, err)
+	return err
+}
+
+// SetUserSessionField sets a named field of a stored session's Data. The store must implement \
+// store.FieldServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) SetUserSessionField(sessionID string, name string, value string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	start := time.Now()
+	err := fieldStore.SetUserSessionField(sessionID, name, value)
+
+	s.observe(metrics.OperationSetField, start, err)
+	return err
+}
+
+// DeleteUserSessionField deletes a named field from a stored session's Data. The store must implement \
+// store.FieldServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) DeleteUserSessionField(sessionID string, name string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	start := time.Now()
+	err := fieldStore.DeleteUserSessionField(sessionID, name)
+
+	s.observe(metrics.OperationDeleteField, start, err)
+	return err
+}
+
+// SaveUserSessionWithLimit saves a new user session in the store, with a limit on its user's sessions. The store \
+// must implement store.LimitServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) SaveUserSessionWithLimit(ctx context.Context, userSession *user.Session, limit int, evict bool) ([]string, error) {
+	limitStore, ok := s.store.(store.LimitServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	start := time.Now()
+	evictedSessionIDs, err := limitStore.SaveUserSessionWithLimit(ctx, userSession, limit, evict)
+
+	s.observe(metrics.OperationSaveWithLimit, start, err)
+	return evictedSessionIDs, err
+}
+
+// TouchUserSession ranks a session as its user's most recently used session in the store. The store must \
+// implement store.LimitServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) TouchUserSession(ctx context.Context, userSession *user.Session) error {
+	limitStore, ok := s.store.(store.LimitServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	start := time.Now()
+	err := limitStore.TouchUserSession(ctx, userSession)
+
+	s.observe(metrics.OperationTouch, start, err)
+	return err
+}
+
+// RegenerateUserSession moves a session to a new id in the store. The store must implement \
+// store.RegenerateServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) RegenerateUserSession(ctx context.Context, sessionID string, userSession *user.Session) error {
+	regenerateStore, ok := s.store.(store.RegenerateServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	start := time.Now()
+	err := regenerateStore.RegenerateUserSession(ctx, sessionID, userSession)
+
+	s.observe(metrics.OperationRegenerate, start, err)
+	return err
+}
+
+// LockUserSession acquires the lock of a session in the store and observes the attempt as a lock operation. The \
+// store must implement store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) LockUserSession(ctx context.Context, sessionID string, ttl time.Duration) (int64, error) {
+	lockStore, ok := s.store.(store.LockServiceInterface)
+	if !ok {
+		return 0, store.ErrNotSupported
+	}
+
+	start := time.Now()
+	token, err := lockStore.LockUserSession(ctx, sessionID, ttl)
+
+	s.observe(metrics.OperationLock, start, err)
+	return token, err
+}
+
+// UnlockUserSession releases the lock of a session in the store and observes the attempt as an unlock operation. \
+// The store must implement store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) UnlockUserSession(ctx context.Context, sessionID string, token int64) error {
+	lockStore, ok := s.store.(store.LockServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	start := time.Now()
+	err := lockStore.UnlockUserSession(ctx, sessionID, token)
+
+	s.observe(metrics.OperationUnlock, start, err)
+	return err
+}
+
+// Ping checks whether the store can be reached. The store must implement store.HealthServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+func (s *Service) Ping(ctx context.Context) error {
+	healthStore, ok := s.store.(store.HealthServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	start := time.Now()
+	err := healthStore.Ping(ctx)
+
+	s.observe(metrics.OperationPing, start, err)
+	return err
+}
+
+// DeleteUserSession deletes a user session from the store
+func (s *Service) DeleteUserSession(sessionID string) error {
+	return s.DeleteUserSessionContext(context.Background(), sessionID)
+}
+
+// DeleteUserSessionContext is like DeleteUserSession, but passes ctx to the store if it supports it
+func (s *Service) DeleteUserSessionContext(ctx context.Context, sessionID string) error {
+	start := time.Now()
+
+	var err error
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		err = contextStore.DeleteUserSessionContext(ctx, sessionID)
+	} else {
+		err = s.store.DeleteUserSession(sessionID)
+	}
+
+	s.observe(metrics.OperationDelete, start, err)
+	return err
+}
+
+// FetchValidUserSession fetches a user session from the store. If a valid session does not exist, this function \
+// returns a nil pointer
+func (s *Service) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	return s.FetchValidUserSessionContext(context.Background(), sessionID)
+}
+
+// FetchValidUserSessionContext is like FetchValidUserSession, but passes ctx to the store if it supports it
+func (s *Service) FetchValidUserSessionContext(ctx context.Context, sessionID string) (*user.Session, error) {
+	start := time.Now()
+
+	var userSession *user.Session
+	var err error
+	if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+		userSession, err = contextStore.FetchValidUserSessionContext(ctx, sessionID)
+	} else {
+		userSession, err = s.store.FetchValidUserSession(sessionID)
+	}
+
+	s.observe(metrics.OperationFetch, start, err)
+	return userSession, err
+}
+
+// ListUserSessions returns all valid sessions of a user from the store. The store must implement \
+// store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) ListUserSessions(userID string) ([]*user.Session, error) {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	start := time.Now()
+	userSessions, err := userStore.ListUserSessions(userID)
+
+	s.observe(metrics.OperationList, start, err)
+	return userSessions, err
+}
+
+// DeleteAllUserSessions deletes all sessions of a user from the store. The store must implement \
+// store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) DeleteAllUserSessions(userID string) error {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	start := time.Now()
+	err := userStore.DeleteAllUserSessions(userID)
+
+	s.observe(metrics.OperationDeleteAll, start, err)
+	return err
+}
+
+// Scan returns a batch of valid sessions from the store. The store must implement store.ScanServiceInterface, \
+// otherwise store.ErrNotSupported is returned.
+func (s *Service) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	scanStore, ok := s.store.(store.ScanServiceInterface)
+	if !ok {
+		return nil, "", store.ErrNotSupported
+	}
+
+	start := time.Now()
+	userSessions, next, err := scanStore.Scan(cursor, count)
+
+	s.observe(metrics.OperationScan, start, err)
+	return userSessions, next, err
+}
+
+// observe records the duration of a store operation started at start. Errors that report an expected outcome, e.g. \
+// lock contention, a version conflict that is retried or a reached session limit, are observed as successes
+func (s *Service) observe(operation string, start time.Time, err error) {
+	switch err {
+	case store.ErrLocked, store.ErrLockNotHeld, store.ErrVersionConflict, store.ErrSessionLimitReached:
+		err = nil
+	}
+
+	s.metrics.ObserveStoreOperation(operation, time.Since(start), err)
+}
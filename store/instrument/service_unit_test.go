@@ -0,0 +1,146 @@
+// +build unit
+
+package instrument
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/metrics"
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/store/memory"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// note: this will fail to compile if the instrumented store does not implement the store interfaces
+var (
+	_ store.ServiceInterface           = (*Service)(nil)
+	_ store.ContextServiceInterface    = (*Service)(nil)
+	_ store.UserServiceInterface       = (*Service)(nil)
+	_ store.ScanServiceInterface       = (*Service)(nil)
+	_ store.VersionedServiceInterface  = (*Service)(nil)
+	_ store.FieldServiceInterface      = (*Service)(nil)
+	_ store.LockServiceInterface       = (*Service)(nil)
+	_ store.HealthServiceInterface     = (*Service)(nil)
+	_ store.LimitServiceInterface      = (*Service)(nil)
+	_ store.RegenerateServiceInterface = (*Service)(nil)
+)
+
+// observation is a recorded store operation
+type observation struct {
+	operation string
+	err       error
+}
+
+// mockedMetrics records the observed store operations
+type mockedMetrics struct {
+	observations []observation
+}
+
+func (m *mockedMetrics) CountEvent(event string) {
+}
+
+func (m *mockedMetrics) ObserveStoreOperation(operation string, duration time.Duration, err error) {
+	m.observations = append(m.observations, observation{operation: operation, err: err})
+}
+
+// basicStore only implements store.ServiceInterface
+type basicStore struct {
+	store.ServiceInterface
+}
+
+// TestOperations tests that every store operation is observed
+func TestOperations(t *testing.T) {
+	inner := memory.New(memory.Options{})
+	defer inner.Close()
+
+	userSession := &user.Session{
+		ID:        "sessionID",
+		UserID:    "userID",
+		JSON:      "json",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+
+	ctx := context.Background()
+	var token int64
+	var err error
+
+	var tests = []struct {
+		run               func(s *Service) error
+		expectedOperation string
+		expectedErr       error
+	}{
+		{func(s *Service) error { return s.SaveUserSession(userSession) }, metrics.OperationSave, nil},
+		{func(s *Service) error { return s.SaveUserSessionIfVersion(userSession, userSession.Version) }, metrics.OperationSaveIfVersion, nil},
+		{func(s *Service) error { return s.SetUserSessionField(userSession.ID, "cart", "1") }, metrics.OperationSetField, nil},
+		{func(s *Service) error { return s.DeleteUserSessionField(userSession.ID, "cart") }, metrics.OperationDeleteField, nil},
+		{func(s *Service) error { token, err = s.LockUserSession(ctx, userSession.ID, 1*time.Second); return err }, metrics.OperationLock, nil},
+		{func(s *Service) error { return s.UnlockUserSession(ctx, userSession.ID, token) }, metrics.OperationUnlock, nil},
+		{func(s *Service) error { return s.Ping(ctx) }, metrics.OperationPing, nil},
+		{func(s *Service) error { _, err := s.SaveUserSessionWithLimit(ctx, userSession, 1, false); return err }, metrics.OperationSaveWithLimit, nil},
+		{func(s *Service) error { return s.TouchUserSession(ctx, userSession) }, metrics.OperationTouch, nil},
+		{func(s *Service) error { return s.RegenerateUserSession(ctx, "previousSessionID", userSession) }, metrics.OperationRegenerate, nil},
+		{func(s *Service) error { _, err := s.FetchValidUserSession(userSession.ID); return err }, metrics.OperationFetch, nil},
+		{func(s *Service) error { _, err := s.ListUserSessions(userSession.UserID); return err }, metrics.OperationList, nil},
+		{func(s *Service) error { return s.DeleteUserSession(userSession.ID) }, metrics.OperationDelete, nil},
+		{func(s *Service) error { return s.DeleteAllUserSessions(userSession.UserID) }, metrics.OperationDeleteAll, nil},
+		{func(s *Service) error { _, _, err := s.Scan("", 0); return err }, metrics.OperationScan, nil},
+	}
+
+	for idx, tt := range tests {
+		m := &mockedMetrics{}
+		s := New(inner, m)
+
+		e := tt.run(s)
+		if e != tt.expectedErr || len(m.observations) != 1 || m.observations[0].operation != tt.expectedOperation {
+			t.Errorf("test #%d failed; expected operation: %s, received observations: %v, received err: %v", idx+1, tt.expectedOperation, m.observations, e)
+		}
+	}
+
+	m := &mockedMetrics{}
+	s := New(&basicStore{inner}, m)
+	if _, e := s.ListUserSessions(userSession.UserID); e != store.ErrNotSupported || len(m.observations) != 0 {
+		t.Errorf("test failed; expected err: %v and no observations, received err: %v, observations: %v", store.ErrNotSupported, e, m.observations)
+	}
+}
+
+// TestExpectedErrors tests that expected outcomes are returned to the caller, but not observed as store errors
+func TestExpectedErrors(t *testing.T) {
+	inner := memory.New(memory.Options{})
+	defer inner.Close()
+
+	userSession := &user.Session{
+		ID:        "sessionID",
+		UserID:    "userID",
+		JSON:      "json",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	inner.SaveUserSession(userSession)
+	inner.SaveUserSession(&user.Session{ID: "otherSessionID", UserID: userSession.UserID, JSON: "json", ExpiresAt: userSession.ExpiresAt})
+	inner.LockUserSession(context.Background(), userSession.ID, 1*time.Hour)
+
+	ctx := context.Background()
+
+	var tests = []struct {
+		run              func(s *Service) error
+		expectedErr      error
+		expectedObserved error
+	}{
+		{func(s *Service) error { _, err := s.LockUserSession(ctx, userSession.ID, 1*time.Second); return err }, store.ErrLocked, nil},
+		{func(s *Service) error { return s.UnlockUserSession(ctx, userSession.ID, -1) }, store.ErrLockNotHeld, nil},
+		{func(s *Service) error { return s.SaveUserSessionIfVersion(userSession, -1) }, store.ErrVersionConflict, nil},
+		{func(s *Service) error { _, err := s.SaveUserSessionWithLimit(ctx, userSession, 1, false); return err }, store.ErrSessionLimitReached, nil},
+		{func(s *Service) error { return s.SetUserSessionField("missingSessionID", "cart", "1") }, store.ErrSessionNotFound, store.ErrSessionNotFound},
+	}
+
+	for idx, tt := range tests {
+		m := &mockedMetrics{}
+		s := New(inner, m)
+
+		e := tt.run(s)
+		if e != tt.expectedErr || len(m.observations) != 1 || m.observations[0].err != tt.expectedObserved {
+			t.Errorf("test #%d failed; expected err: %v, expected observed err: %v, received err: %v, received observations: %v", idx+1, tt.expectedErr, tt.expectedObserved, e, m.observations)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"github.com/adam-hanna/sessions/user"
+)
+
+// Iterator iterates over the valid sessions of a store, fetching them in batches with Scan:
+//
+// 	it := store.NewIterator(s, 0)
+// 	for it.Next() {
+// 		userSession := it.Session()
+// 		...
+// 	}
+// 	if err := it.Err(); err != nil {
+// 		...
+// 	}
+//
+// Like Scan, an Iterator may or may not return sessions saved or deleted while it runs, and may return a session \
+// more than once. Sessions may be deleted from the store while iterating.
+type Iterator struct {
+	store ScanServiceInterface
+	count int
+
+	batch   []*user.Session
+	idx     int
+	cursor  string
+	started bool
+	err     error
+	current *user.Session
+}
+
+// NewIterator returns an iterator over the sessions of s, that fetches batches of about count sessions. If count \
+// is not positive, DefaultScanCount is used.
+func NewIterator(s ScanServiceInterface, count int) *Iterator {
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	return &Iterator{
+		store: s,
+		count: count,
+	}
+}
+
+// Next advances the iterator to the next session, which is then available from Session. It returns false once \
+// there are no more sessions, or if an error occurred, which is then available from Err.
+func (it *Iterator) Next() bool {
+	// note: a batch may be empty before the scan is complete
+	for it.idx >= len(it.batch) {
+		if it.err != nil || (it.started && it.cursor == "") {
+			it.current = nil
+			return false
+		}
+
+		batch, next, err := it.store.Scan(it.cursor, it.count)
+		it.started = true
+		if err != nil {
+			it.err = err
+			it.current = nil
+			return false
+		}
+		it.batch, it.idx, it.cursor = batch, 0, next
+	}
+
+	it.current = it.batch[it.idx]
+	it.idx++
+	return true
+}
+
+// Session returns the current session
+func (it *Iterator) Session() *user.Session {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
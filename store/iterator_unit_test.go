@@ -0,0 +1,65 @@
+// +build unit
+
+package store
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/adam-hanna/sessions/user"
+)
+
+// pagedStore returns its batches in order, the cursor being the index of the next batch
+type pagedStore struct {
+	batches [][]*user.Session
+	err     error
+}
+
+func (p *pagedStore) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	idx := 0
+	if cursor != "" {
+		idx, _ = strconv.Atoi(cursor)
+	}
+	if idx == len(p.batches)-1 && p.err != nil {
+		return nil, "", p.err
+	}
+
+	next := ""
+	if idx+1 < len(p.batches) {
+		next = strconv.Itoa(idx + 1)
+	}
+
+	return p.batches[idx], next, nil
+}
+
+// TestIterator tests that the iterator returns the sessions of every batch, in order
+func TestIterator(t *testing.T) {
+	errTest := errors.New("test err")
+	a := &user.Session{ID: "a"}
+	b := &user.Session{ID: "b"}
+	c := &user.Session{ID: "c"}
+
+	var tests = []struct {
+		input       *pagedStore
+		expectedIDs string
+		expectedErr error
+	}{
+		{&pagedStore{batches: [][]*user.Session{{}}}, "", nil},
+		{&pagedStore{batches: [][]*user.Session{{a, b}, {c}}}, "abc", nil},
+		{&pagedStore{batches: [][]*user.Session{{a}, {}, {}, {b, c}}}, "abc", nil},
+		{&pagedStore{batches: [][]*user.Session{{a}, {b}, {c}}, err: errTest}, "ab", errTest},
+	}
+
+	for idx, tt := range tests {
+		ids := ""
+		it := NewIterator(tt.input, 0)
+		for it.Next() {
+			ids += it.Session().ID
+		}
+
+		if ids != tt.expectedIDs || it.Err() != tt.expectedErr || it.Next() || it.Session() != nil {
+			t.Errorf("test #%d failed; expected ids: %s, err: %v, received ids: %s, err: %v", idx+1, tt.expectedIDs, tt.expectedErr, ids, it.Err())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minLocalLocksSweepSize is the number of locks a LocalLocks holds before it first drops expired locks
+const minLocalLocksSweepSize = 64
+
+// LocalLocks is a table of session locks for stores that live in a single process, like the memory and bolt \
+// stores. They can implement LockServiceInterface by delegating to it. The zero value is ready to use.
+type LocalLocks struct {
+	mu        sync.Mutex
+	locks     map[string]localLock
+	lastToken int64
+	// sweepAt is the number of locks at which expired locks are dropped next
+	sweepAt int
+}
+
+// localLock is a lock held in a LocalLocks
+type localLock struct {
+	token     int64
+	expiresAt time.Time
+}
+
+// LockUserSession acquires the lock of a session for ttl, and returns the lock's fencing token. If the session is \
+// already locked, ErrLocked is returned. See LockServiceInterface.
+func (l *LocalLocks) LockUserSession(ctx context.Context, sessionID string, ttl time.Duration) (int64, error) {
+	if ttl < time.Millisecond {
+		return 0, ErrInvalidLockTTL
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if lock, ok := l.locks[sessionID]; ok && lock.expiresAt.After(now) {
+		return 0, ErrLocked
+	}
+
+	// note: an expired lock is only replaced when its session is locked again, so drop the others once the table \
+	// has doubled since the last sweep. Acquiring stays O(1) amortized, and abandoned locks can't pile up
+	if len(l.locks) >= l.sweepAt {
+		l.sweep(now)
+	}
+
+	if l.locks == nil {
+		l.locks = make(map[string]localLock)
+	}
+	l.lastToken++
+	l.locks[sessionID] = localLock{
+		token:     l.lastToken,
+		expiresAt: now.Add(ttl),
+	}
+
+	return l.lastToken, nil
+}
+
+// UnlockUserSession releases the lock of a session if it is still held with token. Otherwise, ErrLockNotHeld is \
+// returned. See LockServiceInterface.
+func (l *LocalLocks) UnlockUserSession(ctx context.Context, sessionID string, token int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, ok := l.locks[sessionID]
+	if !ok || lock.token != token || !lock.expiresAt.After(time.Now()) {
+		return ErrLockNotHeld
+	}
+	delete(l.locks, sessionID)
+
+	return nil
+}
+
+// sweep drops the expired locks and sets the size of the table at which to sweep next.
+// note: l.mu must be held!
+func (l *LocalLocks) sweep(now time.Time) {
+	for id, lock := range l.locks {
+		if !lock.expiresAt.After(now) {
+			delete(l.locks, id)
+		}
+	}
+
+	l.sweepAt = 2 * len(l.locks)
+	if l.sweepAt < minLocalLocksSweepSize {
+		l.sweepAt = minLocalLocksSweepSize
+	}
+}
@@ -0,0 +1,88 @@
+// +build unit
+
+package store
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// note: this will fail to compile if LocalLocks does not implement the lock interface
+var _ LockServiceInterface = (*LocalLocks)(nil)
+
+// TestLocalLocks tests that a session can only be locked once at a time, and only unlocked by its holder
+func TestLocalLocks(t *testing.T) {
+	l := &LocalLocks{}
+	ctx := context.Background()
+
+	token, err := l.LockUserSession(ctx, "sessionID", 1*time.Hour)
+	if err != nil || token <= 0 {
+		t.Fatalf("test failed; expected the session to be locked, received token: %d, err: %v", token, err)
+	}
+	expiringToken, err := l.LockUserSession(ctx, "expiringSessionID", 1*time.Millisecond)
+	if err != nil || expiringToken <= token {
+		t.Fatalf("test failed; expected a larger token than %d, received token: %d, err: %v", token, expiringToken, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	var tests = []struct {
+		run         func() error
+		expectedErr error
+	}{
+		{func() error { _, err := l.LockUserSession(ctx, "sessionID", 1*time.Hour); return err }, ErrLocked},
+		{func() error { _, err := l.LockUserSession(ctx, "sessionID", 0); return err }, ErrInvalidLockTTL},
+		{func() error { _, err := l.LockUserSession(cancelled, "otherSessionID", 1*time.Hour); return err }, context.Canceled},
+		{func() error { return l.UnlockUserSession(ctx, "sessionID", token+100) }, ErrLockNotHeld},
+		{func() error { return l.UnlockUserSession(ctx, "expiringSessionID", expiringToken) }, ErrLockNotHeld},
+		{func() error { _, err := l.LockUserSession(ctx, "expiringSessionID", 1*time.Hour); return err }, nil},
+		{func() error { return l.UnlockUserSession(ctx, "sessionID", token) }, nil},
+		{func() error { return l.UnlockUserSession(ctx, "sessionID", token) }, ErrLockNotHeld},
+		{func() error { _, err := l.LockUserSession(ctx, "sessionID", 1*time.Hour); return err }, nil},
+	}
+
+	for idx, tt := range tests {
+		if e := tt.run(); e != tt.expectedErr {
+			t.Errorf("test #%d failed; expected err: %v, received: %v", idx+1, tt.expectedErr, e)
+		}
+	}
+}
+
+// TestLocalLocksSweep tests that expired locks are dropped once the table has grown, rather than on every acquire
+func TestLocalLocksSweep(t *testing.T) {
+	l := &LocalLocks{}
+	ctx := context.Background()
+
+	for idx := 0; idx < 1000; idx++ {
+		l.LockUserSession(ctx, "expiringSessionID"+strconv.Itoa(idx), 200*time.Millisecond)
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	var tests = []struct {
+		input         int
+		expectedLocks int
+	}{
+		{1, 1001},
+		{23, 1024},
+		{1, 25},
+		{1000, 1025},
+	}
+
+	var locked int
+	for idx, tt := range tests {
+		for n := 0; n < tt.input; n++ {
+			if _, err := l.LockUserSession(ctx, "sessionID"+strconv.Itoa(locked), 1*time.Hour); err != nil {
+				t.Fatalf("test #%d failed; expected err to be nil, received: %v", idx+1, err)
+			}
+			locked++
+		}
+
+		if a := len(l.locks); a != tt.expectedLocks {
+			t.Errorf("test #%d failed; input: %d, expected locks: %d, received: %d", idx+1, tt.input, tt.expectedLocks, a)
+		}
+	}
+}
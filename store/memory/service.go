@@ -0,0 +1,417 @@
+package memory
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+const (
+	// DefaultShardCount sets the default number of lock-protected maps that sessions are spread across
+	DefaultShardCount = 32
+	// DefaultSweepIntervalDuration sets the default duration between sweeps of expired sessions
+	DefaultSweepIntervalDuration = 1 * time.Minute
+)
+
+// Service is a session store that keeps sessions in process memory
+type Service struct {
+	shards []*shard
+	locks  store.LocalLocks
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+
+	// limitMu serializes saves with a limit, and guards the ranks of the sessions saved with a limit. Ranks are \
+	// drawn from lastRank, which increases with every limited save and touch
+	limitMu  sync.Mutex
+	ranks    map[string]int64
+	lastRank int64
+}
+
+// shard is a lock-protected subset of the stored sessions
+type shard struct {
+	mu       sync.RWMutex
+	sessions map[string]user.Session
+}
+
+// Options defines the behavior of the session store
+type Options struct {
+	// ShardCount is the number of lock-protected maps that sessions are spread across. More shards means less \
+	// lock contention between concurrent requests
+	ShardCount int
+	// SweepIntervalDuration is the duration between background sweeps that evict expired sessions
+	SweepIntervalDuration time.Duration
+}
+
+// New returns a new in-memory session store and starts its background expiry sweeper.
+// Close should be called to stop the sweeper once the store is no longer needed.
+func New(options Options) *Service {
+	setDefaultOptions(&options)
+
+	s := &Service{
+		shards: make([]*shard, options.ShardCount),
+		done:   make(chan struct{}),
+		ranks:  make(map[string]int64),
+	}
+	for idx := range s.shards {
+		s.shards[idx] = &shard{
+			sessions: make(map[string]user.Session),
+		}
+	}
+
+	s.wg.Add(1)
+	go s.sweep(options.SweepIntervalDuration)
+
+	return s
+}
+
+// SaveUserSession saves a user session in the store and increments its version
+func (s *Service) SaveUserSession(userSession *user.Session) error {
+	sh := s.shardFor(userSession.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.save(userSession)
+
+	return nil
+}
+
+// SaveUserSessionIfVersion saves a user session in the store, like SaveUserSession, but only if the stored \
+// session's version is version. Otherwise, store.ErrVersionConflict is returned. See \
+// store.VersionedServiceInterface.
+func (s *Service) SaveUserSessionIfVersion(userSession *user.Session, version int64) error {
+	sh := s.shardFor(userSession.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.version(userSession.ID) != version {
+		return store.ErrVersionConflict
+	}
+	sh.save(userSession)
+
+	return nil
+}
+
+// SetUserSessionField sets a named field of a stored session's Data and increments its version. If the session \
+// does not exist, store.ErrSessionNotFound is returned. See store.FieldServiceInterface.
+func (s *Service) SetUserSessionField(sessionID string, name string, value string) error {
+	return s.updateField(sessionID, func(userSession *user.Session) {
+		userSession.Set(name, value)
+	})
+}
+
+// DeleteUserSessionField deletes a named field from a stored session's Data and increments its version. If the \
+// session does not exist, store.ErrSessionNotFound is returned. See store.FieldServiceInterface.
+func (s *Service) DeleteUserSessionField(sessionID string, name string) error {
+	return s.updateField(sessionID, func(userSession *user.Session) {
+		userSession.Delete(name)
+	})
+}
+
+// SaveUserSessionWithLimit saves a new session if its user holds fewer than limit other valid sessions, or, if \
+// evict is set, after deleting the user's lowest ranked sessions. Limited saves are serialized, so concurrent \
+// logins can't exceed the limit. See store.LimitServiceInterface.
+func (s *Service) SaveUserSessionWithLimit(ctx context.Context, userSession *user.Session, limit int, evict bool) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+
+	userSessions, _ := s.ListUserSessions(userSession.UserID)
+	others := make([]*user.Session, 0, len(userSessions))
+	for _, other := range userSessions {
+		if other.ID != userSession.ID {
+			others = append(others, other)
+		}
+	}
+
+	var evictedSessionIDs []string
+	if excess := len(others) - limit + 1; excess > 0 {
+		if !evict {
+			return nil, store.ErrSessionLimitReached
+		}
+
+		// note: sessions that were never ranked rank lowest, and ties are broken by id
+		sort.Slice(others, func(i, j int) bool {
+			if s.ranks[others[i].ID] != s.ranks[others[j].ID] {
+				return s.ranks[others[i].ID] < s.ranks[others[j].ID]
+			}
+			return others[i].ID < others[j].ID
+		})
+		for _, evicted := range others[:excess] {
+			s.DeleteUserSession(evicted.ID)
+			delete(s.ranks, evicted.ID)
+			evictedSessionIDs = append(evictedSessionIDs, evicted.ID)
+		}
+	}
+
+	s.SaveUserSession(userSession)
+	s.lastRank++
+	s.ranks[userSession.ID] = s.lastRank
+
+	return evictedSessionIDs, nil
+}
+
+// TouchUserSession ranks a session as its user's most recently used session, so it is evicted last. See \
+// store.LimitServiceInterface.
+func (s *Service) TouchUserSession(ctx context.Context, userSession *user.Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+
+	if _, ok := s.ranks[userSession.ID]; ok {
+		s.lastRank++
+		s.ranks[userSession.ID] = s.lastRank
+	}
+
+	return nil
+}
+
+// RegenerateUserSession saves userSession, which carries a new id, and deletes the session with sessionID. The new \
+// id takes over the old id's rank. Sessions are saved before they are deleted, so there is no moment at which \
+// neither id is valid. See store.RegenerateServiceInterface.
+func (s *Service) RegenerateUserSession(ctx context.Context, sessionID string, userSession *user.Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+
+	s.SaveUserSession(userSession)
+	s.DeleteUserSession(sessionID)
+	if rank, ok := s.ranks[sessionID]; ok {
+		delete(s.ranks, sessionID)
+		s.ranks[userSession.ID] = rank
+	}
+
+	return nil
+}
+
+// LockUserSession acquires the lock of a session for ttl, and returns the lock's fencing token. Locks are held in \
+// process memory. See store.LockServiceInterface.
+func (s *Service) LockUserSession(ctx context.Context, sessionID string, ttl time.Duration) (int64, error) {
+	return s.locks.LockUserSession(ctx, sessionID, ttl)
+}
+
+// UnlockUserSession releases the lock of a session if it is still held with token. See store.LockServiceInterface.
+func (s *Service) UnlockUserSession(ctx context.Context, sessionID string, token int64) error {
+	return s.locks.UnlockUserSession(ctx, sessionID, token)
+}
+
+// DeleteUserSession deletes a user session from the store
+func (s *Service) DeleteUserSession(sessionID string) error {
+	sh := s.shardFor(sessionID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	delete(sh.sessions, sessionID)
+
+	return nil
+}
+
+// FetchValidUserSession returns a valid user session or an err if the session has expired or does not exist. \
+// If a valid session does not exist, this function should return a nil pointer
+func (s *Service) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	sh := s.shardFor(sessionID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	// note: the sweeper may not have evicted an expired session, yet
+	userSession, ok := sh.sessions[sessionID]
+	if !ok || !userSession.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+
+	return userSession.Copy(), nil
+}
+
+// ListUserSessions returns all valid sessions of a user
+func (s *Service) ListUserSessions(userID string) ([]*user.Session, error) {
+	now := time.Now()
+	userSessions := []*user.Session{}
+
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for _, userSession := range sh.sessions {
+			if userSession.UserID == userID && userSession.ExpiresAt.After(now) {
+				userSessions = append(userSessions, userSession.Copy())
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	return userSessions, nil
+}
+
+// DeleteAllUserSessions deletes all sessions of a user
+func (s *Service) DeleteAllUserSessions(userID string) error {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for sessionID, userSession := range sh.sessions {
+			if userSession.UserID == userID {
+				delete(sh.sessions, sessionID)
+			}
+		}
+		sh.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Scan returns the valid sessions of one or more shards, starting at the shard cursor, and the cursor of the next \
+// shard. Whole shards are returned until at least count sessions are collected. See store.ScanServiceInterface.
+func (s *Service) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	if count <= 0 {
+		count = store.DefaultScanCount
+	}
+
+	shardIdx := 0
+	if cursor != "" {
+		var err error
+		if shardIdx, err = strconv.Atoi(cursor); err != nil || shardIdx < 0 || shardIdx >= len(s.shards) {
+			return nil, "", store.ErrInvalidCursor
+		}
+	}
+
+	now := time.Now()
+	userSessions := []*user.Session{}
+	for ; shardIdx < len(s.shards) && len(userSessions) < count; shardIdx++ {
+		sh := s.shards[shardIdx]
+		sh.mu.RLock()
+		for _, userSession := range sh.sessions {
+			if userSession.ExpiresAt.After(now) {
+				userSessions = append(userSessions, userSession.Copy())
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	if shardIdx == len(s.shards) {
+		return userSessions, "", nil
+	}
+
+	return userSessions, strconv.Itoa(shardIdx), nil
+}
+
+// Ping returns ctx's error, if any. The sessions live in process memory, so the store is always reachable
+func (s *Service) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Close stops the background expiry sweeper. It is safe to call Close more than once.
+func (s *Service) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+
+	return nil
+}
+
+// sweep periodically evicts expired sessions until the store is closed
+func (s *Service) sweep(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.evictExpired(now)
+		}
+	}
+}
+
+// evictExpired removes all sessions that expired before now
+func (s *Service) evictExpired(now time.Time) {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for sessionID, userSession := range sh.sessions {
+			if !userSession.ExpiresAt.After(now) {
+				delete(sh.sessions, sessionID)
+			}
+		}
+		sh.mu.Unlock()
+	}
+
+	// note: the ranks of sessions that expired or were deleted are no longer needed
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	for sessionID := range s.ranks {
+		sh := s.shardFor(sessionID)
+		sh.mu.RLock()
+		_, ok := sh.sessions[sessionID]
+		sh.mu.RUnlock()
+
+		if !ok {
+			delete(s.ranks, sessionID)
+		}
+	}
+}
+
+// save stores a copy of a session with its incremented version. The caller must hold the shard's lock
+func (sh *shard) save(userSession *user.Session) {
+	userSession.Version = sh.version(userSession.ID) + 1
+
+	// note: like redis' EXPIREAT, saving a session with an expiry in the past removes it
+	if !userSession.ExpiresAt.After(time.Now()) {
+		delete(sh.sessions, userSession.ID)
+		return
+	}
+
+	// note: store a copy so callers can't mutate the stored session without saving it
+	sh.sessions[userSession.ID] = *userSession.Copy()
+}
+
+// version returns the version of a stored session, or 0 if it does not exist or has expired. The caller must \
+// hold the shard's lock
+func (sh *shard) version(sessionID string) int64 {
+	userSession, ok := sh.sessions[sessionID]
+	if !ok || !userSession.ExpiresAt.After(time.Now()) {
+		return 0
+	}
+
+	return userSession.Version
+}
+
+// updateField calls update with a stored session and increments its version
+func (s *Service) updateField(sessionID string, update func(userSession *user.Session)) error {
+	sh := s.shardFor(sessionID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	userSession, ok := sh.sessions[sessionID]
+	if !ok || !userSession.ExpiresAt.After(time.Now()) {
+		return store.ErrSessionNotFound
+	}
+
+	// note: copy on write, so the stored data is never changed in place, e.g. while a fetch copies it
+	updatedUserSession := userSession.Copy()
+	update(updatedUserSession)
+	updatedUserSession.Version++
+	sh.sessions[sessionID] = *updatedUserSession
+
+	return nil
+}
+
+// shardFor returns the shard responsible for a session id
+func (s *Service) shardFor(sessionID string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(sessionID))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
@@ -0,0 +1,476 @@
+// +build unit
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// note: this will fail to compile if the memory store does not implement the store interfaces
+var (
+	_ store.ServiceInterface           = (*Service)(nil)
+	_ store.ScanServiceInterface       = (*Service)(nil)
+	_ store.VersionedServiceInterface  = (*Service)(nil)
+	_ store.FieldServiceInterface      = (*Service)(nil)
+	_ store.LockServiceInterface       = (*Service)(nil)
+	_ store.HealthServiceInterface     = (*Service)(nil)
+	_ store.LimitServiceInterface      = (*Service)(nil)
+	_ store.RegenerateServiceInterface = (*Service)(nil)
+)
+
+var (
+	validUserSession = &user.Session{
+		ID:        "validSessionID",
+		UserID:    "validUserID",
+		JSON:      "validJSON",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	expiredUserSession = &user.Session{
+		ID:        "expiredSessionID",
+		UserID:    "expiredUserID",
+		JSON:      "expiredJSON",
+		ExpiresAt: time.Now().Add(-100 * time.Hour),
+	}
+)
+
+// TestNew tests the New function
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		input              Options
+		expectedShardCount int
+	}{
+		{Options{}, DefaultShardCount},
+		{Options{ShardCount: 4, SweepIntervalDuration: 1 * time.Second}, 4},
+	}
+
+	for idx, tt := range tests {
+		s := New(tt.input)
+		assert := len(s.shards) == tt.expectedShardCount
+		s.Close()
+
+		if !assert {
+			t.Errorf("test #%d failed; assert: %t, input: %v, expected shard count: %d, received shard count: %d", idx+1, assert, tt.input, tt.expectedShardCount, len(s.shards))
+		}
+	}
+}
+
+// TestSaveUserSession tests the SaveUserSession function
+func TestSaveUserSession(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	tests := []struct {
+		input         *user.Session
+		expectedErr   error
+		expectToExist bool
+	}{
+		{validUserSession, nil, true},
+		{expiredUserSession, nil, false},
+	}
+
+	for idx, tt := range tests {
+		e := s.SaveUserSession(tt.input)
+		assertErr := e == tt.expectedErr
+
+		sh := s.shardFor(tt.input.ID)
+		_, exists := sh.sessions[tt.input.ID]
+		assertExist := exists == tt.expectToExist
+
+		if !assertErr || !assertExist {
+			t.Errorf("test #%d failed; assert err: %t, assert exists: %t, received err: %v, received exists: %t, expected err: %v, expected exists: %t, input: %v\n", idx+1, assertErr, assertExist, e, exists, tt.expectedErr, tt.expectToExist, tt.input)
+		}
+	}
+}
+
+// TestFetchValidUserSession tests the FetchValidUserSession function
+func TestFetchValidUserSession(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	s.SaveUserSession(validUserSession)
+	// note: bypass SaveUserSession so that an expired session is present but not yet swept
+	s.shardFor(expiredUserSession.ID).sessions[expiredUserSession.ID] = *expiredUserSession
+
+	tests := []struct {
+		input               string
+		expectedUserSession *user.Session
+		expectedErr         error
+	}{
+		{validUserSession.ID, validUserSession, nil},
+		{expiredUserSession.ID, nil, nil},
+		{"missingSessionID", nil, nil},
+	}
+
+	for idx, tt := range tests {
+		a, e := s.FetchValidUserSession(tt.input)
+		assertErr := e == tt.expectedErr
+		var assertUserSession bool
+		if a == nil {
+			assertUserSession = tt.expectedUserSession == nil
+		} else {
+			assertUserSession = reflect.DeepEqual(*a, *tt.expectedUserSession)
+		}
+
+		if !assertErr || !assertUserSession {
+			t.Errorf("test #%d failed; assert err: %t, assert user session: %t, received err: %v, received user session: %v, expected err: %v, expected user session: %v, input: %v\n", idx+1, assertErr, assertUserSession, e, a, tt.expectedErr, tt.expectedUserSession, tt.input)
+		}
+	}
+}
+
+// TestDeleteUserSession tests the DeleteUserSession function
+func TestDeleteUserSession(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	s.SaveUserSession(validUserSession)
+
+	tests := []struct {
+		input         string
+		expectToExist bool
+	}{
+		{validUserSession.ID, false},
+		{"missingSessionID", false},
+	}
+
+	for idx, tt := range tests {
+		if e := s.DeleteUserSession(tt.input); e != nil {
+			t.Errorf("Err in test #%d when deleting user session, expected err to be nil, received err: %v, input: %s\n", idx+1, e, tt.input)
+		}
+
+		a, _ := s.FetchValidUserSession(tt.input)
+		exists := a != nil
+		if exists != tt.expectToExist {
+			t.Errorf("test #%d failed; expected exists: %t, received exists: %t, input: %s\n", idx+1, tt.expectToExist, exists, tt.input)
+		}
+	}
+}
+
+// TestListUserSessions tests the ListUserSessions and DeleteAllUserSessions functions
+func TestListUserSessions(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	otherUserSession := &user.Session{
+		ID:        "otherSessionID",
+		UserID:    "otherUserID",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	s.SaveUserSession(validUserSession)
+	s.SaveUserSession(otherUserSession)
+
+	a, e := s.ListUserSessions(validUserSession.UserID)
+	if e != nil || len(a) != 1 || !reflect.DeepEqual(*a[0], *validUserSession) {
+		t.Errorf("test failed; expected sessions: [%v], received sessions: %v, received err: %v", validUserSession, a, e)
+	}
+
+	if e := s.DeleteAllUserSessions(validUserSession.UserID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting all user sessions, received: %v", e)
+	}
+
+	if a, _ := s.ListUserSessions(validUserSession.UserID); len(a) != 0 {
+		t.Errorf("test failed; expected no sessions after deleting all user sessions, received: %v", a)
+	}
+	if a, _ := s.FetchValidUserSession(otherUserSession.ID); a == nil {
+		t.Errorf("test failed; expected other user's session to remain after deleting all user sessions")
+	}
+}
+
+// TestSweep tests that the background sweeper evicts expired sessions
+func TestSweep(t *testing.T) {
+	s := New(Options{SweepIntervalDuration: 10 * time.Millisecond})
+	defer s.Close()
+
+	soonToExpireUserSession := &user.Session{
+		ID:        "soonToExpireSessionID",
+		UserID:    "soonToExpireUserID",
+		ExpiresAt: time.Now().Add(20 * time.Millisecond),
+	}
+	s.SaveUserSession(soonToExpireUserSession)
+	s.SaveUserSession(validUserSession)
+
+	time.Sleep(100 * time.Millisecond)
+
+	sh := s.shardFor(soonToExpireUserSession.ID)
+	sh.mu.RLock()
+	_, expiredExists := sh.sessions[soonToExpireUserSession.ID]
+	sh.mu.RUnlock()
+
+	sh = s.shardFor(validUserSession.ID)
+	sh.mu.RLock()
+	_, validExists := sh.sessions[validUserSession.ID]
+	sh.mu.RUnlock()
+
+	if expiredExists || !validExists {
+		t.Errorf("test failed; expected expired session to be swept and valid session to remain, received expired exists: %t, valid exists: %t", expiredExists, validExists)
+	}
+}
+
+// TestClose tests that Close can be called more than once
+func TestClose(t *testing.T) {
+	s := New(Options{})
+
+	for idx := 0; idx < 2; idx++ {
+		if e := s.Close(); e != nil {
+			t.Errorf("test #%d failed; expected err to be nil, received err: %v", idx+1, e)
+		}
+	}
+}
+
+// TestScan tests that scanning returns every valid session
+func TestScan(t *testing.T) {
+	s := New(Options{ShardCount: 4})
+	defer s.Close()
+
+	for idx := 0; idx < 25; idx++ {
+		s.SaveUserSession(&user.Session{ID: fmt.Sprintf("sessionID%02d", idx), UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)})
+	}
+	s.SaveUserSession(expiredUserSession)
+
+	var tests = []struct {
+		inputCount       int
+		expectedSessions int
+	}{
+		{0, 25},
+		{1, 25},
+		{10, 25},
+		{100, 25},
+	}
+
+	for idx, tt := range tests {
+		scanned := make(map[string]bool)
+		var cursor string
+		var batches int
+		for {
+			userSessions, next, err := s.Scan(cursor, tt.inputCount)
+			if err != nil {
+				t.Fatalf("test #%d failed; expected err to be nil, received: %v", idx+1, err)
+			}
+			for _, userSession := range userSessions {
+				scanned[userSession.ID] = true
+			}
+
+			batches++
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		if len(scanned) != tt.expectedSessions || scanned[expiredUserSession.ID] {
+			t.Errorf("test #%d failed; input count: %d, expected sessions: %d, received: %d in %d batches", idx+1, tt.inputCount, tt.expectedSessions, len(scanned), batches)
+		}
+	}
+
+	for _, cursor := range []string{"-1", "4", "notACursor"} {
+		if _, _, e := s.Scan(cursor, 10); e != store.ErrInvalidCursor {
+			t.Errorf("test failed; cursor: %s, expected err: %v, received: %v", cursor, store.ErrInvalidCursor, e)
+		}
+	}
+}
+
+// TestSaveUserSessionIfVersion tests that a session is only saved if its stored version matches
+func TestSaveUserSessionIfVersion(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	userSession := &user.Session{ID: "versionedSessionID", UserID: "userID", JSON: "json", ExpiresAt: time.Unix(time.Now().Add(1*time.Hour).Unix(), 0)}
+
+	var tests = []struct {
+		inputVersion    int64
+		expectedErr     error
+		expectedVersion int64
+	}{
+		{1, store.ErrVersionConflict, 0},
+		{0, nil, 1},
+		{0, store.ErrVersionConflict, 1},
+		{1, nil, 2},
+	}
+
+	for idx, tt := range tests {
+		e := s.SaveUserSessionIfVersion(userSession, tt.inputVersion)
+
+		var version int64
+		if a, _ := s.FetchValidUserSession(userSession.ID); a != nil {
+			version = a.Version
+		}
+
+		if e != tt.expectedErr || version != tt.expectedVersion {
+			t.Errorf("test #%d failed; input version: %d, expected err: %v, expected version: %d, received err: %v, received version: %d", idx+1, tt.inputVersion, tt.expectedErr, tt.expectedVersion, e, version)
+		}
+	}
+
+	if e := s.SaveUserSession(userSession); e != nil || userSession.Version != 3 {
+		t.Errorf("test failed; expected saving to increment the version to 3, received: %d, err: %v", userSession.Version, e)
+	}
+}
+
+// TestUserSessionFields tests that data fields are set and deleted one by one, and that stored data can't be \
+// changed without saving it
+func TestUserSessionFields(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	userSession := &user.Session{ID: "fieldsSessionID", UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	userSession.Set("cart", "1")
+	s.SaveUserSession(userSession)
+	userSession.Set("cart", "changed")
+
+	var tests = []struct {
+		input           func() error
+		expectedErr     error
+		expectedData    map[string]string
+		expectedVersion int64
+	}{
+		{func() error { return nil }, nil, map[string]string{"cart": "1"}, 1},
+		{func() error { return s.SetUserSessionField(userSession.ID, "theme", "dark") }, nil, map[string]string{"cart": "1", "theme": "dark"}, 2},
+		{func() error { return s.DeleteUserSessionField(userSession.ID, "cart") }, nil, map[string]string{"theme": "dark"}, 3},
+		{func() error { return s.SetUserSessionField("missingSessionID", "cart", "1") }, store.ErrSessionNotFound, map[string]string{"theme": "dark"}, 3},
+		{func() error { return s.DeleteUserSessionField("missingSessionID", "cart") }, store.ErrSessionNotFound, map[string]string{"theme": "dark"}, 3},
+	}
+
+	for idx, tt := range tests {
+		e := tt.input()
+		a, _ := s.FetchValidUserSession(userSession.ID)
+
+		if e != tt.expectedErr || a == nil || !reflect.DeepEqual(a.Data, tt.expectedData) || a.Version != tt.expectedVersion {
+			t.Errorf("test #%d failed; expected err: %v, expected data: %v, expected version: %d, received err: %v, received session: %v", idx+1, tt.expectedErr, tt.expectedData, tt.expectedVersion, e, a)
+		}
+
+		if a != nil {
+			a.Set("theme", "changed")
+		}
+	}
+}
+
+// TestConcurrentUserSessionFields tests that fields can be set while the session is fetched concurrently. Run it \
+// with -race.
+func TestConcurrentUserSessionFields(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	userSession := &user.Session{ID: "concurrentSessionID", UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	userSession.Set("cart", "0")
+	s.SaveUserSession(userSession)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.SetUserSessionField(userSession.ID, fmt.Sprintf("field%d", i), fmt.Sprint(j))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if a, _ := s.FetchValidUserSession(userSession.ID); a != nil {
+					a.Set("cart", "changed")
+				}
+				s.ListUserSessions(userSession.UserID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	a, e := s.FetchValidUserSession(userSession.ID)
+	if e != nil || a == nil || len(a.Data) != 5 || a.Data["cart"] != "0" || a.Version != 4001 {
+		t.Errorf("test failed; expected 5 fields and version 4001, received session: %v, received err: %v", a, e)
+	}
+}
+
+// TestSaveUserSessionWithLimit tests that a user's sessions are capped, and that the least recently issued or \
+// touched sessions are evicted first
+func TestSaveUserSessionWithLimit(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	ctx := context.Background()
+	newUserSession := func(id string) *user.Session {
+		return &user.Session{ID: id, UserID: "limitedUserID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	}
+	a, b, c := newUserSession("a"), newUserSession("b"), newUserSession("c")
+
+	var tests = []struct {
+		input           func() ([]string, error)
+		expectedEvicted []string
+		expectedErr     error
+		expectedIDs     []string
+	}{
+		{func() ([]string, error) { return s.SaveUserSessionWithLimit(ctx, a, 2, false) }, nil, nil, []string{"a"}},
+		{func() ([]string, error) { return s.SaveUserSessionWithLimit(ctx, b, 2, false) }, nil, nil, []string{"a", "b"}},
+		{func() ([]string, error) { return s.SaveUserSessionWithLimit(ctx, c, 2, false) }, nil, store.ErrSessionLimitReached, []string{"a", "b"}},
+		{func() ([]string, error) {
+			// note: a session that is saved again doesn't count against its own limit
+			return s.SaveUserSessionWithLimit(ctx, a, 2, false)
+		}, nil, nil, []string{"a", "b"}},
+		{func() ([]string, error) { return s.SaveUserSessionWithLimit(ctx, c, 2, true) }, []string{"b"}, nil, []string{"a", "c"}},
+		{func() ([]string, error) { return nil, s.TouchUserSession(ctx, a) }, nil, nil, []string{"a", "c"}},
+		{func() ([]string, error) { return s.SaveUserSessionWithLimit(ctx, b, 1, true) }, []string{"c", "a"}, nil, []string{"b"}},
+	}
+
+	for idx, tt := range tests {
+		evicted, e := tt.input()
+
+		userSessions, _ := s.ListUserSessions("limitedUserID")
+		var ids []string
+		for _, userSession := range userSessions {
+			ids = append(ids, userSession.ID)
+		}
+		sort.Strings(ids)
+
+		if e != tt.expectedErr || !reflect.DeepEqual(evicted, tt.expectedEvicted) || !reflect.DeepEqual(ids, tt.expectedIDs) {
+			t.Errorf("test #%d failed; expected err: %v, expected evicted: %v, expected ids: %v, received err: %v, received evicted: %v, received ids: %v", idx+1, tt.expectedErr, tt.expectedEvicted, tt.expectedIDs, e, evicted, ids)
+		}
+	}
+}
+
+// TestRegenerateUserSession tests that a session is moved to its new id, and that the new id takes over the old \
+// id's rank
+func TestRegenerateUserSession(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	ctx := context.Background()
+	newUserSession := func(id string) *user.Session {
+		return &user.Session{ID: id, UserID: "regeneratedUserID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	}
+	s.SaveUserSessionWithLimit(ctx, newUserSession("a"), 2, true)
+	s.SaveUserSessionWithLimit(ctx, newUserSession("b"), 2, true)
+
+	var tests = []struct {
+		input           func() ([]string, error)
+		expectedEvicted []string
+		expectedIDs     []string
+	}{
+		{func() ([]string, error) { return nil, s.RegenerateUserSession(ctx, "a", newUserSession("c")) }, nil, []string{"b", "c"}},
+		{func() ([]string, error) {
+			// note: c took over a's rank, so it is evicted before b
+			return s.SaveUserSessionWithLimit(ctx, newUserSession("d"), 2, true)
+		}, []string{"c"}, []string{"b", "d"}},
+	}
+
+	for idx, tt := range tests {
+		evicted, e := tt.input()
+
+		userSessions, _ := s.ListUserSessions("regeneratedUserID")
+		var ids []string
+		for _, userSession := range userSessions {
+			ids = append(ids, userSession.ID)
+		}
+		sort.Strings(ids)
+
+		if e != nil || !reflect.DeepEqual(evicted, tt.expectedEvicted) || !reflect.DeepEqual(ids, tt.expectedIDs) {
+			t.Errorf("test #%d failed; expected evicted: %v, expected ids: %v, received err: %v, received evicted: %v, received ids: %v", idx+1, tt.expectedEvicted, tt.expectedIDs, e, evicted, ids)
+		}
+	}
+}
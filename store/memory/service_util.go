@@ -0,0 +1,14 @@
+package memory
+
+// setDefaultOptions sets default values for nil fields
+func setDefaultOptions(options *Options) {
+	emptyOptions := Options{}
+	if options.ShardCount <= emptyOptions.ShardCount {
+		options.ShardCount = DefaultShardCount
+	}
+	if options.SweepIntervalDuration <= emptyOptions.SweepIntervalDuration {
+		options.SweepIntervalDuration = DefaultSweepIntervalDuration
+	}
+
+	return
+}
@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"context"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+)
+
+// Copy copies every valid session of from to to, with its expiry preserved, and returns the number of copied \
+// sessions. count is the batch size hint passed to from.Scan. Copy stops when ctx is done. from must implement \
+// store.ScanServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Sessions saved to from while Copy runs may not be copied, so, to move sessions without downtime, serve requests \
+// with a migrating store (see New) before running Copy. Copy then doesn't undo the writes of the migrating store: \
+// sessions that were deleted after they were scanned are deleted from to again, and, if to versions sessions, \
+// sessions that were saved to it already aren't overwritten with the older copies that were scanned.
+func Copy(ctx context.Context, from store.ServiceInterface, to store.ServiceInterface, count int) (int, error) {
+	scanStore, ok := from.(store.ScanServiceInterface)
+	if !ok {
+		return 0, store.ErrNotSupported
+	}
+
+	var copied int
+	var cursor string
+	for {
+		userSessions, next, err := scanStore.Scan(cursor, count)
+		if err != nil {
+			return copied, err
+		}
+
+		for _, userSession := range userSessions {
+			if err := ctx.Err(); err != nil {
+				return copied, err
+			}
+			// note: the session may have expired since it was scanned
+			if !userSession.ExpiresAt.After(time.Now()) {
+				continue
+			}
+
+			ok, err := backfillUserSession(ctx, from, to, userSession)
+			if err != nil {
+				return copied, err
+			}
+			if ok {
+				copied++
+			}
+		}
+
+		if next == "" {
+			return copied, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,105 @@
+// +build unit
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/store/memory"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// TestCopy tests that every valid session is copied, with its expiry
+func TestCopy(t *testing.T) {
+	from := memory.New(memory.Options{ShardCount: 4})
+	defer from.Close()
+
+	for idx := 0; idx < 25; idx++ {
+		from.SaveUserSession(newUserSession(fmt.Sprintf("sessionID%02d", idx)))
+	}
+
+	var tests = []struct {
+		inputCount     int
+		expectedCopied int
+	}{
+		{1, 25},
+		{10, 25},
+		{100, 25},
+	}
+
+	for idx, tt := range tests {
+		to := memory.New(memory.Options{})
+
+		copied, e := Copy(context.Background(), from, to, tt.inputCount)
+		userSessions, _ := to.ListUserSessions("userID")
+
+		if e != nil || copied != tt.expectedCopied || len(userSessions) != tt.expectedCopied {
+			t.Errorf("test #%d failed; expected copied: %d, received: %d, stored: %d, received err: %v", idx+1, tt.expectedCopied, copied, len(userSessions), e)
+		}
+		for _, userSession := range userSessions {
+			fromUserSession, _ := from.FetchValidUserSession(userSession.ID)
+			if !userSession.ExpiresAt.Equal(fromUserSession.ExpiresAt) {
+				t.Errorf("test #%d failed; expected expiry %v to be preserved, received: %v", idx+1, fromUserSession.ExpiresAt, userSession.ExpiresAt)
+			}
+		}
+
+		to.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	to := memory.New(memory.Options{})
+	defer to.Close()
+	if copied, e := Copy(ctx, from, to, 10); e != context.Canceled || copied != 0 {
+		t.Errorf("test failed; expected err: %v and no copies, received err: %v, copied: %d", context.Canceled, e, copied)
+	}
+
+	if copied, e := Copy(context.Background(), struct{ store.ServiceInterface }{to}, to, 10); e != store.ErrNotSupported || copied != 0 {
+		t.Errorf("test failed; expected err: %v and no copies, received err: %v, copied: %d", store.ErrNotSupported, e, copied)
+	}
+}
+
+// TestInterleavedCopy tests that a copy doesn't undo a delete or overwrite a save of the migrating store that \
+// happened after the session was scanned
+func TestInterleavedCopy(t *testing.T) {
+	var tests = []struct {
+		interleave     func(s *Service, userSession *user.Session)
+		expectedJSON   string
+		expectedCopied int
+	}{
+		{func(s *Service, userSession *user.Session) {}, "json", 1},
+		{func(s *Service, userSession *user.Session) { s.DeleteUserSession(userSession.ID) }, "", 0},
+		{func(s *Service, userSession *user.Session) {
+			savedUserSession := *userSession
+			savedUserSession.JSON = "savedJSON"
+			s.SaveUserSession(&savedUserSession)
+		}, "savedJSON", 0},
+	}
+
+	for idx, tt := range tests {
+		from := &interleavedStore{Service: memory.New(memory.Options{})}
+		to := memory.New(memory.Options{})
+		s := New(from, to, Options{})
+
+		userSession := newUserSession("interleavedSessionID")
+		from.SaveUserSession(userSession)
+		from.interleave = func() { tt.interleave(s, userSession) }
+
+		copied, e := Copy(context.Background(), from, to, 10)
+		stored, _ := to.FetchValidUserSession(userSession.ID)
+		var json string
+		if stored != nil {
+			json = stored.JSON
+		}
+
+		if e != nil || copied != tt.expectedCopied || json != tt.expectedJSON {
+			t.Errorf("test #%d failed; expected copied: %d, expected json: %s, received copied: %d, received stored session: %v, received err: %v", idx+1, tt.expectedCopied, tt.expectedJSON, copied, stored, e)
+		}
+
+		from.Close()
+		to.Close()
+	}
+}
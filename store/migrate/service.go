@@ -0,0 +1,313 @@
+package migrate
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+const (
+	// newCursorPrefix and oldCursorPrefix tag the cursors returned by Scan with the store being scanned
+	newCursorPrefix = "new:"
+	oldCursorPrefix = "old:"
+)
+
+// Service is a session store that moves sessions from an old store to a new one while both are in use. Writes go \
+// to both stores; reads go to the new store and fall back to the old one, copying the sessions found there to the \
+// new store. Once every session of the old store has expired, or has been copied with Copy, the old store can be \
+// dropped.
+type Service struct {
+	oldStore store.ServiceInterface
+	newStore store.ServiceInterface
+	options  Options
+}
+
+// Options defines the behavior of the migrating store
+type Options struct {
+	// BackfillErrorHandler, if set, is called with errors returned when copying a session found in the old store \
+	// to the new store. The session is returned anyways
+	BackfillErrorHandler func(error)
+}
+
+// New returns a new migrating store that moves sessions from oldStore to newStore
+func New(oldStore store.ServiceInterface, newStore store.ServiceInterface, options Options) *Service {
+	return &Service{
+		oldStore: oldStore,
+		newStore: newStore,
+		options:  options,
+	}
+}
+
+// SaveUserSession saves a user session in the new store, then in the old one
+func (s *Service) SaveUserSession(userSession *user.Session) error {
+	return s.SaveUserSessionContext(context.Background(), userSession)
+}
+
+// SaveUserSessionContext is like SaveUserSession, but passes ctx to the stores that support it
+func (s *Service) SaveUserSessionContext(ctx context.Context, userSession *user.Session) error {
+	if err := saveUserSession(ctx, s.newStore, userSession); err != nil {
+		return err
+	}
+
+	// note: keep the old store up to date, so we can roll back to it
+	return saveUserSession(ctx, s.oldStore, userSession)
+}
+
+// SaveUserSessionWithLimit saves a new user session in the new store if its user holds fewer than limit other \
+// valid sessions there, or, if evict is set, after the new store deleted the user's lowest ranked sessions. The \
+// session is then saved in, and the evicted sessions are deleted from, the old store. The new store must implement \
+// store.LimitServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Note that sessions that haven't been copied to the new store yet are not counted.
+func (s *Service) SaveUserSessionWithLimit(ctx context.Context, userSession *user.Session, limit int, evict bool) ([]string, error) {
+	limitStore, ok := s.newStore.(store.LimitServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	evictedSessionIDs, err := limitStore.SaveUserSessionWithLimit(ctx, userSession, limit, evict)
+	if err != nil {
+		return evictedSessionIDs, err
+	}
+
+	// note: evicted sessions must not be found, and copied back, through the old store
+	for _, sessionID := range evictedSessionIDs {
+		if err := deleteUserSession(ctx, s.oldStore, sessionID); err != nil {
+			return evictedSessionIDs, err
+		}
+	}
+
+	return evictedSessionIDs, saveUserSession(ctx, s.oldStore, userSession)
+}
+
+// TouchUserSession ranks a session as its user's most recently used session in the new store. The new store must \
+// implement store.LimitServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) TouchUserSession(ctx context.Context, userSession *user.Session) error {
+	limitStore, ok := s.newStore.(store.LimitServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return limitStore.TouchUserSession(ctx, userSession)
+}
+
+// RegenerateUserSession moves a session to a new id in the new store, then deletes the old id from, and saves the \
+// new session in, the old store. The new store must implement store.RegenerateServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+func (s *Service) RegenerateUserSession(ctx context.Context, sessionID string, userSession *user.Session) error {
+	regenerateStore, ok := s.newStore.(store.RegenerateServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	if err := regenerateStore.RegenerateUserSession(ctx, sessionID, userSession); err != nil {
+		return err
+	}
+
+	// note: the old id must not be found, and copied back, through the old store
+	if err := deleteUserSession(ctx, s.oldStore, sessionID); err != nil {
+		return err
+	}
+
+	return saveUserSession(ctx, s.oldStore, userSession)
+}
+
+// LockUserSession acquires the lock of a session in the new store. Locks are short-lived, so they aren't migrated: \
+// every instance must use the migrating store while locks are in use. The new store must implement \
+// store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) LockUserSession(ctx context.Context, sessionID string, ttl time.Duration) (int64, error) {
+	lockStore, ok := s.newStore.(store.LockServiceInterface)
+	if !ok {
+		return 0, store.ErrNotSupported
+	}
+
+	return lockStore.LockUserSession(ctx, sessionID, ttl)
+}
+
+// UnlockUserSession releases the lock of a session in the new store. The new store must implement \
+// store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) UnlockUserSession(ctx context.Context, sessionID string, token int64) error {
+	lockStore, ok := s.newStore.(store.LockServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return lockStore.UnlockUserSession(ctx, sessionID, token)
+}
+
+// Ping checks whether both stores can be reached, since reads fall back to the old store. Both stores must \
+// implement store.HealthServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) Ping(ctx context.Context) error {
+	for _, pinged := range []store.ServiceInterface{s.newStore, s.oldStore} {
+		healthStore, ok := pinged.(store.HealthServiceInterface)
+		if !ok {
+			return store.ErrNotSupported
+		}
+		if err := healthStore.Ping(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteUserSession deletes a user session from both stores
+func (s *Service) DeleteUserSession(sessionID string) error {
+	return s.DeleteUserSessionContext(context.Background(), sessionID)
+}
+
+// DeleteUserSessionContext is like DeleteUserSession, but passes ctx to the stores that support it
+func (s *Service) DeleteUserSessionContext(ctx context.Context, sessionID string) error {
+	// note: delete from the old store first, so a failure can't leave a session that would be backfilled
+	if err := deleteUserSession(ctx, s.oldStore, sessionID); err != nil {
+		return err
+	}
+
+	return deleteUserSession(ctx, s.newStore, sessionID)
+}
+
+// FetchValidUserSession fetches a user session from the new store, or, if it isn't there, from the old store, \
+// in which case it is copied to the new store. If a valid session does not exist, this function returns a nil \
+// pointer
+func (s *Service) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	return s.FetchValidUserSessionContext(context.Background(), sessionID)
+}
+
+// FetchValidUserSessionContext is like FetchValidUserSession, but passes ctx to the stores that support it
+func (s *Service) FetchValidUserSessionContext(ctx context.Context, sessionID string) (*user.Session, error) {
+	userSession, err := fetchValidUserSession(ctx, s.newStore, sessionID)
+	if err != nil || userSession != nil {
+		return userSession, err
+	}
+
+	userSession, err = fetchValidUserSession(ctx, s.oldStore, sessionID)
+	if err != nil || userSession == nil {
+		return nil, err
+	}
+
+	copied, err := backfillUserSession(ctx, s.oldStore, s.newStore, userSession)
+	if err != nil {
+		if s.options.BackfillErrorHandler != nil {
+			s.options.BackfillErrorHandler(err)
+		}
+		return userSession, nil
+	}
+	if !copied {
+		// note: the new store has a more recent copy of the session, or it was deleted in the meantime
+		return fetchValidUserSession(ctx, s.newStore, sessionID)
+	}
+
+	return userSession, nil
+}
+
+// ListUserSessions returns all valid sessions of a user from both stores. Both stores must implement \
+// store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) ListUserSessions(userID string) ([]*user.Session, error) {
+	newUserStore, newOK := s.newStore.(store.UserServiceInterface)
+	oldUserStore, oldOK := s.oldStore.(store.UserServiceInterface)
+	if !newOK || !oldOK {
+		return nil, store.ErrNotSupported
+	}
+
+	userSessions, err := newUserStore.ListUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	oldUserSessions, err := oldUserStore.ListUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// note: sessions that were saved since the migration started are in both stores
+	listed := make(map[string]bool, len(userSessions))
+	for _, userSession := range userSessions {
+		listed[userSession.ID] = true
+	}
+	for _, userSession := range oldUserSessions {
+		if !listed[userSession.ID] {
+			userSessions = append(userSessions, userSession)
+		}
+	}
+
+	return userSessions, nil
+}
+
+// DeleteAllUserSessions deletes all sessions of a user from both stores. Both stores must implement \
+// store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) DeleteAllUserSessions(userID string) error {
+	newUserStore, newOK := s.newStore.(store.UserServiceInterface)
+	oldUserStore, oldOK := s.oldStore.(store.UserServiceInterface)
+	if !newOK || !oldOK {
+		return store.ErrNotSupported
+	}
+
+	if err := oldUserStore.DeleteAllUserSessions(userID); err != nil {
+		return err
+	}
+
+	return newUserStore.DeleteAllUserSessions(userID)
+}
+
+// Scan returns a batch of valid sessions from the new store, then from the old one, skipping the sessions of the \
+// old store that were copied to the new store. Both stores must implement store.ScanServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+func (s *Service) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	newScanStore, newOK := s.newStore.(store.ScanServiceInterface)
+	oldScanStore, oldOK := s.oldStore.(store.ScanServiceInterface)
+	if !newOK || !oldOK {
+		return nil, "", store.ErrNotSupported
+	}
+
+	switch {
+	case cursor == "":
+		return s.scanNew(newScanStore, "", count)
+	case strings.HasPrefix(cursor, newCursorPrefix):
+		return s.scanNew(newScanStore, strings.TrimPrefix(cursor, newCursorPrefix), count)
+	case strings.HasPrefix(cursor, oldCursorPrefix):
+		return s.scanOld(oldScanStore, strings.TrimPrefix(cursor, oldCursorPrefix), count)
+	default:
+		return nil, "", store.ErrInvalidCursor
+	}
+}
+
+// scanNew returns a batch of sessions from the new store. Once the new store is exhausted, the returned cursor \
+// starts scanning the old store.
+func (s *Service) scanNew(newScanStore store.ScanServiceInterface, cursor string, count int) ([]*user.Session, string, error) {
+	userSessions, next, err := newScanStore.Scan(cursor, count)
+	if err != nil {
+		return nil, "", err
+	}
+	if next == "" {
+		return userSessions, oldCursorPrefix, nil
+	}
+
+	return userSessions, newCursorPrefix + next, nil
+}
+
+// scanOld returns a batch of sessions from the old store that are not in the new store
+func (s *Service) scanOld(oldScanStore store.ScanServiceInterface, cursor string, count int) ([]*user.Session, string, error) {
+	oldUserSessions, next, err := oldScanStore.Scan(cursor, count)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// note: sessions in both stores were already returned while scanning the new store, possibly with newer data
+	userSessions := make([]*user.Session, 0, len(oldUserSessions))
+	for _, userSession := range oldUserSessions {
+		newUserSession, err := s.newStore.FetchValidUserSession(userSession.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		if newUserSession == nil {
+			userSessions = append(userSessions, userSession)
+		}
+	}
+	if next == "" {
+		return userSessions, "", nil
+	}
+
+	return userSessions, oldCursorPrefix + next, nil
+}
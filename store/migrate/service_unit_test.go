@@ -0,0 +1,266 @@
+// +build unit
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/store/memory"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// note: this will fail to compile if the migrating store does not implement the store interfaces
+var (
+	_ store.ServiceInterface           = (*Service)(nil)
+	_ store.ContextServiceInterface    = (*Service)(nil)
+	_ store.UserServiceInterface       = (*Service)(nil)
+	_ store.ScanServiceInterface       = (*Service)(nil)
+	_ store.LockServiceInterface       = (*Service)(nil)
+	_ store.HealthServiceInterface     = (*Service)(nil)
+	_ store.LimitServiceInterface      = (*Service)(nil)
+	_ store.RegenerateServiceInterface = (*Service)(nil)
+)
+
+var errTest = errors.New("test err")
+
+// erredStore fails every save
+type erredStore struct {
+	*memory.Service
+}
+
+func (e *erredStore) SaveUserSession(userSession *user.Session) error {
+	return errTest
+}
+
+func (e *erredStore) SaveUserSessionIfVersion(userSession *user.Session, version int64) error {
+	return errTest
+}
+
+// interleavedStore runs interleave once, after it read sessions, before it returns them, like a write that races \
+// with a backfill or a copy
+type interleavedStore struct {
+	*memory.Service
+
+	interleave func()
+}
+
+func (i *interleavedStore) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	userSession, err := i.Service.FetchValidUserSession(sessionID)
+	i.runInterleave()
+	return userSession, err
+}
+
+func (i *interleavedStore) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	userSessions, next, err := i.Service.Scan(cursor, count)
+	i.runInterleave()
+	return userSessions, next, err
+}
+
+func (i *interleavedStore) runInterleave() {
+	if interleave := i.interleave; interleave != nil {
+		i.interleave = nil
+		interleave()
+	}
+}
+
+func newUserSession(id string) *user.Session {
+	return &user.Session{
+		ID:        id,
+		UserID:    "userID",
+		JSON:      "json",
+		ExpiresAt: time.Unix(time.Now().Add(1*time.Hour).Unix(), 0),
+	}
+}
+
+// TestDualWrite tests that saves and deletes go to both stores
+func TestDualWrite(t *testing.T) {
+	oldStore := memory.New(memory.Options{})
+	defer oldStore.Close()
+	newStore := memory.New(memory.Options{})
+	defer newStore.Close()
+	s := New(oldStore, newStore, Options{})
+
+	userSession := newUserSession("sessionID")
+	if e := s.SaveUserSession(userSession); e != nil {
+		t.Errorf("test failed; expected err to be nil when saving, received: %v", e)
+	}
+	for idx, st := range []*memory.Service{oldStore, newStore} {
+		if a, _ := st.FetchValidUserSession(userSession.ID); a == nil {
+			t.Errorf("test #%d failed; expected session to be saved in both stores", idx+1)
+		}
+	}
+
+	if e := s.DeleteUserSession(userSession.ID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting, received: %v", e)
+	}
+	for idx, st := range []*memory.Service{oldStore, newStore} {
+		if a, _ := st.FetchValidUserSession(userSession.ID); a != nil {
+			t.Errorf("test #%d failed; expected session to be deleted from both stores, received: %v", idx+1, a)
+		}
+	}
+}
+
+// TestBackfill tests that sessions only found in the old store are copied to the new store
+func TestBackfill(t *testing.T) {
+	oldStore := memory.New(memory.Options{})
+	defer oldStore.Close()
+	newStore := memory.New(memory.Options{})
+	defer newStore.Close()
+
+	var backfillErrs []error
+	s := New(oldStore, newStore, Options{BackfillErrorHandler: func(err error) { backfillErrs = append(backfillErrs, err) }})
+
+	oldUserSession := newUserSession("oldSessionID")
+	oldStore.SaveUserSession(oldUserSession)
+
+	var tests = []struct {
+		input          string
+		expectedExists bool
+	}{
+		{oldUserSession.ID, true},
+		{"missingSessionID", false},
+	}
+
+	for idx, tt := range tests {
+		a, e := s.FetchValidUserSession(tt.input)
+		exists := a != nil
+
+		if e != nil || exists != tt.expectedExists {
+			t.Errorf("test #%d failed; input: %s, expected exists: %t, received: %v, received err: %v", idx+1, tt.input, tt.expectedExists, a, e)
+		}
+	}
+
+	a, _ := newStore.FetchValidUserSession(oldUserSession.ID)
+	if a == nil || !a.ExpiresAt.Equal(oldUserSession.ExpiresAt) {
+		t.Errorf("test failed; expected session to be backfilled with its expiry, received: %v", a)
+	}
+
+	failingStore := New(oldStore, &erredStore{newStore}, Options{BackfillErrorHandler: func(err error) { backfillErrs = append(backfillErrs, err) }})
+	newStore.DeleteUserSession(oldUserSession.ID)
+	if a, e := failingStore.FetchValidUserSession(oldUserSession.ID); a == nil || e != nil || len(backfillErrs) != 1 {
+		t.Errorf("test failed; expected session despite a failed backfill, received: %v, err: %v, backfill errs: %v", a, e, backfillErrs)
+	}
+}
+
+// TestInterleavedBackfill tests that a backfill doesn't undo a concurrent delete or overwrite a concurrent save
+func TestInterleavedBackfill(t *testing.T) {
+	oldStore := &interleavedStore{Service: memory.New(memory.Options{})}
+	defer oldStore.Close()
+	newStore := memory.New(memory.Options{})
+	defer newStore.Close()
+	s := New(oldStore, newStore, Options{})
+
+	var tests = []struct {
+		interleave   func(userSession *user.Session)
+		expectedJSON string
+	}{
+		{func(userSession *user.Session) { s.DeleteUserSession(userSession.ID) }, ""},
+		{func(userSession *user.Session) {
+			savedUserSession := *userSession
+			savedUserSession.JSON = "savedJSON"
+			s.SaveUserSession(&savedUserSession)
+		}, "savedJSON"},
+	}
+
+	for idx, tt := range tests {
+		userSession := newUserSession(fmt.Sprintf("interleavedSessionID%d", idx))
+		oldStore.SaveUserSession(userSession)
+		oldStore.interleave = func() { tt.interleave(userSession) }
+
+		a, e := s.FetchValidUserSession(userSession.ID)
+		stored, _ := newStore.FetchValidUserSession(userSession.ID)
+
+		for _, received := range []*user.Session{a, stored} {
+			var json string
+			if received != nil {
+				json = received.JSON
+			}
+			if e != nil || json != tt.expectedJSON {
+				t.Errorf("test #%d failed; expected json: %s, received session: %v, received stored session: %v, received err: %v", idx+1, tt.expectedJSON, a, stored, e)
+			}
+		}
+	}
+}
+
+// TestListUserSessions tests that sessions of both stores are listed once
+func TestListUserSessions(t *testing.T) {
+	oldStore := memory.New(memory.Options{})
+	defer oldStore.Close()
+	newStore := memory.New(memory.Options{})
+	defer newStore.Close()
+	s := New(oldStore, newStore, Options{})
+
+	oldStore.SaveUserSession(newUserSession("oldSessionID"))
+	s.SaveUserSession(newUserSession("sessionID"))
+
+	userSessions, e := s.ListUserSessions("userID")
+	if e != nil || len(userSessions) != 2 {
+		t.Errorf("test failed; expected 2 sessions, received: %v, received err: %v", userSessions, e)
+	}
+
+	if e := s.DeleteAllUserSessions("userID"); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting all user sessions, received: %v", e)
+	}
+	if userSessions, _ := s.ListUserSessions("userID"); len(userSessions) != 0 {
+		t.Errorf("test failed; expected no sessions, received: %v", userSessions)
+	}
+}
+
+// TestScan tests that sessions of both stores are scanned once
+func TestScan(t *testing.T) {
+	oldStore := memory.New(memory.Options{})
+	defer oldStore.Close()
+	newStore := memory.New(memory.Options{})
+	defer newStore.Close()
+	s := New(oldStore, newStore, Options{})
+
+	oldStore.SaveUserSession(newUserSession("oldSessionID"))
+	s.SaveUserSession(newUserSession("sessionID"))
+
+	scanned := map[string]int{}
+	it := store.NewIterator(s, 1)
+	for it.Next() {
+		scanned[it.Session().ID]++
+	}
+	if e := it.Err(); e != nil || len(scanned) != 2 || scanned["sessionID"] != 1 || scanned["oldSessionID"] != 1 {
+		t.Errorf("test failed; expected each session to be scanned once, received: %v, received err: %v", scanned, e)
+	}
+
+	if _, _, e := s.Scan("invalid", 1); e != store.ErrInvalidCursor {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrInvalidCursor, e)
+	}
+}
+
+// TestPing tests that both stores are pinged
+func TestPing(t *testing.T) {
+	oldStore := memory.New(memory.Options{})
+	defer oldStore.Close()
+	newStore := memory.New(memory.Options{})
+	defer newStore.Close()
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var tests = []struct {
+		input       *Service
+		ctx         context.Context
+		expectedErr error
+	}{
+		{New(oldStore, newStore, Options{}), context.Background(), nil},
+		{New(oldStore, newStore, Options{}), cancelledCtx, context.Canceled},
+		{New(struct{ store.ServiceInterface }{oldStore}, newStore, Options{}), context.Background(), store.ErrNotSupported},
+	}
+
+	for idx, tt := range tests {
+		e := tt.input.Ping(tt.ctx)
+
+		if e != tt.expectedErr {
+			t.Errorf("test #%d failed; expected err: %v, received: %v", idx+1, tt.expectedErr, e)
+		}
+	}
+}
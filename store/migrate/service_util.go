@@ -0,0 +1,63 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// saveUserSession saves a session in s, passing ctx along if s supports it
+func saveUserSession(ctx context.Context, s store.ServiceInterface, userSession *user.Session) error {
+	if contextStore, ok := s.(store.ContextServiceInterface); ok {
+		return contextStore.SaveUserSessionContext(ctx, userSession)
+	}
+	return s.SaveUserSession(userSession)
+}
+
+// deleteUserSession deletes a session from s, passing ctx along if s supports it
+func deleteUserSession(ctx context.Context, s store.ServiceInterface, sessionID string) error {
+	if contextStore, ok := s.(store.ContextServiceInterface); ok {
+		return contextStore.DeleteUserSessionContext(ctx, sessionID)
+	}
+	return s.DeleteUserSession(sessionID)
+}
+
+// fetchValidUserSession fetches a session from s, passing ctx along if s supports it
+func fetchValidUserSession(ctx context.Context, s store.ServiceInterface, sessionID string) (*user.Session, error) {
+	if contextStore, ok := s.(store.ContextServiceInterface); ok {
+		return contextStore.FetchValidUserSessionContext(ctx, sessionID)
+	}
+	return s.FetchValidUserSession(sessionID)
+}
+
+// backfillUserSession copies a session that was read from oldStore to newStore, and returns whether it was copied. \
+// A concurrent write must not be undone: if newStore versions sessions, the session is only copied if newStore has \
+// no copy of it yet, since a copy there was written by the migrating store, which writes to the new store first, so \
+// it is at least as recent. And if the session was deleted from oldStore in the meantime, its copy is deleted again.
+func backfillUserSession(ctx context.Context, oldStore store.ServiceInterface, newStore store.ServiceInterface, userSession *user.Session) (bool, error) {
+	err := store.ErrNotSupported
+	if versionedStore, ok := newStore.(store.VersionedServiceInterface); ok {
+		// note: stored sessions have a version of at least 1, so this only saves the session if it is absent
+		err = versionedStore.SaveUserSessionIfVersion(userSession, 0)
+		if err == store.ErrVersionConflict {
+			return false, nil
+		}
+	}
+	// note: decorating stores implement store.VersionedServiceInterface even if the store they wrap doesn't
+	if err == store.ErrNotSupported {
+		err = saveUserSession(ctx, newStore, userSession)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// note: sessions are deleted from the old store first, so if the session is still there, a concurrent delete \
+	// has yet to delete it from the new store, too
+	stored, err := fetchValidUserSession(ctx, oldStore, userSession.ID)
+	if err != nil || stored != nil {
+		return err == nil, err
+	}
+
+	return false, deleteUserSession(ctx, newStore, userSession.ID)
+}
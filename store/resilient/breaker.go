@@ -0,0 +1,68 @@
+package resilient
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker. It opens after threshold consecutive failures, and lets a single trial operation \
+// through once it has been open for openDuration
+type breaker struct {
+	threshold    int
+	openDuration time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// newBreaker returns a closed breaker
+func newBreaker(threshold int, openDuration time.Duration) *breaker {
+	return &breaker{
+		threshold:    threshold,
+		openDuration: openDuration,
+	}
+}
+
+// allow reports whether an operation may call the store
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	// note: while open, or while the trial operation is in flight, fail fast
+	if b.trial || time.Since(b.openedAt) < b.openDuration {
+		return false
+	}
+
+	b.trial = true
+	return true
+}
+
+// record records the outcome of an allowed operation
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends an allowed operation without recording its outcome
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
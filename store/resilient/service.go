@@ -0,0 +1,365 @@
+package resilient
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+const (
+	// DefaultMaxRetries sets the default number of times a failed operation is retried
+	DefaultMaxRetries = 2
+	// DefaultInitialBackoffDuration sets the default duration to wait before the first retry
+	DefaultInitialBackoffDuration = 50 * time.Millisecond
+	// DefaultMaxBackoffDuration sets the default maximum duration to wait between retries
+	DefaultMaxBackoffDuration = 1 * time.Second
+	// DefaultFailureThreshold sets the default number of consecutive failed operations that open the circuit
+	DefaultFailureThreshold = 5
+	// DefaultOpenDuration sets the default duration the circuit stays open before an operation is let through
+	DefaultOpenDuration = 10 * time.Second
+)
+
+// ErrCircuitOpen is thrown, without calling the store, while the circuit is open
+var ErrCircuitOpen = errors.New("store unavailable: circuit open")
+
+// Service is a session store that retries transient failures of another store with exponential backoff, and \
+// stops calling the store for a while once it has failed repeatedly
+type Service struct {
+	store   store.ServiceInterface
+	options Options
+	breaker *breaker
+}
+
+// Options defines the behavior of the resilient store
+type Options struct {
+	// MaxRetries is the number of times an operation that failed with a transient error is retried. Set it to a \
+	// negative number to disable retries
+	MaxRetries int
+	// InitialBackoffDuration is the duration to wait before the first retry. It doubles with every retry
+	InitialBackoffDuration time.Duration
+	// MaxBackoffDuration caps the duration to wait between retries
+	MaxBackoffDuration time.Duration
+	// FailureThreshold is the number of consecutive operations that fail with a transient error, after retries, \
+	// that open the circuit
+	FailureThreshold int
+	// OpenDuration is how long the circuit stays open. Afterwards, a single operation is let through: if it \
+	// succeeds the circuit closes, otherwise it opens again
+	OpenDuration time.Duration
+	// IsTransient reports whether an error is transient, i.e. worth retrying and a sign the store is unavailable. \
+	// Defaults to IsTransient
+	IsTransient func(err error) bool
+}
+
+// New returns a new resilient store in front of s
+func New(s store.ServiceInterface, options Options) *Service {
+	setDefaultOptions(&options)
+
+	return &Service{
+		store:   s,
+		options: options,
+		breaker: newBreaker(options.FailureThreshold, options.OpenDuration),
+	}
+}
+
+// SaveUserSession saves a user session in the store
+func (s *Service) SaveUserSession(userSession *user.Session) error {
+	return s.SaveUserSessionContext(context.Background(), userSession)
+}
+
+// SaveUserSessionContext is like SaveUserSession, but passes ctx to the store if it supports it, and stops \
+// retrying when ctx is done
+func (s *Service) SaveUserSessionContext(ctx context.Context, userSession *user.Session) error {
+	return s.do(ctx, func() error {
+		if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+			return contextStore.SaveUserSessionContext(ctx, userSession)
+		}
+		return s.store.SaveUserSession(userSession)
+	})
+}
+
+// SaveUserSessionIfVersion saves a user session in the store if the stored session's version is version. The \
+// store must implement store.VersionedServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Note that if an attempt failed after the store saved the session, the retry fails with \
+// store.ErrVersionConflict.
+func (s *Service) SaveUserSessionIfVersion(userSession *user.Session, version int64) error {
+	versionedStore, ok := s.store.(store.VersionedServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.do(context.Background(), func() error {
+		return versionedStore.SaveUserSessionIfVersion(userSession, version)
+	})
+}
+
+// SetUserSessionField sets a named field of a stored session's Data. The store must implement \
+// store.FieldServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) SetUserSessionField(sessionID string, name string, value string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.do(context.Background(), func() error {
+		return fieldStore.SetUserSessionField(sessionID, name, value)
+	})
+}
+
+// DeleteUserSessionField deletes a named field from a stored session's Data. The store must implement \
+// store.FieldServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) DeleteUserSessionField(sessionID string, name string) error {
+	fieldStore, ok := s.store.(store.FieldServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.do(context.Background(), func() error {
+		return fieldStore.DeleteUserSessionField(sessionID, name)
+	})
+}
+
+// SaveUserSessionWithLimit saves a new user session in the store, with a limit on its user's sessions, and stops \
+// retrying when ctx is done. The store must implement store.LimitServiceInterface, otherwise \
+// store.ErrNotSupported is returned.
+//
+// Note that if an attempt failed after the store saved the session, the retry counts the session against the \
+// limit only once, since it is saved under the same id.
+func (s *Service) SaveUserSessionWithLimit(ctx context.Context, userSession *user.Session, limit int, evict bool) ([]string, error) {
+	limitStore, ok := s.store.(store.LimitServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	var evictedSessionIDs []string
+	err := s.do(ctx, func() error {
+		ids, err := limitStore.SaveUserSessionWithLimit(ctx, userSession, limit, evict)
+		evictedSessionIDs = append(evictedSessionIDs, ids...)
+		return err
+	})
+
+	return evictedSessionIDs, err
+}
+
+// TouchUserSession ranks a session as its user's most recently used session in the store, and stops retrying \
+// when ctx is done. The store must implement store.LimitServiceInterface, otherwise store.ErrNotSupported is \
+// returned.
+func (s *Service) TouchUserSession(ctx context.Context, userSession *user.Session) error {
+	limitStore, ok := s.store.(store.LimitServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.do(ctx, func() error {
+		return limitStore.TouchUserSession(ctx, userSession)
+	})
+}
+
+// RegenerateUserSession moves a session to a new id in the store, and stops retrying when ctx is done. The store \
+// must implement store.RegenerateServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Note that retries are safe, since the new session is saved under the same id, and the old id is already deleted.
+func (s *Service) RegenerateUserSession(ctx context.Context, sessionID string, userSession *user.Session) error {
+	regenerateStore, ok := s.store.(store.RegenerateServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.do(ctx, func() error {
+		return regenerateStore.RegenerateUserSession(ctx, sessionID, userSession)
+	})
+}
+
+// LockUserSession acquires the lock of a session in the store, and stops retrying when ctx is done. The store \
+// must implement store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Note that if an attempt failed after the store acquired the lock, the retry fails with store.ErrLocked until the \
+// lock expires.
+func (s *Service) LockUserSession(ctx context.Context, sessionID string, ttl time.Duration) (int64, error) {
+	lockStore, ok := s.store.(store.LockServiceInterface)
+	if !ok {
+		return 0, store.ErrNotSupported
+	}
+
+	var token int64
+	err := s.do(ctx, func() error {
+		var err error
+		token, err = lockStore.LockUserSession(ctx, sessionID, ttl)
+		return err
+	})
+
+	return token, err
+}
+
+// UnlockUserSession releases the lock of a session in the store, and stops retrying when ctx is done. The store \
+// must implement store.LockServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Note that if an attempt failed after the store released the lock, the retry fails with store.ErrLockNotHeld.
+func (s *Service) UnlockUserSession(ctx context.Context, sessionID string, token int64) error {
+	lockStore, ok := s.store.(store.LockServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.do(ctx, func() error {
+		return lockStore.UnlockUserSession(ctx, sessionID, token)
+	})
+}
+
+// Ping checks whether the store can be reached, and stops retrying when ctx is done. The store must implement \
+// store.HealthServiceInterface, otherwise store.ErrNotSupported is returned.
+//
+// Note that while the circuit is open, Ping fails fast with ErrCircuitOpen.
+func (s *Service) Ping(ctx context.Context) error {
+	healthStore, ok := s.store.(store.HealthServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.do(ctx, func() error {
+		return healthStore.Ping(ctx)
+	})
+}
+
+// DeleteUserSession deletes a user session from the store
+func (s *Service) DeleteUserSession(sessionID string) error {
+	return s.DeleteUserSessionContext(context.Background(), sessionID)
+}
+
+// DeleteUserSessionContext is like DeleteUserSession, but passes ctx to the store if it supports it, and stops \
+// retrying when ctx is done
+func (s *Service) DeleteUserSessionContext(ctx context.Context, sessionID string) error {
+	return s.do(ctx, func() error {
+		if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+			return contextStore.DeleteUserSessionContext(ctx, sessionID)
+		}
+		return s.store.DeleteUserSession(sessionID)
+	})
+}
+
+// FetchValidUserSession fetches a user session from the store. If a valid session does not exist, this function \
+// returns a nil pointer
+func (s *Service) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	return s.FetchValidUserSessionContext(context.Background(), sessionID)
+}
+
+// FetchValidUserSessionContext is like FetchValidUserSession, but passes ctx to the store if it supports it, and \
+// stops retrying when ctx is done
+func (s *Service) FetchValidUserSessionContext(ctx context.Context, sessionID string) (*user.Session, error) {
+	var userSession *user.Session
+	err := s.do(ctx, func() error {
+		var err error
+		if contextStore, ok := s.store.(store.ContextServiceInterface); ok {
+			userSession, err = contextStore.FetchValidUserSessionContext(ctx, sessionID)
+		} else {
+			userSession, err = s.store.FetchValidUserSession(sessionID)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return userSession, nil
+}
+
+// ListUserSessions returns all valid sessions of a user from the store. The store must implement \
+// store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) ListUserSessions(userID string) ([]*user.Session, error) {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return nil, store.ErrNotSupported
+	}
+
+	var userSessions []*user.Session
+	err := s.do(context.Background(), func() error {
+		var err error
+		userSessions, err = userStore.ListUserSessions(userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return userSessions, nil
+}
+
+// DeleteAllUserSessions deletes all sessions of a user from the store. The store must implement \
+// store.UserServiceInterface, otherwise store.ErrNotSupported is returned.
+func (s *Service) DeleteAllUserSessions(userID string) error {
+	userStore, ok := s.store.(store.UserServiceInterface)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	return s.do(context.Background(), func() error {
+		return userStore.DeleteAllUserSessions(userID)
+	})
+}
+
+// Scan returns a batch of valid sessions from the store. The store must implement store.ScanServiceInterface, \
+// otherwise store.ErrNotSupported is returned.
+func (s *Service) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	scanStore, ok := s.store.(store.ScanServiceInterface)
+	if !ok {
+		return nil, "", store.ErrNotSupported
+	}
+
+	var userSessions []*user.Session
+	var next string
+	err := s.do(context.Background(), func() error {
+		var err error
+		userSessions, next, err = scanStore.Scan(cursor, count)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return userSessions, next, nil
+}
+
+// do calls op, retrying transient failures with exponential backoff, unless the circuit is open. The circuit \
+// records whether op eventually succeeded.
+func (s *Service) do(ctx context.Context, op func() error) error {
+	if !s.breaker.allow() {
+		return ErrCircuitOpen
+	}
+
+	backoff := s.options.InitialBackoffDuration
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = op(); err == nil || !s.options.IsTransient(err) || attempt >= s.options.MaxRetries {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.breaker.release()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > s.options.MaxBackoffDuration {
+			backoff = s.options.MaxBackoffDuration
+		}
+	}
+
+	switch {
+	case err != nil && s.options.IsTransient(err):
+		s.breaker.record(err)
+	case ctx.Err() != nil:
+		// note: the caller gave up, which says nothing about the store
+		s.breaker.release()
+	default:
+		// note: the store answered, even if with an error
+		s.breaker.record(nil)
+	}
+
+	return err
+}
@@ -0,0 +1,177 @@
+// +build unit
+
+package resilient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// note: this will fail to compile if the resilient store does not implement the store interfaces
+var (
+	_ store.ServiceInterface           = (*Service)(nil)
+	_ store.ContextServiceInterface    = (*Service)(nil)
+	_ store.UserServiceInterface       = (*Service)(nil)
+	_ store.ScanServiceInterface       = (*Service)(nil)
+	_ store.VersionedServiceInterface  = (*Service)(nil)
+	_ store.FieldServiceInterface      = (*Service)(nil)
+	_ store.LockServiceInterface       = (*Service)(nil)
+	_ store.HealthServiceInterface     = (*Service)(nil)
+	_ store.LimitServiceInterface      = (*Service)(nil)
+	_ store.RegenerateServiceInterface = (*Service)(nil)
+)
+
+var errPermanent = errors.New("permanent err")
+
+// flakyStore fails with the queued errors, then succeeds
+type flakyStore struct {
+	mu    sync.Mutex
+	errs  []error
+	calls int
+}
+
+func (f *flakyStore) next() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *flakyStore) SaveUserSession(userSession *user.Session) error {
+	return f.next()
+}
+
+func (f *flakyStore) DeleteUserSession(sessionID string) error {
+	return f.next()
+}
+
+func (f *flakyStore) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	if err := f.next(); err != nil {
+		return nil, err
+	}
+	return &user.Session{ID: sessionID}, nil
+}
+
+var testOptions = Options{
+	MaxRetries:             2,
+	InitialBackoffDuration: 1 * time.Millisecond,
+	MaxBackoffDuration:     2 * time.Millisecond,
+	FailureThreshold:       2,
+	OpenDuration:           20 * time.Millisecond,
+}
+
+// TestRetries tests that transient errors are retried, and others are not
+func TestRetries(t *testing.T) {
+	var tests = []struct {
+		input         []error
+		expectedErr   error
+		expectedCalls int
+	}{
+		{nil, nil, 1},
+		{[]error{io.EOF}, nil, 2},
+		{[]error{io.EOF, io.EOF}, nil, 3},
+		{[]error{io.EOF, io.EOF, io.EOF}, io.EOF, 3},
+		{[]error{errPermanent}, errPermanent, 1},
+		{[]error{io.EOF, errPermanent}, errPermanent, 2},
+	}
+
+	for idx, tt := range tests {
+		inner := &flakyStore{errs: tt.input}
+		s := New(inner, testOptions)
+
+		a, e := s.FetchValidUserSession("sessionID")
+		if e != tt.expectedErr || inner.calls != tt.expectedCalls || (e == nil && a == nil) {
+			t.Errorf("test #%d failed; expected err: %v, expected calls: %d, received err: %v, received calls: %d, received session: %v", idx+1, tt.expectedErr, tt.expectedCalls, e, inner.calls, a)
+		}
+	}
+}
+
+// TestCircuitBreaker tests that the circuit opens after repeated failures, and closes once the store recovers
+func TestCircuitBreaker(t *testing.T) {
+	inner := &flakyStore{}
+	s := New(inner, Options{MaxRetries: -1, FailureThreshold: 2, OpenDuration: 20 * time.Millisecond})
+
+	inner.errs = []error{io.EOF, io.EOF, io.EOF}
+	for idx := 0; idx < 2; idx++ {
+		if e := s.SaveUserSession(&user.Session{}); e != io.EOF {
+			t.Errorf("test failed; expected err: %v, received: %v", io.EOF, e)
+		}
+	}
+
+	// note: the circuit is open, so the store must not be called
+	if e := s.DeleteUserSession("sessionID"); e != ErrCircuitOpen || inner.calls != 2 {
+		t.Errorf("test failed; expected err: %v and 2 calls, received err: %v, calls: %d", ErrCircuitOpen, e, inner.calls)
+	}
+
+	// note: the trial operation fails, so the circuit opens again
+	time.Sleep(30 * time.Millisecond)
+	if e := s.DeleteUserSession("sessionID"); e != io.EOF {
+		t.Errorf("test failed; expected err: %v, received: %v", io.EOF, e)
+	}
+	if e := s.DeleteUserSession("sessionID"); e != ErrCircuitOpen {
+		t.Errorf("test failed; expected err: %v, received: %v", ErrCircuitOpen, e)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	for idx := 0; idx < 2; idx++ {
+		if e := s.DeleteUserSession("sessionID"); e != nil {
+			t.Errorf("test failed; expected the circuit to close, received err: %v", e)
+		}
+	}
+}
+
+// TestNonTransientErrors tests that the store answering with an error doesn't open the circuit
+func TestNonTransientErrors(t *testing.T) {
+	inner := &flakyStore{errs: []error{errPermanent, errPermanent, errPermanent}}
+	s := New(inner, testOptions)
+
+	for idx := 0; idx < 3; idx++ {
+		if e := s.DeleteUserSession("sessionID"); e != errPermanent {
+			t.Errorf("test #%d failed; expected err: %v, received: %v", idx+1, errPermanent, e)
+		}
+	}
+}
+
+// TestContextCancellation tests that retries stop when the context is done
+func TestContextCancellation(t *testing.T) {
+	inner := &flakyStore{errs: []error{io.EOF, io.EOF, io.EOF}}
+	s := New(inner, Options{InitialBackoffDuration: 1 * time.Second, FailureThreshold: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if e := s.DeleteUserSessionContext(ctx, "sessionID"); e != context.DeadlineExceeded || inner.calls != 1 {
+		t.Errorf("test failed; expected err: %v and 1 call, received err: %v, calls: %d", context.DeadlineExceeded, e, inner.calls)
+	}
+
+	// note: a cancelled operation isn't held against the store
+	inner.errs = nil
+	if e := s.DeleteUserSession("sessionID"); e == ErrCircuitOpen {
+		t.Errorf("test failed; expected the circuit to be closed, received err: %v", e)
+	}
+}
+
+// TestNotSupported tests that user operations require a store that supports them
+func TestNotSupported(t *testing.T) {
+	s := New(&flakyStore{}, Options{})
+
+	if _, e := s.ListUserSessions("userID"); e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrNotSupported, e)
+	}
+	if e := s.DeleteAllUserSessions("userID"); e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrNotSupported, e)
+	}
+}
@@ -0,0 +1,44 @@
+package resilient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+)
+
+// setDefaultOptions sets default values for nil fields
+func setDefaultOptions(options *Options) {
+	emptyOptions := Options{}
+	if options.MaxRetries == emptyOptions.MaxRetries {
+		options.MaxRetries = DefaultMaxRetries
+	}
+	if options.InitialBackoffDuration <= emptyOptions.InitialBackoffDuration {
+		options.InitialBackoffDuration = DefaultInitialBackoffDuration
+	}
+	if options.MaxBackoffDuration <= emptyOptions.MaxBackoffDuration {
+		options.MaxBackoffDuration = DefaultMaxBackoffDuration
+	}
+	if options.FailureThreshold <= emptyOptions.FailureThreshold {
+		options.FailureThreshold = DefaultFailureThreshold
+	}
+	if options.OpenDuration <= emptyOptions.OpenDuration {
+		options.OpenDuration = DefaultOpenDuration
+	}
+	if options.IsTransient == nil {
+		options.IsTransient = IsTransient
+	}
+
+	return
+}
+
+// IsTransient reports whether err is a network error, like a refused connection, a timeout or a connection \
+// closed by the server. Context errors are not transient: the caller gave up, the store didn't fail.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,65 @@
+// +build unit
+
+package resilient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestSetDefaultOptions tests the setDefaultOptions function
+func TestSetDefaultOptions(t *testing.T) {
+	var tests = []struct {
+		input    Options
+		expected Options
+	}{
+		{Options{}, Options{MaxRetries: DefaultMaxRetries, InitialBackoffDuration: DefaultInitialBackoffDuration, MaxBackoffDuration: DefaultMaxBackoffDuration, FailureThreshold: DefaultFailureThreshold, OpenDuration: DefaultOpenDuration}},
+		{testOptions, testOptions},
+		{Options{MaxRetries: -1}, Options{MaxRetries: -1, InitialBackoffDuration: DefaultInitialBackoffDuration, MaxBackoffDuration: DefaultMaxBackoffDuration, FailureThreshold: DefaultFailureThreshold, OpenDuration: DefaultOpenDuration}},
+	}
+
+	for idx, tt := range tests {
+		setDefaultOptions(&tt.input)
+		// note: funcs can't be compared, so only check one was set
+		isTransientSet := tt.input.IsTransient != nil
+		tt.input.IsTransient = nil
+		assert := tt.input.MaxRetries == tt.expected.MaxRetries && tt.input.InitialBackoffDuration == tt.expected.InitialBackoffDuration &&
+			tt.input.MaxBackoffDuration == tt.expected.MaxBackoffDuration && tt.input.FailureThreshold == tt.expected.FailureThreshold &&
+			tt.input.OpenDuration == tt.expected.OpenDuration && isTransientSet
+
+		if !assert {
+			t.Errorf("test #%d failed; assert: %t, expected: %v, received: %v\n", idx+1, assert, tt.expected, tt.input)
+		}
+	}
+}
+
+// TestIsTransient tests the IsTransient function
+func TestIsTransient(t *testing.T) {
+	_, dialErr := net.DialTimeout("tcp", "127.0.0.1:1", 100*time.Millisecond)
+
+	var tests = []struct {
+		input    error
+		expected bool
+	}{
+		{nil, false},
+		{errors.New("test err"), false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{io.EOF, true},
+		{fmt.Errorf("wrapped: %w", io.ErrUnexpectedEOF), true},
+		{dialErr, true},
+	}
+
+	for idx, tt := range tests {
+		a := IsTransient(tt.input)
+
+		if a != tt.expected {
+			t.Errorf("test #%d failed; input: %v, expected: %t, received: %t", idx+1, tt.input, tt.expected, a)
+		}
+	}
+}
@@ -3,15 +3,19 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/adam-hanna/sessions/user"
-	"github.com/garyburd/redigo/redis"
+	"github.com/gomodule/redigo/redis"
 )
 
 var (
@@ -241,3 +245,853 @@ func TestDeleteUserSession(t *testing.T) {
 		}
 	}
 }
+
+// TestListUserSessions tests the ListUserSessions and DeleteAllUserSessions functions
+func TestListUserSessions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestListUserSessions, an integration test")
+	}
+
+	userID := "listUserSessionsUserID"
+	userSessions := []*user.Session{
+		{ID: "listUserSessionsID1", UserID: userID, JSON: "json1", ExpiresAt: time.Now().Add(1 * time.Hour)},
+		{ID: "listUserSessionsID2", UserID: userID, JSON: "json2", ExpiresAt: time.Now().Add(2 * time.Hour)},
+		{ID: "listUserSessionsExpiredID", UserID: userID, JSON: "json3", ExpiresAt: time.Now().Add(-1 * time.Hour)},
+	}
+	for _, userSession := range userSessions {
+		if err := service.SaveUserSession(userSession); err != nil {
+			t.Fatalf("Err saving user session: %v", err)
+		}
+	}
+
+	c := service.Pool.Get()
+	defer c.Close()
+
+	// note: the expired session must have been pruned from the index on save
+	count, err := redis.Int(c.Do("ZCARD", userSessionsKey(userID)))
+	if err != nil || count != 2 {
+		t.Errorf("test failed; expected index to contain 2 sessions, received: %d, err: %v", count, err)
+	}
+
+	// deleting a session must remove it from the index
+	if err := service.DeleteUserSession(userSessions[0].ID); err != nil {
+		t.Fatalf("Err deleting user session: %v", err)
+	}
+
+	a, e := service.ListUserSessions(userID)
+	if e != nil || len(a) != 1 || a[0].ID != userSessions[1].ID || a[0].JSON != userSessions[1].JSON {
+		t.Errorf("test failed; expected sessions: [%v], received sessions: %v, received err: %v", userSessions[1], a, e)
+	}
+
+	if e := service.DeleteAllUserSessions(userID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting all user sessions, received: %v", e)
+	}
+
+	for _, key := range []string{userSessions[1].ID, userSessionsKey(userID)} {
+		exists, err := redis.Bool(c.Do("EXISTS", key))
+		if err != nil || exists {
+			t.Errorf("test failed; expected key %s to be deleted, received exists: %t, err: %v", key, exists, err)
+		}
+	}
+}
+
+// TestSaveUserSessionExpiry tests that SaveUserSession writes the session and its expiry together
+func TestSaveUserSessionExpiry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSaveUserSessionExpiry, an integration test")
+	}
+
+	userSession := &user.Session{
+		ID:        "saveUserSessionExpiryID",
+		UserID:    "saveUserSessionExpiryUserID",
+		JSON:      "saveUserSessionExpiryJSON",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	if err := service.SaveUserSession(userSession); err != nil {
+		t.Fatalf("Err saving user session: %v", err)
+	}
+	defer service.DeleteUserSession(userSession.ID)
+
+	c := service.Pool.Get()
+	defer c.Close()
+
+	for _, key := range []string{userSession.ID, userSessionsKey(userSession.UserID)} {
+		ttl, err := redis.Int(c.Do("TTL", key))
+		if err != nil || ttl <= 0 {
+			t.Errorf("test failed; expected key %s to have a ttl, received ttl: %d, err: %v", key, ttl, err)
+		}
+	}
+}
+
+// TestExpiryShadowKeys tests that a shadow key holding the user id outlives each saved session
+func TestExpiryShadowKeys(t *testing.T) {
+	shadowService := New(Options{ConnectionAddress: os.Getenv("REDIS_URL"), KeyPrefix: "shadowed:", ExpiryShadowKeys: true})
+	defer shadowService.Close()
+
+	userSession := &user.Session{
+		ID:        "expiryShadowKeysID",
+		UserID:    "expiryShadowKeysUserID",
+		JSON:      "expiryShadowKeysJSON",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	if err := shadowService.SaveUserSession(userSession); err != nil {
+		t.Fatalf("Err saving user session: %v", err)
+	}
+	defer shadowService.DeleteUserSession(userSession.ID)
+
+	c := shadowService.Pool.Get()
+	defer c.Close()
+
+	shadowKey := shadowService.shadowKey(userSession.ID)
+	defer c.Do("DEL", shadowKey)
+
+	userID, err := redis.String(c.Do("GET", shadowKey))
+	if err != nil || userID != userSession.UserID {
+		t.Errorf("test failed; expected shadow key to hold %s, received: %s, err: %v", userSession.UserID, userID, err)
+	}
+
+	sessionTTL, _ := redis.Int(c.Do("TTL", shadowService.sessionKey(userSession.ID)))
+	shadowTTL, _ := redis.Int(c.Do("TTL", shadowKey))
+	if sessionTTL <= 0 || shadowTTL <= sessionTTL {
+		t.Errorf("test failed; expected shadow key to outlive the session, received ttls: %d, %d", sessionTTL, shadowTTL)
+	}
+}
+
+// TestPoolStats tests that the store answers pings, and reports its pool statistics
+func TestPoolStats(t *testing.T) {
+	if e := service.Ping(context.Background()); e != nil {
+		t.Errorf("test failed; expected err to be nil, received: %v", e)
+	}
+	if stats := service.PoolStats(); stats.ActiveCount == 0 || stats.IdleCount == 0 {
+		t.Errorf("test failed; expected an idle connection in the pool, received stats: %v", stats)
+	}
+}
+
+// TestDatabase tests that a store with a Database reads and writes the sessions of that db only
+func TestDatabase(t *testing.T) {
+	options, err := ParseURL("redis://" + os.Getenv("REDIS_URL") + "/1")
+	if err != nil {
+		t.Fatalf("Err parsing url: %v", err)
+	}
+	options.HealthCheckIntervalDuration = 1 * time.Second
+	databaseService := New(options)
+	defer databaseService.Close()
+
+	userSession := &user.Session{
+		ID:        "databaseID",
+		UserID:    "databaseUserID",
+		JSON:      "databaseJSON",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	if err := databaseService.SaveUserSession(userSession); err != nil {
+		t.Fatalf("Err saving user session: %v", err)
+	}
+	defer databaseService.DeleteUserSession(userSession.ID)
+
+	var tests = []struct {
+		input    *Service
+		expected bool
+	}{
+		{databaseService, true},
+		{service, false},
+	}
+
+	for idx, tt := range tests {
+		a, e := tt.input.FetchValidUserSession(userSession.ID)
+
+		if e != nil || (a != nil) != tt.expected {
+			t.Errorf("test #%d failed; expected session: %t, received: %v, received err: %v", idx+1, tt.expected, a, e)
+		}
+	}
+}
+
+// TestSaveUserSessionIfVersion tests that sessions are only saved if their stored version is the expected one
+func TestSaveUserSessionIfVersion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSaveUserSessionIfVersion, an integration test")
+	}
+
+	userSession := &user.Session{
+		ID:        "saveUserSessionIfVersionID",
+		UserID:    "saveUserSessionIfVersionUserID",
+		JSON:      "saveUserSessionIfVersionJSON",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	defer service.DeleteAllUserSessions(userSession.UserID)
+
+	var tests = []struct {
+		input           int64
+		expectedErr     error
+		expectedVersion int64
+	}{
+		{1, ErrVersionConflict, 0},
+		{0, nil, 1},
+		{0, ErrVersionConflict, 1},
+		{1, nil, 2},
+	}
+
+	for idx, tt := range tests {
+		e := service.SaveUserSessionIfVersion(userSession, tt.input)
+		stored, _ := service.FetchValidUserSession(userSession.ID)
+		var version int64
+		if stored != nil {
+			version = stored.Version
+		}
+
+		if e != tt.expectedErr || version != tt.expectedVersion {
+			t.Errorf("test #%d failed; input: %d, expected err: %v, expected version: %d, received err: %v, received version: %d", idx+1, tt.input, tt.expectedErr, tt.expectedVersion, e, version)
+		}
+	}
+
+	if e := service.SaveUserSession(userSession); e != nil || userSession.Version != 3 {
+		t.Errorf("test failed; expected the session to be saved with version 3, received: %d, err: %v", userSession.Version, e)
+	}
+	if userSessions, e := service.ListUserSessions(userSession.UserID); e != nil || len(userSessions) != 1 || userSessions[0].Version != 3 {
+		t.Errorf("test failed; expected 1 listed session with version 3, received: %v, err: %v", userSessions, e)
+	}
+}
+
+// TestUserSessionFields tests that data fields are saved, and set or deleted one by one
+func TestUserSessionFields(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestUserSessionFields, an integration test")
+	}
+
+	userSession := &user.Session{
+		ID:        "userSessionFieldsID",
+		UserID:    "userSessionFieldsUserID",
+		JSON:      "userSessionFieldsJSON",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+		Data:      map[string]string{"cart": "1", "theme": "dark"},
+	}
+	if err := service.SaveUserSession(userSession); err != nil {
+		t.Fatalf("Err saving user session: %v", err)
+	}
+	defer service.DeleteAllUserSessions(userSession.UserID)
+
+	var tests = []struct {
+		input           func() error
+		expectedErr     error
+		expectedData    map[string]string
+		expectedVersion int64
+	}{
+		{func() error { return nil }, nil, map[string]string{"cart": "1", "theme": "dark"}, 1},
+		{func() error { return service.SetUserSessionField(userSession.ID, "cart", "2") }, nil, map[string]string{"cart": "2", "theme": "dark"}, 2},
+		{func() error { return service.SetUserSessionField(userSession.ID, "locale", "en") }, nil, map[string]string{"cart": "2", "theme": "dark", "locale": "en"}, 3},
+		{func() error { return service.DeleteUserSessionField(userSession.ID, "theme") }, nil, map[string]string{"cart": "2", "locale": "en"}, 4},
+		{func() error { return service.SetUserSessionField("missingSessionID", "cart", "1") }, ErrSessionNotFound, map[string]string{"cart": "2", "locale": "en"}, 4},
+		{func() error { return service.DeleteUserSessionField("missingSessionID", "cart") }, ErrSessionNotFound, map[string]string{"cart": "2", "locale": "en"}, 4},
+		{func() error {
+			// note: saving the whole session replaces its data
+			userSession.Data = map[string]string{"cart": "3"}
+			return service.SaveUserSession(userSession)
+		}, nil, map[string]string{"cart": "3"}, 5},
+		{func() error {
+			userSession.Data = map[string]string{"cart": "4"}
+			return service.SaveUserSessionIfVersion(userSession, 5)
+		}, nil, map[string]string{"cart": "4"}, 6},
+	}
+
+	for idx, tt := range tests {
+		e := tt.input()
+		a, _ := service.FetchValidUserSession(userSession.ID)
+
+		if e != tt.expectedErr || a == nil || !reflect.DeepEqual(a.Data, tt.expectedData) || a.Version != tt.expectedVersion || a.JSON != userSession.JSON {
+			t.Errorf("test #%d failed; expected err: %v, expected data: %v, expected version: %d, received err: %v, received session: %v", idx+1, tt.expectedErr, tt.expectedData, tt.expectedVersion, e, a)
+		}
+	}
+
+	c := service.Pool.Get()
+	defer c.Close()
+	if exists, _ := redis.Bool(c.Do("EXISTS", service.sessionKey("missingSessionID"))); exists {
+		t.Errorf("test failed; expected setting a field not to create a session")
+	}
+}
+
+// TestLockUserSession tests that a session can only be locked once at a time, and only unlocked by its holder
+func TestLockUserSession(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestLockUserSession, an integration test")
+	}
+
+	c := service.Pool.Get()
+	defer c.Close()
+	defer c.Do("DEL", lockTokensKey)
+
+	ctx := context.Background()
+	sessionID := "lockUserSessionID"
+	token, err := service.LockUserSession(ctx, sessionID, 1*time.Hour)
+	if err != nil {
+		t.Fatalf("Err locking user session: %v", err)
+	}
+	expiringToken, err := service.LockUserSession(ctx, "expiringLockUserSessionID", 50*time.Millisecond)
+	if err != nil || expiringToken <= token {
+		t.Fatalf("test failed; expected a larger token than %d, received token: %d, err: %v", token, expiringToken, err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	var tests = []struct {
+		run         func() error
+		expectedErr error
+	}{
+		{func() error { _, err := service.LockUserSession(ctx, sessionID, 1*time.Hour); return err }, ErrLocked},
+		{func() error { _, err := service.LockUserSession(ctx, sessionID, 0); return err }, ErrInvalidLockTTL},
+		{func() error { return service.UnlockUserSession(ctx, sessionID, token+100) }, ErrLockNotHeld},
+		{func() error { return service.UnlockUserSession(ctx, "expiringLockUserSessionID", expiringToken) }, ErrLockNotHeld},
+		{func() error { return service.UnlockUserSession(ctx, sessionID, token) }, nil},
+		{func() error { return service.UnlockUserSession(ctx, sessionID, token) }, ErrLockNotHeld},
+		{func() error {
+			newToken, err := service.LockUserSession(ctx, sessionID, 1*time.Hour)
+			if err == nil {
+				err = service.UnlockUserSession(ctx, sessionID, newToken)
+			}
+			return err
+		}, nil},
+	}
+
+	for idx, tt := range tests {
+		if e := tt.run(); e != tt.expectedErr {
+			t.Errorf("test #%d failed; expected err: %v, received: %v", idx+1, tt.expectedErr, e)
+		}
+	}
+}
+
+// TestSaveUserSessionWithLimit tests that a user's sessions are capped, and that the least recently issued or \
+// touched sessions are evicted first
+func TestSaveUserSessionWithLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSaveUserSessionWithLimit, an integration test")
+	}
+
+	ctx := context.Background()
+	userID := "limitedUserID"
+	defer service.DeleteAllUserSessions(userID)
+
+	newUserSession := func(id string) *user.Session {
+		return &user.Session{ID: id, UserID: userID, JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	}
+	a, b, c := newUserSession("limitSessionA"), newUserSession("limitSessionB"), newUserSession("limitSessionC")
+
+	var tests = []struct {
+		input           func() ([]string, error)
+		expectedEvicted []string
+		expectedErr     error
+		expectedIDs     []string
+	}{
+		{func() ([]string, error) { return service.SaveUserSessionWithLimit(ctx, a, 2, false) }, []string{}, nil, []string{a.ID}},
+		{func() ([]string, error) { return service.SaveUserSessionWithLimit(ctx, b, 2, false) }, []string{}, nil, []string{a.ID, b.ID}},
+		{func() ([]string, error) { return service.SaveUserSessionWithLimit(ctx, c, 2, false) }, nil, ErrSessionLimitReached, []string{a.ID, b.ID}},
+		{func() ([]string, error) { return service.SaveUserSessionWithLimit(ctx, a, 2, false) }, []string{}, nil, []string{a.ID, b.ID}},
+		{func() ([]string, error) { return service.SaveUserSessionWithLimit(ctx, c, 2, true) }, []string{b.ID}, nil, []string{a.ID, c.ID}},
+		{func() ([]string, error) { return nil, service.TouchUserSession(ctx, a) }, nil, nil, []string{a.ID, c.ID}},
+		{func() ([]string, error) { return service.SaveUserSessionWithLimit(ctx, b, 1, true) }, []string{c.ID, a.ID}, nil, []string{b.ID}},
+	}
+
+	for idx, tt := range tests {
+		// note: keep the ranks of consecutive saves and touches apart
+		time.Sleep(2 * time.Millisecond)
+		evicted, e := tt.input()
+
+		userSessions, _ := service.ListUserSessions(userID)
+		var ids []string
+		for _, userSession := range userSessions {
+			ids = append(ids, userSession.ID)
+		}
+		sort.Strings(ids)
+
+		if e != tt.expectedErr || !reflect.DeepEqual(evicted, tt.expectedEvicted) || !reflect.DeepEqual(ids, tt.expectedIDs) {
+			t.Errorf("test #%d failed; expected err: %v, expected evicted: %v, expected ids: %v, received err: %v, received evicted: %v, received ids: %v", idx+1, tt.expectedErr, tt.expectedEvicted, tt.expectedIDs, e, evicted, ids)
+		}
+	}
+
+	conn := service.Pool.Get()
+	defer conn.Close()
+	if ttl, _ := redis.Int64(conn.Do("TTL", service.userSessionsRankKey(userID))); ttl <= 0 {
+		t.Errorf("test failed; expected the rank key to expire, received ttl: %d", ttl)
+	}
+}
+
+// TestSaveUserSessionWithLimitHashTag tests that users whose keys share a hash tag, here from the key prefix, are \
+// still ranked apart
+func TestSaveUserSessionWithLimitHashTag(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSaveUserSessionWithLimitHashTag, an integration test")
+	}
+
+	prefixedService := New(Options{ConnectionAddress: os.Getenv("REDIS_URL"), KeyPrefix: "{sessions}:"})
+	defer prefixedService.Close()
+
+	ctx := context.Background()
+	newUserSession := func(id string, userID string) *user.Session {
+		return &user.Session{ID: id, UserID: userID, JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	}
+	userIDs := []string{"hashTagUserA", "hashTagUserB"}
+	for _, userID := range userIDs {
+		defer prefixedService.DeleteAllUserSessions(userID)
+	}
+
+	save := func(id string, userID string) func() ([]string, error) {
+		return func() ([]string, error) {
+			return prefixedService.SaveUserSessionWithLimit(ctx, newUserSession(id, userID), 2, true)
+		}
+	}
+	touch := func(id string, userID string) func() ([]string, error) {
+		return func() ([]string, error) { return nil, prefixedService.TouchUserSession(ctx, newUserSession(id, userID)) }
+	}
+
+	var tests = []struct {
+		input           func() ([]string, error)
+		expectedEvicted []string
+	}{
+		{save("hashTagA1", userIDs[0]), []string{}},
+		{save("hashTagB1", userIDs[1]), []string{}},
+		{save("hashTagA2", userIDs[0]), []string{}},
+		{save("hashTagB2", userIDs[1]), []string{}},
+		{touch("hashTagA1", userIDs[0]), nil},
+		{touch("hashTagB1", userIDs[1]), nil},
+		{save("hashTagA3", userIDs[0]), []string{"hashTagA2"}},
+		{save("hashTagB3", userIDs[1]), []string{"hashTagB2"}},
+	}
+
+	for idx, tt := range tests {
+		// note: keep the ranks of consecutive saves and touches apart
+		time.Sleep(2 * time.Millisecond)
+		evicted, e := tt.input()
+
+		if e != nil || !reflect.DeepEqual(evicted, tt.expectedEvicted) {
+			t.Errorf("test #%d failed; expected evicted: %v, received evicted: %v, received err: %v", idx+1, tt.expectedEvicted, evicted, e)
+		}
+	}
+}
+
+// TestCreatedAt tests that the creation time of a session is stored, and that sessions saved without one, or \
+// before it was stored, have none
+func TestCreatedAt(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestCreatedAt, an integration test")
+	}
+
+	createdAt := time.Unix(time.Now().Add(-1*time.Hour).Unix(), 0)
+	sessionID := "createdAtSessionID"
+	defer service.DeleteUserSession(sessionID)
+
+	var tests = []struct {
+		input             func() error
+		expectedCreatedAt time.Time
+	}{
+		{func() error {
+			return service.SaveUserSession(&user.Session{ID: sessionID, UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour), CreatedAt: createdAt})
+		}, createdAt},
+		{func() error {
+			return service.SaveUserSessionIfVersion(&user.Session{ID: sessionID, UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}, 1)
+		}, time.Time{}},
+		{func() error {
+			c := service.Pool.Get()
+			defer c.Close()
+			_, err := c.Do("HMSET", service.sessionKey(sessionID), "UserID", "userID", "JSON", "json", "ExpiresAtSeconds", time.Now().Add(1*time.Hour).Unix())
+			if err == nil {
+				_, err = c.Do("HDEL", service.sessionKey(sessionID), "CreatedAtSeconds")
+			}
+			return err
+		}, time.Time{}},
+	}
+
+	for idx, tt := range tests {
+		if err := tt.input(); err != nil {
+			t.Fatalf("Err saving user session: %v", err)
+		}
+
+		a, e := service.FetchValidUserSession(sessionID)
+		if e != nil || a == nil || !a.CreatedAt.Equal(tt.expectedCreatedAt) {
+			t.Errorf("test #%d failed; expected created at: %v, received session: %v, received err: %v", idx+1, tt.expectedCreatedAt, a, e)
+		}
+	}
+}
+
+// TestDuration tests that the duration and cookie kind of a session are stored, and that sessions saved before \
+// they were stored have neither
+func TestDuration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDuration, an integration test")
+	}
+
+	sessionID := "durationSessionID"
+	defer service.DeleteUserSession(sessionID)
+
+	var tests = []struct {
+		input                  func() error
+		expectedDuration       time.Duration
+		expectedBrowserSession bool
+	}{
+		{func() error {
+			return service.SaveUserSession(&user.Session{ID: sessionID, UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour), Duration: 30 * 24 * time.Hour})
+		}, 30 * 24 * time.Hour, false},
+		{func() error {
+			return service.SaveUserSessionIfVersion(&user.Session{ID: sessionID, UserID: "userID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour), Duration: 5 * time.Minute, BrowserSession: true}, 1)
+		}, 5 * time.Minute, true},
+		{func() error {
+			c := service.Pool.Get()
+			defer c.Close()
+			_, err := c.Do("HDEL", service.sessionKey(sessionID), "DurationSeconds", "BrowserSession")
+			return err
+		}, 0, false},
+	}
+
+	for idx, tt := range tests {
+		if err := tt.input(); err != nil {
+			t.Fatalf("Err saving user session: %v", err)
+		}
+
+		a, e := service.FetchValidUserSession(sessionID)
+		if e != nil || a == nil || a.Duration != tt.expectedDuration || a.BrowserSession != tt.expectedBrowserSession {
+			t.Errorf("test #%d failed; expected duration: %v, expected browser session: %t, received session: %v, received err: %v", idx+1, tt.expectedDuration, tt.expectedBrowserSession, a, e)
+		}
+	}
+}
+
+// TestRegenerateUserSession tests that a session is moved to its new id, that its old id is deleted, and that the \
+// new id takes over the old id's rank
+func TestRegenerateUserSession(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestRegenerateUserSession, an integration test")
+	}
+
+	ctx := context.Background()
+	userID := "regeneratedUserID"
+	defer service.DeleteAllUserSessions(userID)
+
+	newUserSession := func(id string) *user.Session {
+		return &user.Session{ID: id, UserID: userID, JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	}
+	a, b, c, d := newUserSession("regenerateSessionA"), newUserSession("regenerateSessionB"), newUserSession("regenerateSessionC"), newUserSession("regenerateSessionD")
+	service.SaveUserSessionWithLimit(ctx, a, 2, true)
+	time.Sleep(2 * time.Millisecond)
+	service.SaveUserSessionWithLimit(ctx, b, 2, true)
+
+	var tests = []struct {
+		input           func() ([]string, error)
+		expectedEvicted []string
+		expectedIDs     []string
+	}{
+		{func() ([]string, error) { return nil, service.RegenerateUserSession(ctx, a.ID, c) }, nil, []string{b.ID, c.ID}},
+		{func() ([]string, error) {
+			// note: c took over a's rank, so it is evicted before b
+			return service.SaveUserSessionWithLimit(ctx, d, 2, true)
+		}, []string{c.ID}, []string{b.ID, d.ID}},
+	}
+
+	for idx, tt := range tests {
+		evicted, e := tt.input()
+
+		userSessions, _ := service.ListUserSessions(userID)
+		var ids []string
+		for _, userSession := range userSessions {
+			ids = append(ids, userSession.ID)
+		}
+		sort.Strings(ids)
+
+		if e != nil || !reflect.DeepEqual(evicted, tt.expectedEvicted) || !reflect.DeepEqual(ids, tt.expectedIDs) {
+			t.Errorf("test #%d failed; expected evicted: %v, expected ids: %v, received err: %v, received evicted: %v, received ids: %v", idx+1, tt.expectedEvicted, tt.expectedIDs, e, evicted, ids)
+		}
+	}
+
+	if fetched, e := service.FetchValidUserSession(a.ID); fetched != nil || e != nil {
+		t.Errorf("test failed; expected the old session to be deleted, received session: %v, received err: %v", fetched, e)
+	}
+	if c.Version != 1 {
+		t.Errorf("test failed; expected the new session to have version 1, received: %d", c.Version)
+	}
+}
+
+// TestFetchValidUserSessionContext tests that FetchValidUserSessionContext honors the context
+func TestFetchValidUserSessionContext(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestFetchValidUserSessionContext, an integration test")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	a, e := service.FetchValidUserSessionContext(ctx, validUserSession.ID)
+	if a != nil || e == nil {
+		t.Errorf("test failed; expected an err and a nil session for a cancelled context, received session: %v, received err: %v", a, e)
+	}
+}
+
+// TestKeyPrefix tests that sessions are namespaced by key prefix and tenant
+func TestKeyPrefix(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestKeyPrefix, an integration test")
+	}
+
+	prefixedService := New(Options{ConnectionAddress: os.Getenv("REDIS_URL"), KeyPrefix: "sessions:"})
+	defer prefixedService.Close()
+	tenantService := prefixedService.WithTenant("tenant")
+
+	userSession := &user.Session{
+		ID:        "keyPrefixID",
+		UserID:    "keyPrefixUserID",
+		JSON:      "keyPrefixJSON",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	if err := tenantService.SaveUserSession(userSession); err != nil {
+		t.Fatalf("Err saving user session: %v", err)
+	}
+	defer tenantService.DeleteAllUserSessions(userSession.UserID)
+
+	c := service.Pool.Get()
+	defer c.Close()
+
+	for _, key := range []string{"sessions:tenant:" + userSession.ID, "sessions:tenant:" + userSessionsKey(userSession.UserID)} {
+		exists, err := redis.Bool(c.Do("EXISTS", key))
+		if err != nil || !exists {
+			t.Errorf("test failed; expected key %s to exist, received exists: %t, err: %v", key, exists, err)
+		}
+	}
+
+	var tests = []struct {
+		service        *Service
+		expectedExists bool
+	}{
+		{tenantService, true},
+		{prefixedService, false},
+		{prefixedService.WithTenant("otherTenant"), false},
+		{service, false},
+	}
+
+	for idx, tt := range tests {
+		a, e := tt.service.FetchValidUserSession(userSession.ID)
+		exists := a != nil
+
+		if e != nil || exists != tt.expectedExists || (exists && a.TenantID != "tenant") {
+			t.Errorf("test #%d failed; expected exists: %t, received session: %v, received err: %v", idx+1, tt.expectedExists, a, e)
+		}
+	}
+
+	otherTenantUserSession := *userSession
+	otherTenantUserSession.TenantID = "otherTenant"
+	if e := tenantService.SaveUserSession(&otherTenantUserSession); e != ErrTenantMismatch {
+		t.Errorf("test failed; expected err: %v, received: %v", ErrTenantMismatch, e)
+	}
+}
+
+// TestLegacyKeyFallback tests that sessions saved under un-prefixed keys are still found
+func TestLegacyKeyFallback(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestLegacyKeyFallback, an integration test")
+	}
+
+	userID := "legacyKeyFallbackUserID"
+	legacyUserSession := &user.Session{ID: "legacyKeyFallbackID", UserID: userID, JSON: "legacyJSON", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	if err := service.SaveUserSession(legacyUserSession); err != nil {
+		t.Fatalf("Err saving user session: %v", err)
+	}
+
+	prefixedService := New(Options{ConnectionAddress: os.Getenv("REDIS_URL"), KeyPrefix: "sessions:", LegacyKeyFallback: true})
+	defer prefixedService.Close()
+
+	userSession := &user.Session{ID: "keyFallbackID", UserID: userID, JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	if err := prefixedService.SaveUserSession(userSession); err != nil {
+		t.Fatalf("Err saving user session: %v", err)
+	}
+
+	a, e := prefixedService.FetchValidUserSession(legacyUserSession.ID)
+	if e != nil || a == nil || a.JSON != legacyUserSession.JSON {
+		t.Errorf("test failed; expected legacy session: %v, received: %v, received err: %v", legacyUserSession, a, e)
+	}
+
+	// note: saving a legacy session again writes it under its prefixed key, but it must be listed once
+	if err := prefixedService.SaveUserSession(legacyUserSession); err != nil {
+		t.Fatalf("Err saving user session: %v", err)
+	}
+	userSessions, e := prefixedService.ListUserSessions(userID)
+	if e != nil || len(userSessions) != 2 {
+		t.Errorf("test failed; expected 2 sessions, received: %v, received err: %v", userSessions, e)
+	}
+
+	if e := prefixedService.DeleteUserSession(legacyUserSession.ID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting the legacy session, received: %v", e)
+	}
+	for _, s := range []*Service{service, prefixedService} {
+		if a, e := s.FetchValidUserSession(legacyUserSession.ID); a != nil || e != nil {
+			t.Errorf("test failed; expected the legacy session to be deleted, received: %v, received err: %v", a, e)
+		}
+	}
+
+	if e := prefixedService.DeleteAllUserSessions(userID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting all user sessions, received: %v", e)
+	}
+
+	c := service.Pool.Get()
+	defer c.Close()
+
+	for _, key := range []string{"sessions:" + userSession.ID, "sessions:" + userSessionsKey(userID), userSessionsKey(userID)} {
+		exists, err := redis.Bool(c.Do("EXISTS", key))
+		if err != nil || exists {
+			t.Errorf("test failed; expected key %s to be deleted, received exists: %t, err: %v", key, exists, err)
+		}
+	}
+}
+
+// TestScan tests that scanning returns every valid session of the store's namespace
+func TestScan(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestScan, an integration test")
+	}
+
+	prefixedService := New(Options{ConnectionAddress: os.Getenv("REDIS_URL"), KeyPrefix: "scan:"})
+	defer prefixedService.Close()
+	tenantService := prefixedService.WithTenant("tenant")
+
+	for idx := 0; idx < 25; idx++ {
+		userSession := &user.Session{ID: fmt.Sprintf("scanSessionID%02d", idx), UserID: "scanUserID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)}
+		if err := prefixedService.SaveUserSession(userSession); err != nil {
+			t.Fatalf("Err saving user session: %v", err)
+		}
+	}
+	defer prefixedService.DeleteAllUserSessions("scanUserID")
+	tenantService.SaveUserSession(&user.Session{ID: "scanTenantSessionID", UserID: "scanUserID", JSON: "json", ExpiresAt: time.Now().Add(1 * time.Hour)})
+	defer tenantService.DeleteAllUserSessions("scanUserID")
+
+	c := service.Pool.Get()
+	defer c.Close()
+	// note: keys of the namespace that don't hold a session must be skipped
+	c.Do("SET", "scan:notASession", "value")
+	defer c.Do("DEL", "scan:notASession")
+
+	var tests = []struct {
+		service          *Service
+		inputCount       int
+		expectedSessions int
+	}{
+		{prefixedService, 0, 25},
+		{prefixedService, 5, 25},
+		{tenantService, 5, 1},
+	}
+
+	for idx, tt := range tests {
+		scanned := make(map[string]bool)
+		var cursor string
+		for {
+			userSessions, next, err := tt.service.Scan(cursor, tt.inputCount)
+			if err != nil {
+				t.Fatalf("test #%d failed; expected err to be nil, received: %v", idx+1, err)
+			}
+			for _, userSession := range userSessions {
+				scanned[userSession.ID] = true
+			}
+
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		if len(scanned) != tt.expectedSessions {
+			t.Errorf("test #%d failed; expected sessions: %d, received: %d", idx+1, tt.expectedSessions, len(scanned))
+		}
+	}
+}
+
+// TestCluster tests the store against a redis cluster. The comma separated addresses of the cluster's nodes are \
+// read from the REDIS_CLUSTER_ADDRESSES environment variable.
+func TestCluster(t *testing.T) {
+	if testing.Short() || os.Getenv("REDIS_CLUSTER_ADDRESSES") == "" {
+		t.Skip("skipping TestCluster, an integration test that requires REDIS_CLUSTER_ADDRESSES")
+	}
+
+	clusterService := New(Options{
+		ClusterAddresses: strings.Split(os.Getenv("REDIS_CLUSTER_ADDRESSES"), ","),
+	})
+	defer clusterService.Close()
+
+	userID := "clusterUserID"
+	userSessions := []*user.Session{
+		{ID: "clusterSessionID1", UserID: userID, JSON: "json1", ExpiresAt: time.Now().Add(1 * time.Hour)},
+		{ID: "clusterSessionID2", UserID: userID, JSON: "json2", ExpiresAt: time.Now().Add(1 * time.Hour)},
+	}
+	for _, userSession := range userSessions {
+		if err := clusterService.SaveUserSession(userSession); err != nil {
+			t.Fatalf("Err saving user session: %v", err)
+		}
+	}
+
+	a, e := clusterService.FetchValidUserSession(userSessions[0].ID)
+	if e != nil || a == nil || a.JSON != userSessions[0].JSON {
+		t.Errorf("test failed; expected session: %v, received session: %v, received err: %v", userSessions[0], a, e)
+	}
+	if e := clusterService.Ping(context.Background()); e != nil {
+		t.Errorf("test failed; expected err to be nil when pinging the cluster, received: %v", e)
+	}
+	if stats := clusterService.PoolStats(); stats.ActiveCount == 0 {
+		t.Errorf("test failed; expected active connections in the cluster pools, received stats: %v", stats)
+	}
+	if e := clusterService.SaveUserSessionIfVersion(userSessions[0], userSessions[0].Version-1); e != ErrVersionConflict {
+		t.Errorf("test failed; expected err: %v, received: %v", ErrVersionConflict, e)
+	}
+	if e := clusterService.SaveUserSessionIfVersion(userSessions[0], userSessions[0].Version); e != nil {
+		t.Errorf("test failed; expected err to be nil when saving with the current version, received: %v", e)
+	}
+
+	prefixedClusterService := New(Options{
+		ClusterAddresses: strings.Split(os.Getenv("REDIS_CLUSTER_ADDRESSES"), ","),
+		KeyPrefix:        "clusterScan:",
+	})
+	defer prefixedClusterService.Close()
+	for _, userSession := range userSessions {
+		if err := prefixedClusterService.SaveUserSession(userSession); err != nil {
+			t.Fatalf("Err saving user session: %v", err)
+		}
+	}
+	defer prefixedClusterService.DeleteAllUserSessions(userID)
+
+	scanned := make(map[string]bool)
+	var cursor string
+	for {
+		scannedUserSessions, next, err := prefixedClusterService.Scan(cursor, 1)
+		if err != nil {
+			t.Fatalf("test failed; expected err to be nil when scanning, received: %v", err)
+		}
+		for _, userSession := range scannedUserSessions {
+			scanned[userSession.ID] = true
+		}
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(scanned) != len(userSessions) {
+		t.Errorf("test failed; expected %d scanned sessions, received: %v", len(userSessions), scanned)
+	}
+
+	if e := clusterService.DeleteUserSession(userSessions[0].ID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting user session, received: %v", e)
+	}
+
+	list, e := clusterService.ListUserSessions(userID)
+	if e != nil || len(list) != 1 || list[0].ID != userSessions[1].ID {
+		t.Errorf("test failed; expected sessions: [%v], received sessions: %v, received err: %v", userSessions[1], list, e)
+	}
+
+	if evicted, e := clusterService.SaveUserSessionWithLimit(context.Background(), userSessions[0], 1, true); e != nil || !reflect.DeepEqual(evicted, []string{userSessions[1].ID}) {
+		t.Errorf("test failed; expected evicted: %v, received evicted: %v, received err: %v", []string{userSessions[1].ID}, evicted, e)
+	}
+
+	regeneratedUserSession := *userSessions[0]
+	regeneratedUserSession.ID = "clusterSessionID3"
+	if e := clusterService.RegenerateUserSession(context.Background(), userSessions[0].ID, &regeneratedUserSession); e != nil {
+		t.Errorf("test failed; expected err to be nil when regenerating user session, received: %v", e)
+	}
+	list, e = clusterService.ListUserSessions(userID)
+	if e != nil || len(list) != 1 || list[0].ID != regeneratedUserSession.ID {
+		t.Errorf("test failed; expected sessions: [%v], received sessions: %v, received err: %v", regeneratedUserSession, list, e)
+	}
+
+	if e := clusterService.DeleteAllUserSessions(userID); e != nil {
+		t.Errorf("test failed; expected err to be nil when deleting all user sessions, received: %v", e)
+	}
+	if a, e := clusterService.FetchValidUserSession(userSessions[1].ID); a != nil || e != nil {
+		t.Errorf("test failed; expected session to be deleted, received session: %v, received err: %v", a, e)
+	}
+}
@@ -1,6 +1,9 @@
 package store
 
 import (
+	"context"
+	"time"
+
 	"github.com/adam-hanna/sessions/user"
 )
 
@@ -10,3 +13,94 @@ type ServiceInterface interface {
 	DeleteUserSession(sessionID string) error
 	FetchValidUserSession(sessionID string) (*user.Session, error)
 }
+
+// UserServiceInterface is optionally implemented by stores that can relate sessions to the users they belong to
+type UserServiceInterface interface {
+	ListUserSessions(userID string) ([]*user.Session, error)
+	DeleteAllUserSessions(userID string) error
+}
+
+// ContextServiceInterface is optionally implemented by stores whose operations can be cancelled. When implemented, \
+// the session service passes the request's context to the store.
+type ContextServiceInterface interface {
+	SaveUserSessionContext(ctx context.Context, userSession *user.Session) error
+	DeleteUserSessionContext(ctx context.Context, sessionID string) error
+	FetchValidUserSessionContext(ctx context.Context, sessionID string) (*user.Session, error)
+}
+
+// ScanServiceInterface is optionally implemented by stores that can enumerate their sessions, e.g. to copy them to \
+// another store
+type ScanServiceInterface interface {
+	// Scan returns a batch of valid sessions, starting at cursor, and the cursor of the next batch. Pass an empty \
+	// cursor to start a scan; the scan is complete when the returned cursor is empty. count is a hint of the \
+	// batch size: a batch may hold more or fewer sessions, or none at all, before the scan completes. Sessions \
+	// saved or deleted during a scan may or may not be returned, and a session may be returned more than once.
+	Scan(cursor string, count int) ([]*user.Session, string, error)
+}
+
+// VersionedServiceInterface is optionally implemented by stores that version sessions, to keep concurrent requests \
+// from overwriting each other's changes. Such stores increment a session's Version every time it is saved, and set \
+// the new version on the saved user.Session.
+type VersionedServiceInterface interface {
+	// SaveUserSessionIfVersion saves a session only if the stored session's version is version, i.e. the session \
+	// was not saved since it was fetched with that version. Otherwise, ErrVersionConflict is returned. A session \
+	// that does not exist has version 0.
+	SaveUserSessionIfVersion(userSession *user.Session, version int64) error
+}
+
+// FieldServiceInterface is optionally implemented by stores that persist each field of a session's Data \
+// separately, so independent parts of an app can change their own fields without rewriting, or overwriting, the \
+// rest of the session. Setting or deleting a field increments the session's version, if the store versions sessions.
+type FieldServiceInterface interface {
+	// SetUserSessionField sets a named field of a stored session's Data. If the session does not exist, \
+	// ErrSessionNotFound is returned.
+	SetUserSessionField(sessionID string, name string, value string) error
+	// DeleteUserSessionField deletes a named field from a stored session's Data. If the session does not exist, \
+	// ErrSessionNotFound is returned.
+	DeleteUserSessionField(sessionID string, name string) error
+}
+
+// LimitServiceInterface is optionally implemented by stores that can cap the number of valid sessions a user \
+// holds, e.g. to enforce per-seat licensing. Such stores rank each user's sessions, by when they were saved with a \
+// limit or last touched, and evict the lowest ranked sessions first. Sessions that were never saved with a limit \
+// rank lowest.
+type LimitServiceInterface interface {
+	// SaveUserSessionWithLimit saves a new session if its user holds fewer than limit other valid sessions. \
+	// Otherwise, if evict is set, the user's lowest ranked sessions are deleted to make room, and their ids are \
+	// returned; if not, ErrSessionLimitReached is returned and nothing is saved. Counting and ranking the user's \
+	// sessions happens atomically, so concurrent logins can't exceed the limit.
+	SaveUserSessionWithLimit(ctx context.Context, userSession *user.Session, limit int, evict bool) ([]string, error)
+	// TouchUserSession ranks a session as its user's most recently used session, so it is evicted last. Sessions \
+	// that were never saved with a limit are left alone.
+	TouchUserSession(ctx context.Context, userSession *user.Session) error
+}
+
+// RegenerateServiceInterface is optionally implemented by stores that can move a session to a new id, e.g. to \
+// prevent session fixation when its user logs in or their privileges change
+type RegenerateServiceInterface interface {
+	// RegenerateUserSession saves userSession, which carries the new id, and deletes the session with sessionID, \
+	// which must belong to the same user, so the old id can't be used anymore once the new one is valid. If the \
+	// store ranks sessions, see LimitServiceInterface, the new id takes over the old id's rank.
+	RegenerateUserSession(ctx context.Context, sessionID string, userSession *user.Session) error
+}
+
+// HealthServiceInterface is optionally implemented by stores that can check whether they are able to serve \
+// requests, e.g. for readiness probes
+type HealthServiceInterface interface {
+	// Ping returns an error if the store can't be reached, e.g. because the redis server is down
+	Ping(ctx context.Context) error
+}
+
+// LockServiceInterface is optionally implemented by stores that can lock sessions, so only one request per session \
+// proceeds through a workflow, like a checkout, at a time. Locks expire, so a crashed holder can't keep a session \
+// locked forever.
+type LockServiceInterface interface {
+	// LockUserSession acquires the lock of a session for ttl, and returns a fencing token that identifies the \
+	// holder. Tokens increase with every lock acquired from the store, so a resource that records the highest \
+	// token it has seen can reject the writes of a holder whose lock expired while it was paused. If the session \
+	// is already locked, ErrLocked is returned; it doesn't wait for the lock to be released.
+	LockUserSession(ctx context.Context, sessionID string, ttl time.Duration) (int64, error)
+	// UnlockUserSession releases the lock of a session if it is still held with token. If the lock has expired, \
+	// or was acquired by someone else in the meantime, ErrLockNotHeld is returned.
+	UnlockUserSession(ctx context.Context, sessionID string, token int64) error
+}
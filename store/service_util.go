@@ -1,5 +1,31 @@
 package store
 
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// sentinelRoleCheckInterval is how long a connection may sit idle in sentinel mode before its role is \
+	// checked on borrow
+	sentinelRoleCheckInterval = 1 * time.Second
+	// clusterMaxAttempts is the number of times a cluster command is attempted while following redirections
+	clusterMaxAttempts = 3
+	// clusterTryAgainDelay is the delay before retrying a cluster command that failed with TRYAGAIN
+	clusterTryAgainDelay = 100 * time.Millisecond
+	// dataFieldPrefix is prepended to the names of a session's Data fields in its hash, so they can't collide with \
+	// the session's own fields
+	dataFieldPrefix = "Data:"
+	// lockTokensKey is the un-prefixed key of the counter that fencing tokens of session locks are drawn from
+	lockTokensKey = "sessionLockTokens:counter"
+)
+
 // setDefaultOptions sets default values for nil fields
 // note @adam-hanna: this utility function should be improved. The fields and types of the options struct \
 // 			         should not be hardcoded!
@@ -22,3 +48,292 @@ func setDefaultOptions(options *Options) {
 
 	return
 }
+
+// newPool returns a redis pool configured by options that dials connections with dial
+func newPool(options Options, dial func(ctx context.Context) (redis.Conn, error)) *redis.Pool {
+	pool := &redis.Pool{
+		MaxActive:   options.MaxActiveConnections,
+		MaxIdle:     options.MaxIdleConnections,
+		IdleTimeout: options.IdleTimeoutDuration,
+		DialContext: dial,
+	}
+	if options.HealthCheckIntervalDuration > 0 {
+		pool.TestOnBorrow = testOnBorrow(options.HealthCheckIntervalDuration, ping)
+	}
+
+	return pool
+}
+
+// connectionDialOptions returns the options that set the timeouts and TLS settings of new connections
+func connectionDialOptions(options Options) []redis.DialOption {
+	var dialOptions []redis.DialOption
+	if options.ConnectTimeoutDuration > 0 {
+		dialOptions = append(dialOptions, redis.DialConnectTimeout(options.ConnectTimeoutDuration))
+	}
+	if options.ReadTimeoutDuration > 0 {
+		dialOptions = append(dialOptions, redis.DialReadTimeout(options.ReadTimeoutDuration))
+	}
+	if options.WriteTimeoutDuration > 0 {
+		dialOptions = append(dialOptions, redis.DialWriteTimeout(options.WriteTimeoutDuration))
+	}
+	if options.UseTLS || options.TLSConfig != nil {
+		dialOptions = append(dialOptions, redis.DialUseTLS(true))
+	}
+	if options.TLSConfig != nil {
+		dialOptions = append(dialOptions, redis.DialTLSConfig(options.TLSConfig))
+	}
+
+	return dialOptions
+}
+
+// redisDialOptions returns the options that new connections to the redis server, or to the cluster nodes, are \
+// dialed with
+func redisDialOptions(options Options) []redis.DialOption {
+	dialOptions := connectionDialOptions(options)
+	if options.Username != "" {
+		dialOptions = append(dialOptions, redis.DialUsername(options.Username))
+	}
+	if options.Password != "" {
+		dialOptions = append(dialOptions, redis.DialPassword(options.Password))
+	}
+	if options.Database != 0 {
+		dialOptions = append(dialOptions, redis.DialDatabase(options.Database))
+	}
+
+	return dialOptions
+}
+
+// testOnBorrow returns a TestOnBorrow function for a pool that checks connections which sat idle for longer than \
+// interval with check
+func testOnBorrow(interval time.Duration, check func(c redis.Conn) error) func(c redis.Conn, t time.Time) error {
+	return func(c redis.Conn, t time.Time) error {
+		if time.Since(t) < interval {
+			return nil
+		}
+		return check(c)
+	}
+}
+
+// ping returns an error if c does not answer a PING
+func ping(c redis.Conn) error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// dialSentinelMaster asks the sentinels, in order, for the address of the master and dials it. The sentinels are \
+// dialed with sentinelDialOptions and the master with masterDialOptions
+func dialSentinelMaster(ctx context.Context, sentinelAddresses []string, masterName string, sentinelDialOptions []redis.DialOption, masterDialOptions []redis.DialOption) (redis.Conn, error) {
+	address, err := resolveSentinelMaster(ctx, sentinelAddresses, masterName, sentinelDialOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := redis.DialContext(ctx, "tcp", address, masterDialOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	// note: the sentinels may not have finished reconfiguring the servers after a failover
+	if err := checkMasterRole(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// resolveSentinelMaster returns the address of the master reported by the first sentinel that knows it
+func resolveSentinelMaster(ctx context.Context, sentinelAddresses []string, masterName string, dialOptions []redis.DialOption) (string, error) {
+	for _, sentinelAddress := range sentinelAddresses {
+		c, err := redis.DialContext(ctx, "tcp", sentinelAddress, dialOptions...)
+		if err != nil {
+			continue
+		}
+
+		reply, err := redis.Strings(redis.DoContext(c, ctx, "SENTINEL", "get-master-addr-by-name", masterName))
+		c.Close()
+		if err != nil || len(reply) != 2 {
+			continue
+		}
+
+		return net.JoinHostPort(reply[0], reply[1]), nil
+	}
+
+	return "", ErrNoSentinelMaster
+}
+
+// checkMasterRole returns ErrNotMaster if c is not connected to a master
+func checkMasterRole(c redis.Conn) error {
+	reply, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+
+	var role string
+	if _, err := redis.Scan(reply, &role); err != nil {
+		return err
+	}
+	if role != "master" {
+		return ErrNotMaster
+	}
+
+	return nil
+}
+
+// doContext is like redis.DoContext, but falls back to Do for connections that don't support contexts, like \
+// cluster connections
+func doContext(ctx context.Context, c redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+	if _, ok := c.(redis.ConnWithContext); ok {
+		return redis.DoContext(c, ctx, cmd, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Do(cmd, args...)
+}
+
+// scriptDoContext is like script.DoContext, but falls back to Do for connections that don't support contexts
+func scriptDoContext(ctx context.Context, script *redis.Script, c redis.Conn, keysAndArgs ...interface{}) (interface{}, error) {
+	if _, ok := c.(redis.ConnWithContext); ok {
+		return script.DoContext(ctx, c, keysAndArgs...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return script.Do(c, keysAndArgs...)
+}
+
+// receiveContext is like redis.ReceiveContext, but falls back to Receive for connections that don't support \
+// contexts
+func receiveContext(ctx context.Context, c redis.Conn) (interface{}, error) {
+	if _, ok := c.(redis.ConnWithContext); ok {
+		return redis.ReceiveContext(c, ctx)
+	}
+	return c.Receive()
+}
+
+// fetchCommand returns the command and arguments that read the session hash stored under key
+func fetchCommand(key string) (string, []interface{}) {
+	return "HGETALL", []interface{}{key}
+}
+
+// dataField returns the hash field that holds a named field of a session's Data
+func dataField(name string) string {
+	return dataFieldPrefix + name
+}
+
+// CreatedAtSeconds returns a session's creation time in unix seconds, or 0 if it is unknown. Stores that persist \
+// the creation time as a number, like this one, save it with CreatedAtSeconds and read it back with CreatedAtTime
+func CreatedAtSeconds(createdAt time.Time) int64 {
+	if createdAt.IsZero() {
+		return 0
+	}
+
+	return createdAt.Unix()
+}
+
+// CreatedAtTime returns the creation time stored as CreatedAtSeconds, or the zero time if it is unknown
+func CreatedAtTime(seconds int64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0)
+}
+
+// dataArgs returns the hash fields and values of a session's Data, sorted by name
+func dataArgs(data map[string]string) []interface{} {
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := make([]interface{}, 0, 2*len(data))
+	for _, name := range names {
+		args = append(args, dataField(name), data[name])
+	}
+
+	return args
+}
+
+// legacySessionKey returns the un-prefixed key of a session's hash
+func legacySessionKey(sessionID string) string {
+	return sessionID
+}
+
+// userSessionsKey returns the un-prefixed key of the sorted set that indexes a user's session ids by expiry
+func userSessionsKey(userID string) string {
+	return "userSessions:" + userID
+}
+
+// userSessionsRankKey returns the un-prefixed key of the sorted set that ranks a user's session ids for eviction. \
+// It embeds the hash tag of the user's index key, so redis cluster stores both keys in the same slot and one script \
+// can update them. If the index key has a hash tag of its own, which other users' index keys may share, e.g. from \
+// a hash-tagged key prefix, the whole index key is embedded too, so every user keeps their own ranks.
+func userSessionsRankKey(indexKey string) string {
+	tag := hashTag(indexKey)
+	if tag == "{"+indexKey+"}" {
+		return "userSessionsRank:" + tag
+	}
+
+	return "userSessionsRank:" + tag + ":" + indexKey
+}
+
+// hashTag returns the hash tag redis cluster hashes key by to pick its slot: key's own hash tag, if it has one, or \
+// key wrapped in braces
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start : start+end+2]
+		}
+	}
+
+	return "{" + key + "}"
+}
+
+// shadowKey returns the un-prefixed shadow key of a session, see Options.ExpiryShadowKeys
+func shadowKey(sessionID string) string {
+	return "expiryShadow:" + sessionID
+}
+
+// expiredEventsPattern returns the channel redis publishes the names of expired keys of a db on
+func expiredEventsPattern(database int) string {
+	return "__keyevent@" + strconv.Itoa(database) + "__:expired"
+}
+
+// lockKey returns the un-prefixed key of a session's lock
+func lockKey(sessionID string) string {
+	return "sessionLock:" + sessionID
+}
+
+// notificationFlags returns the notify-keyspace-events flags that add keyevent notifications of expired keys to \
+// flags. "A" is an alias for all classes of keys, including "x", the expired keys.
+func notificationFlags(flags string) string {
+	if !strings.Contains(flags, "E") {
+		flags += "E"
+	}
+	if !strings.ContainsAny(flags, "xA") {
+		flags += "x"
+	}
+
+	return flags
+}
+
+// patternReplacer escapes the glob special characters of a SCAN MATCH pattern
+var patternReplacer = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// escapePattern escapes s, so it matches itself in a SCAN MATCH pattern
+func escapePattern(s string) string {
+	return patternReplacer.Replace(s)
+}
+
+// isMalformed returns whether err means a key doesn't hold a well-formed session, e.g. because it is not a hash
+func isMalformed(err error) bool {
+	if err == ErrRetrievingSession {
+		return true
+	}
+
+	_, ok := err.(redis.Error)
+	return ok
+}
@@ -27,3 +27,129 @@ func TestSetDefaultOptions(t *testing.T) {
 		}
 	}
 }
+
+// TestNotificationFlags tests the notificationFlags function
+func TestNotificationFlags(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"", "Ex"},
+		{"Kg", "KgEx"},
+		{"Ex", "Ex"},
+		{"AE", "AE"},
+		{"KEA", "KEA"},
+	}
+
+	for idx, tt := range tests {
+		a := notificationFlags(tt.input)
+
+		if a != tt.expected {
+			t.Errorf("test #%d failed; input: %s, expected: %s, received: %s", idx+1, tt.input, tt.expected, a)
+		}
+	}
+}
+
+// TestEscapePattern tests the escapePattern function
+func TestEscapePattern(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"sessions:", "sessions:"},
+		{`a*b?c[d]e\f`, `a\*b\?c\[d\]e\\f`},
+	}
+
+	for idx, tt := range tests {
+		a := escapePattern(tt.input)
+
+		if a != tt.expected {
+			t.Errorf("test #%d failed; input: %s, expected: %s, received: %s", idx+1, tt.input, tt.expected, a)
+		}
+	}
+}
+
+// TestDataArgs tests the dataArgs function
+func TestDataArgs(t *testing.T) {
+	var tests = []struct {
+		input    map[string]string
+		expected []interface{}
+	}{
+		{nil, []interface{}{}},
+		{map[string]string{"cart": "1"}, []interface{}{"Data:cart", "1"}},
+		{map[string]string{"theme": "dark", "cart": "1"}, []interface{}{"Data:cart", "1", "Data:theme", "dark"}},
+	}
+
+	for idx, tt := range tests {
+		a := dataArgs(tt.input)
+
+		if !reflect.DeepEqual(a, tt.expected) {
+			t.Errorf("test #%d failed; input: %v, expected: %v, received: %v", idx+1, tt.input, tt.expected, a)
+		}
+	}
+}
+
+// TestHashTag tests the hashTag function
+func TestHashTag(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"userSessions:userID", "{userSessions:userID}"},
+		{"tenant:{a}:userSessions:userID", "{a}"},
+		{"userSessions:{}:userID", "{userSessions:{}:userID}"},
+		{"userSessions:{userID", "{userSessions:{userID}"},
+	}
+
+	for idx, tt := range tests {
+		a := hashTag(tt.input)
+
+		if a != tt.expected {
+			t.Errorf("test #%d failed; input: %s, expected: %s, received: %s", idx+1, tt.input, tt.expected, a)
+		}
+	}
+}
+
+// TestUserSessionsRankKey tests that every user has their own rank key, in the slot of their index key
+func TestUserSessionsRankKey(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"userSessions:userID", "userSessionsRank:{userSessions:userID}"},
+		{"{sessions}:userSessions:userID", "userSessionsRank:{sessions}:{sessions}:userSessions:userID"},
+		{"{sessions}:userSessions:otherUserID", "userSessionsRank:{sessions}:{sessions}:userSessions:otherUserID"},
+		{"userSessions:{a}", "userSessionsRank:{a}:userSessions:{a}"},
+		{"userSessions:{a}:{b}", "userSessionsRank:{a}:userSessions:{a}:{b}"},
+	}
+
+	for idx, tt := range tests {
+		a := userSessionsRankKey(tt.input)
+
+		if a != tt.expected || hashTag(a) != hashTag(tt.input) {
+			t.Errorf("test #%d failed; input: %s, expected: %s, received: %s", idx+1, tt.input, tt.expected, a)
+		}
+	}
+}
+
+// TestCreatedAtSeconds tests that creation times survive the round trip through CreatedAtSeconds and CreatedAtTime
+func TestCreatedAtSeconds(t *testing.T) {
+	var tests = []struct {
+		input           time.Time
+		expectedSeconds int64
+		expected        time.Time
+	}{
+		{time.Time{}, 0, time.Time{}},
+		{time.Unix(1500000000, 0), 1500000000, time.Unix(1500000000, 0)},
+		{time.Unix(1500000000, 999), 1500000000, time.Unix(1500000000, 0)},
+	}
+
+	for idx, tt := range tests {
+		seconds := CreatedAtSeconds(tt.input)
+		a := CreatedAtTime(seconds)
+
+		if seconds != tt.expectedSeconds || !a.Equal(tt.expected) {
+			t.Errorf("test #%d failed; input: %v, expected seconds: %d, received: %d, expected time: %v, received: %v", idx+1, tt.input, tt.expectedSeconds, seconds, tt.expected, a)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+)
+
+// Schema documents the table used by the store. CreateSchema creates it, substituting the configured table name:
+//
+// 	CREATE TABLE IF NOT EXISTS sessions (
+// 		id         VARCHAR(64)  PRIMARY KEY,
+// 		user_id    VARCHAR(255) NOT NULL,
+// 		json       TEXT         NOT NULL,
+// 		expires_at BIGINT       NOT NULL,
+// 		created_at       BIGINT  NOT NULL DEFAULT 0,
+// 		duration_seconds BIGINT  NOT NULL DEFAULT 0,
+// 		browser_session  BOOLEAN NOT NULL DEFAULT FALSE
+// 	);
+// 	CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+// 	CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
+//
+// expires_at holds the session expiry in unix seconds, the same resolution used by the redis store. created_at holds \
+// the session's creation time in unix seconds, or 0 if it is unknown, and duration_seconds the session's own idle \
+// timeout, or 0 if it has none.
+
+// Dialect selects the SQL flavor spoken by the database
+type Dialect int
+
+const (
+	// DialectSQLite uses "?" query placeholders
+	DialectSQLite Dialect = iota
+	// DialectPostgres uses "$1", "$2", ... query placeholders
+	DialectPostgres
+)
+
+const (
+	// DefaultTableName is the default name of the sessions table
+	DefaultTableName = "sessions"
+	// DefaultCleanupIntervalDuration sets the default duration between DeleteExpired runs
+	DefaultCleanupIntervalDuration = 10 * time.Minute
+)
+
+var (
+	// ErrInvalidTableName is thrown when the table name is not a plain sql identifier
+	ErrInvalidTableName = errors.New("invalid table name")
+
+	tableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Service is a session store backed by a database/sql db
+type Service struct {
+	// DB is the *sql.DB the sessions are stored in. The caller is responsible for importing a driver and \
+	// closing the DB
+	DB *sql.DB
+
+	options Options
+	queries queries
+	done    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// Options defines the behavior of the session store
+type Options struct {
+	Dialect   Dialect
+	TableName string
+	// CleanupIntervalDuration is the duration between background DeleteExpired runs. SQL has no native ttl, so \
+	// expired rows remain in the table until they are cleaned up
+	CleanupIntervalDuration time.Duration
+	// CleanupErrorHandler, if set, is called with errors returned by background DeleteExpired runs
+	CleanupErrorHandler func(error)
+}
+
+// queries holds the dialect specific sql statements of the store
+type queries struct {
+	createSchema  []string
+	addColumns    []addColumn
+	save          string
+	delete        string
+	fetch         string
+	deleteExpired string
+	listUser      string
+	deleteUser    string
+	scan          string
+}
+
+// addColumn holds the statements that add a column to tables created before the column was introduced
+type addColumn struct {
+	check string
+	add   string
+}
+
+// sessionColumns are the columns of a session's attributes that are selected after its expires_at column
+const sessionColumns = "created_at, duration_seconds, browser_session"
+
+// New returns a new session store backed by db and starts the periodic cleanup of expired sessions.
+// Close should be called to stop the cleanup once the store is no longer needed.
+func New(db *sql.DB, options Options) (*Service, error) {
+	setDefaultOptions(&options)
+	if !tableNameRegexp.MatchString(options.TableName) {
+		return nil, ErrInvalidTableName
+	}
+
+	s := &Service{
+		DB:      db,
+		options: options,
+		queries: buildQueries(options.Dialect, options.TableName),
+		done:    make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.cleanup(options.CleanupIntervalDuration)
+
+	return s, nil
+}
+
+// CreateSchema creates the sessions table and its indexes if they do not exist, yet. If the table was created by \
+// an earlier version of the store, the columns added since are added to it.
+func (s *Service) CreateSchema() error {
+	for _, query := range s.queries.createSchema {
+		if _, err := s.DB.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	// note: sqlite has no ADD COLUMN IF NOT EXISTS, so check whether each column is missing, first
+	for _, column := range s.queries.addColumns {
+		if _, err := s.DB.Exec(column.check); err == nil {
+			continue
+		}
+		if _, err := s.DB.Exec(column.add); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveUserSession saves a user session in the store. Sessions with Data are not supported: store.ErrNotSupported is \
+// returned, since the table has no column for it
+func (s *Service) SaveUserSession(userSession *user.Session) error {
+	return s.SaveUserSessionContext(context.Background(), userSession)
+}
+
+// SaveUserSessionContext is like SaveUserSession, but aborts the query when ctx is done
+func (s *Service) SaveUserSessionContext(ctx context.Context, userSession *user.Session) error {
+	// note: the table has no column for the session's Data, so refuse to silently drop it
+	if len(userSession.Data) > 0 {
+		return store.ErrNotSupported
+	}
+
+	_, err := s.DB.ExecContext(ctx, s.queries.save, userSession.ID, userSession.UserID, userSession.JSON, userSession.ExpiresAt.Unix(), store.CreatedAtSeconds(userSession.CreatedAt), int64(userSession.Duration/time.Second), userSession.BrowserSession)
+	return err
+}
+
+// RegenerateUserSession saves userSession, which carries a new id, and deletes the session with sessionID, in one \
+// transaction. Like SaveUserSession, sessions with Data are not supported. See store.RegenerateServiceInterface.
+func (s *Service) RegenerateUserSession(ctx context.Context, sessionID string, userSession *user.Session) error {
+	if len(userSession.Data) > 0 {
+		return store.ErrNotSupported
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.queries.save, userSession.ID, userSession.UserID, userSession.JSON, userSession.ExpiresAt.Unix(), store.CreatedAtSeconds(userSession.CreatedAt), int64(userSession.Duration/time.Second), userSession.BrowserSession); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.queries.delete, sessionID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUserSession deletes a user session from the store
+func (s *Service) DeleteUserSession(sessionID string) error {
+	return s.DeleteUserSessionContext(context.Background(), sessionID)
+}
+
+// DeleteUserSessionContext is like DeleteUserSession, but aborts the query when ctx is done
+func (s *Service) DeleteUserSessionContext(ctx context.Context, sessionID string) error {
+	_, err := s.DB.ExecContext(ctx, s.queries.delete, sessionID)
+	return err
+}
+
+// FetchValidUserSession returns a valid user session or an err if the session has expired or does not exist. \
+// If a valid session does not exist, this function should return a nil pointer
+func (s *Service) FetchValidUserSession(sessionID string) (*user.Session, error) {
+	return s.FetchValidUserSessionContext(context.Background(), sessionID)
+}
+
+// FetchValidUserSessionContext is like FetchValidUserSession, but aborts the query when ctx is done
+func (s *Service) FetchValidUserSessionContext(ctx context.Context, sessionID string) (*user.Session, error) {
+	var userID string
+	var json string
+	var expiresAtSeconds int64
+	var createdAtSeconds int64
+	var durationSeconds int64
+	var browserSession bool
+
+	// note: sql has no native ttl, so expired rows must be filtered out here
+	row := s.DB.QueryRowContext(ctx, s.queries.fetch, sessionID, time.Now().Unix())
+	if err := row.Scan(&userID, &json, &expiresAtSeconds, &createdAtSeconds, &durationSeconds, &browserSession); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user.Session{
+		ID:             sessionID,
+		UserID:         userID,
+		JSON:           json,
+		ExpiresAt:      time.Unix(expiresAtSeconds, 0),
+		CreatedAt:      store.CreatedAtTime(createdAtSeconds),
+		Duration:       time.Duration(durationSeconds) * time.Second,
+		BrowserSession: browserSession,
+	}, nil
+}
+
+// ListUserSessions returns all valid sessions of a user
+func (s *Service) ListUserSessions(userID string) ([]*user.Session, error) {
+	rows, err := s.DB.Query(s.queries.listUser, userID, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userSessions := []*user.Session{}
+	for rows.Next() {
+		var sessionID string
+		var json string
+		var expiresAtSeconds int64
+		var createdAtSeconds int64
+		var durationSeconds int64
+		var browserSession bool
+		if err := rows.Scan(&sessionID, &json, &expiresAtSeconds, &createdAtSeconds, &durationSeconds, &browserSession); err != nil {
+			return nil, err
+		}
+
+		userSessions = append(userSessions, &user.Session{
+			ID:             sessionID,
+			UserID:         userID,
+			JSON:           json,
+			ExpiresAt:      time.Unix(expiresAtSeconds, 0),
+			CreatedAt:      store.CreatedAtTime(createdAtSeconds),
+			Duration:       time.Duration(durationSeconds) * time.Second,
+			BrowserSession: browserSession,
+		})
+	}
+
+	return userSessions, rows.Err()
+}
+
+// DeleteAllUserSessions deletes all sessions of a user
+func (s *Service) DeleteAllUserSessions(userID string) error {
+	_, err := s.DB.Exec(s.queries.deleteUser, userID)
+	return err
+}
+
+// Scan returns up to count valid sessions, in session id order, starting after the session id cursor, and the \
+// cursor of the next batch. See store.ScanServiceInterface.
+func (s *Service) Scan(cursor string, count int) ([]*user.Session, string, error) {
+	if count <= 0 {
+		count = store.DefaultScanCount
+	}
+
+	rows, err := s.DB.Query(s.queries.scan, cursor, time.Now().Unix(), count)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	userSessions := []*user.Session{}
+	for rows.Next() {
+		var sessionID string
+		var userID string
+		var json string
+		var expiresAtSeconds int64
+		var createdAtSeconds int64
+		var durationSeconds int64
+		var browserSession bool
+		if err := rows.Scan(&sessionID, &userID, &json, &expiresAtSeconds, &createdAtSeconds, &durationSeconds, &browserSession); err != nil {
+			return nil, "", err
+		}
+
+		userSessions = append(userSessions, &user.Session{
+			ID:             sessionID,
+			UserID:         userID,
+			JSON:           json,
+			ExpiresAt:      time.Unix(expiresAtSeconds, 0),
+			CreatedAt:      store.CreatedAtTime(createdAtSeconds),
+			Duration:       time.Duration(durationSeconds) * time.Second,
+			BrowserSession: browserSession,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	// note: a short batch means there are no more sessions
+	if len(userSessions) < count {
+		return userSessions, "", nil
+	}
+
+	return userSessions, userSessions[len(userSessions)-1].ID, nil
+}
+
+// DeleteExpired deletes all expired sessions from the store and returns the number of deleted sessions
+func (s *Service) DeleteExpired() (int64, error) {
+	result, err := s.DB.Exec(s.queries.deleteExpired, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Ping returns an error if the db can't be reached
+func (s *Service) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+// Close stops the periodic cleanup of expired sessions. It does not close the DB.
+func (s *Service) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+
+	return nil
+}
+
+// cleanup periodically deletes expired sessions until the store is closed
+func (s *Service) cleanup(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.DeleteExpired(); err != nil && s.options.CleanupErrorHandler != nil {
+				s.options.CleanupErrorHandler(err)
+			}
+		}
+	}
+}
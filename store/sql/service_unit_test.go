@@ -0,0 +1,374 @@
+// +build unit
+
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adam-hanna/sessions/store"
+	"github.com/adam-hanna/sessions/user"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// note: this will fail to compile if the sql store does not implement the store interfaces
+var (
+	_ store.ServiceInterface           = (*Service)(nil)
+	_ store.HealthServiceInterface     = (*Service)(nil)
+	_ store.RegenerateServiceInterface = (*Service)(nil)
+)
+
+var (
+	validUserSession = &user.Session{
+		ID:        "validSessionID",
+		UserID:    "validUserID",
+		JSON:      "validJSON",
+		ExpiresAt: time.Unix(time.Now().Add(1*time.Hour).Unix(), 0),
+	}
+	expiredUserSession = &user.Session{
+		ID:        "expiredSessionID",
+		UserID:    "validUserID",
+		JSON:      "expiredJSON",
+		ExpiresAt: time.Unix(time.Now().Add(-100*time.Hour).Unix(), 0),
+	}
+
+	// note: sqlite binds postgres' "$1", "$2", ... placeholders in order, too, so both dialects' queries can be \
+	// run against it
+	dialects = []Dialect{DialectSQLite, DialectPostgres}
+)
+
+// newTestService returns a store of dialect backed by a new in-memory sqlite db, with the schema created, and a \
+// func to clean it up
+func newTestService(t *testing.T, dialect Dialect) (*Service, func()) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	// note: every connection to ":memory:" opens a new, empty db
+	db.SetMaxOpenConns(1)
+
+	s, err := New(db, Options{Dialect: dialect})
+	if err != nil {
+		db.Close()
+		t.Fatalf("could not create store: %v", err)
+	}
+	if err := s.CreateSchema(); err != nil {
+		s.Close()
+		db.Close()
+		t.Fatalf("could not create schema: %v", err)
+	}
+
+	return s, func() {
+		s.Close()
+		db.Close()
+	}
+}
+
+// countRows returns the number of rows in the sessions table, including expired ones
+func countRows(t *testing.T, s *Service) int {
+	var n int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM " + s.options.TableName).Scan(&n); err != nil {
+		t.Fatalf("could not count rows: %v", err)
+	}
+
+	return n
+}
+
+// TestNew tests the New function
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		input       Options
+		expectedErr error
+	}{
+		{Options{}, nil},
+		{Options{TableName: "user_sessions", Dialect: DialectPostgres}, nil},
+		{Options{TableName: "sessions; DROP TABLE users"}, ErrInvalidTableName},
+	}
+
+	for idx, tt := range tests {
+		s, e := New(nil, tt.input)
+		assertErr := e == tt.expectedErr
+		assertService := (s == nil) == (tt.expectedErr != nil)
+		if s != nil {
+			s.Close()
+		}
+
+		if !assertErr || !assertService {
+			t.Errorf("test #%d failed; assert err: %t, assert service: %t, input: %v, expected err: %v, received err: %v", idx+1, assertErr, assertService, tt.input, tt.expectedErr, e)
+		}
+	}
+}
+
+// TestSaveUserSessionData tests that sessions with data are refused, rather than saved without it
+func TestSaveUserSessionData(t *testing.T) {
+	s := &Service{}
+	userSession := &user.Session{ID: "sessionID", ExpiresAt: time.Now().Add(1 * time.Hour)}
+	userSession.Set("cart", "1")
+
+	if e := s.SaveUserSession(userSession); e != store.ErrNotSupported {
+		t.Errorf("test failed; expected err: %v, received: %v", store.ErrNotSupported, e)
+	}
+}
+
+// TestCreateSchema tests that creating the schema again keeps the existing sessions
+func TestCreateSchema(t *testing.T) {
+	for idx, dialect := range dialects {
+		s, cleanup := newTestService(t, dialect)
+
+		s.SaveUserSession(validUserSession)
+		e := s.CreateSchema()
+		a, _ := s.FetchValidUserSession(validUserSession.ID)
+		cleanup()
+
+		if e != nil || a == nil {
+			t.Errorf("test #%d failed; dialect: %d, expected err to be nil and the session to exist, received err: %v, received session: %v", idx+1, dialect, e, a)
+		}
+	}
+}
+
+// TestFetchValidUserSession tests the SaveUserSession and FetchValidUserSession functions
+func TestFetchValidUserSession(t *testing.T) {
+	durationUserSession := &user.Session{
+		ID:             "durationSessionID",
+		UserID:         "validUserID",
+		JSON:           "durationJSON",
+		ExpiresAt:      validUserSession.ExpiresAt,
+		CreatedAt:      time.Unix(time.Now().Unix(), 0),
+		Duration:       30 * 24 * time.Hour,
+		BrowserSession: true,
+	}
+
+	tests := []struct {
+		input               string
+		expectedUserSession *user.Session
+	}{
+		{validUserSession.ID, validUserSession},
+		{durationUserSession.ID, durationUserSession},
+		{expiredUserSession.ID, nil},
+		{"missingSessionID", nil},
+	}
+
+	for _, dialect := range dialects {
+		s, cleanup := newTestService(t, dialect)
+
+		s.SaveUserSession(validUserSession)
+		s.SaveUserSession(durationUserSession)
+		s.SaveUserSession(expiredUserSession)
+
+		for idx, tt := range tests {
+			a, e := s.FetchValidUserSession(tt.input)
+			var assertUserSession bool
+			if a == nil {
+				assertUserSession = tt.expectedUserSession == nil
+			} else {
+				assertUserSession = tt.expectedUserSession != nil && reflect.DeepEqual(*a, *tt.expectedUserSession)
+			}
+
+			if e != nil || !assertUserSession {
+				t.Errorf("test #%d failed; dialect: %d, assert user session: %t, received err: %v, received user session: %v, expected user session: %v, input: %v\n", idx+1, dialect, assertUserSession, e, a, tt.expectedUserSession, tt.input)
+			}
+		}
+
+		// note: expired sessions stay in the table until they are cleaned up, so fetch must filter them out
+		if n := countRows(t, s); n != 3 {
+			t.Errorf("test failed; dialect: %d, expected 3 rows, received: %d", dialect, n)
+		}
+		cleanup()
+	}
+}
+
+// TestSaveUserSession tests that saving a session again updates its row
+func TestSaveUserSession(t *testing.T) {
+	for _, dialect := range dialects {
+		s, cleanup := newTestService(t, dialect)
+
+		s.SaveUserSession(expiredUserSession)
+		updatedUserSession := *expiredUserSession
+		updatedUserSession.JSON = "updatedJSON"
+		updatedUserSession.ExpiresAt = validUserSession.ExpiresAt
+		e := s.SaveUserSession(&updatedUserSession)
+
+		a, _ := s.FetchValidUserSession(updatedUserSession.ID)
+		if e != nil || a == nil || !reflect.DeepEqual(*a, updatedUserSession) || countRows(t, s) != 1 {
+			t.Errorf("test failed; dialect: %d, expected session: %v, received session: %v, received err: %v", dialect, updatedUserSession, a, e)
+		}
+		cleanup()
+	}
+}
+
+// TestDeleteUserSession tests the DeleteUserSession function
+func TestDeleteUserSession(t *testing.T) {
+	for _, dialect := range dialects {
+		s, cleanup := newTestService(t, dialect)
+
+		s.SaveUserSession(validUserSession)
+		for idx, input := range []string{validUserSession.ID, "missingSessionID"} {
+			e := s.DeleteUserSession(input)
+			a, _ := s.FetchValidUserSession(input)
+
+			if e != nil || a != nil {
+				t.Errorf("test #%d failed; dialect: %d, expected the session to be deleted, received session: %v, received err: %v, input: %s", idx+1, dialect, a, e, input)
+			}
+		}
+		cleanup()
+	}
+}
+
+// TestDeleteExpired tests the DeleteExpired function
+func TestDeleteExpired(t *testing.T) {
+	for _, dialect := range dialects {
+		s, cleanup := newTestService(t, dialect)
+
+		s.SaveUserSession(validUserSession)
+		s.SaveUserSession(expiredUserSession)
+
+		n, e := s.DeleteExpired()
+		if e != nil || n != 1 || countRows(t, s) != 1 {
+			t.Errorf("test failed; dialect: %d, expected 1 deleted session and a nil err, received: %d, err: %v", dialect, n, e)
+		}
+		if a, _ := s.FetchValidUserSession(validUserSession.ID); a == nil {
+			t.Errorf("test failed; dialect: %d, expected session %s to exist after DeleteExpired", dialect, validUserSession.ID)
+		}
+		cleanup()
+	}
+}
+
+// TestListUserSessions tests the ListUserSessions and DeleteAllUserSessions functions
+func TestListUserSessions(t *testing.T) {
+	otherUserSession := &user.Session{
+		ID:        "otherSessionID",
+		UserID:    "otherUserID",
+		JSON:      "otherJSON",
+		ExpiresAt: validUserSession.ExpiresAt,
+	}
+
+	for _, dialect := range dialects {
+		s, cleanup := newTestService(t, dialect)
+
+		s.SaveUserSession(validUserSession)
+		s.SaveUserSession(expiredUserSession)
+		s.SaveUserSession(otherUserSession)
+
+		a, e := s.ListUserSessions(validUserSession.UserID)
+		if e != nil || len(a) != 1 || !reflect.DeepEqual(*a[0], *validUserSession) {
+			t.Errorf("test failed; dialect: %d, expected sessions: [%v], received sessions: %v, received err: %v", dialect, validUserSession, a, e)
+		}
+
+		if e := s.DeleteAllUserSessions(validUserSession.UserID); e != nil {
+			t.Errorf("test failed; dialect: %d, expected err to be nil, received: %v", dialect, e)
+		}
+		a, e = s.ListUserSessions(validUserSession.UserID)
+		if e != nil || len(a) != 0 || countRows(t, s) != 1 {
+			t.Errorf("test failed; dialect: %d, expected only the other user's session to remain, received sessions: %v, received err: %v", dialect, a, e)
+		}
+		cleanup()
+	}
+}
+
+// TestScan tests that scanning returns every valid session exactly once, in batches of up to count sessions
+func TestScan(t *testing.T) {
+	var tests = []struct {
+		inputCount       int
+		expectedSessions int
+		expectedBatches  int
+	}{
+		{0, 25, 1},
+		{1, 25, 26},
+		{10, 25, 3},
+		{100, 25, 1},
+	}
+
+	for _, dialect := range dialects {
+		s, cleanup := newTestService(t, dialect)
+
+		for idx := 0; idx < 25; idx++ {
+			s.SaveUserSession(&user.Session{ID: fmt.Sprintf("sessionID%02d", idx), UserID: "userID", JSON: "json", ExpiresAt: validUserSession.ExpiresAt})
+		}
+		s.SaveUserSession(expiredUserSession)
+
+		for idx, tt := range tests {
+			scanned := make(map[string]bool)
+			var cursor string
+			var batches int
+			for {
+				userSessions, next, err := s.Scan(cursor, tt.inputCount)
+				if err != nil {
+					t.Fatalf("test #%d failed; dialect: %d, expected err to be nil, received: %v", idx+1, dialect, err)
+				}
+				for _, userSession := range userSessions {
+					scanned[userSession.ID] = true
+				}
+
+				batches++
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+
+			if len(scanned) != tt.expectedSessions || batches != tt.expectedBatches || scanned[expiredUserSession.ID] {
+				t.Errorf("test #%d failed; dialect: %d, input count: %d, expected sessions: %d in %d batches, received: %d in %d batches", idx+1, dialect, tt.inputCount, tt.expectedSessions, tt.expectedBatches, len(scanned), batches)
+			}
+		}
+		cleanup()
+	}
+}
+
+// TestCreateSchemaAddColumns tests that CreateSchema adds the columns missing from a table created by an earlier \
+// version of the store, and keeps its sessions
+func TestCreateSchemaAddColumns(t *testing.T) {
+	createdAtUserSession := &user.Session{
+		ID:             "createdAtSessionID",
+		UserID:         "validUserID",
+		JSON:           "createdAtJSON",
+		ExpiresAt:      validUserSession.ExpiresAt,
+		CreatedAt:      time.Unix(time.Now().Unix(), 0),
+		Duration:       30 * 24 * time.Hour,
+		BrowserSession: true,
+	}
+
+	for _, dialect := range dialects {
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatalf("could not open db: %v", err)
+		}
+		db.SetMaxOpenConns(1)
+
+		if _, err := db.Exec("CREATE TABLE sessions (id VARCHAR(64) PRIMARY KEY, user_id VARCHAR(255) NOT NULL, json TEXT NOT NULL, expires_at BIGINT NOT NULL)"); err != nil {
+			t.Fatalf("could not create legacy table: %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO sessions (id, user_id, json, expires_at) VALUES (?, ?, ?, ?)", validUserSession.ID, validUserSession.UserID, validUserSession.JSON, validUserSession.ExpiresAt.Unix()); err != nil {
+			t.Fatalf("could not insert legacy session: %v", err)
+		}
+
+		s, err := New(db, Options{Dialect: dialect})
+		if err != nil {
+			t.Fatalf("could not create store: %v", err)
+		}
+
+		if e := s.CreateSchema(); e != nil {
+			t.Errorf("test failed; dialect: %d, expected err to be nil, received: %v", dialect, e)
+		}
+		// note: once the columns exist, creating the schema again must not try to add them twice
+		if e := s.CreateSchema(); e != nil {
+			t.Errorf("test failed; dialect: %d, expected err to be nil, received: %v", dialect, e)
+		}
+
+		a, e := s.FetchValidUserSession(validUserSession.ID)
+		if e != nil || a == nil || !reflect.DeepEqual(*a, *validUserSession) {
+			t.Errorf("test failed; dialect: %d, expected session: %v, received session: %v, received err: %v", dialect, validUserSession, a, e)
+		}
+
+		s.SaveUserSession(createdAtUserSession)
+		a, e = s.FetchValidUserSession(createdAtUserSession.ID)
+		if e != nil || a == nil || !reflect.DeepEqual(*a, *createdAtUserSession) {
+			t.Errorf("test failed; dialect: %d, expected session: %v, received session: %v, received err: %v", dialect, createdAtUserSession, a, e)
+		}
+
+		s.Close()
+		db.Close()
+	}
+}
@@ -0,0 +1,83 @@
+package sql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// setDefaultOptions sets default values for nil fields
+func setDefaultOptions(options *Options) {
+	emptyOptions := Options{}
+	if options.TableName == emptyOptions.TableName {
+		options.TableName = DefaultTableName
+	}
+	if options.CleanupIntervalDuration <= emptyOptions.CleanupIntervalDuration {
+		options.CleanupIntervalDuration = DefaultCleanupIntervalDuration
+	}
+
+	return
+}
+
+// buildQueries returns the sql statements of the store for a dialect and table name.
+// note: the table name must be validated before calling this function!
+func buildQueries(dialect Dialect, tableName string) queries {
+	return queries{
+		createSchema: []string{
+			"CREATE TABLE IF NOT EXISTS " + tableName + " (" +
+				"id VARCHAR(64) PRIMARY KEY, " +
+				"user_id VARCHAR(255) NOT NULL, " +
+				"json TEXT NOT NULL, " +
+				"expires_at BIGINT NOT NULL, " +
+				"created_at BIGINT NOT NULL DEFAULT 0, " +
+				"duration_seconds BIGINT NOT NULL DEFAULT 0, " +
+				"browser_session BOOLEAN NOT NULL DEFAULT FALSE)",
+			"CREATE INDEX IF NOT EXISTS " + tableName + "_user_id_idx ON " + tableName + " (user_id)",
+			"CREATE INDEX IF NOT EXISTS " + tableName + "_expires_at_idx ON " + tableName + " (expires_at)",
+		},
+		// note: tables created by earlier versions of the store lack the columns added since
+		addColumns: []addColumn{
+			addColumnQueries(tableName, "created_at", "BIGINT NOT NULL DEFAULT 0"),
+			addColumnQueries(tableName, "duration_seconds", "BIGINT NOT NULL DEFAULT 0"),
+			addColumnQueries(tableName, "browser_session", "BOOLEAN NOT NULL DEFAULT FALSE"),
+		},
+		// note: both sqlite (>= 3.24) and postgres (>= 9.5) support upserts with ON CONFLICT
+		save: rebind(dialect, "INSERT INTO "+tableName+" (id, user_id, json, expires_at, created_at, duration_seconds, browser_session) VALUES (?, ?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, json = excluded.json, expires_at = excluded.expires_at, "+
+			"created_at = excluded.created_at, duration_seconds = excluded.duration_seconds, browser_session = excluded.browser_session"),
+		delete:        rebind(dialect, "DELETE FROM "+tableName+" WHERE id = ?"),
+		fetch:         rebind(dialect, "SELECT user_id, json, expires_at, "+sessionColumns+" FROM "+tableName+" WHERE id = ? AND expires_at > ?"),
+		deleteExpired: rebind(dialect, "DELETE FROM "+tableName+" WHERE expires_at <= ?"),
+		listUser:      rebind(dialect, "SELECT id, json, expires_at, "+sessionColumns+" FROM "+tableName+" WHERE user_id = ? AND expires_at > ?"),
+		deleteUser:    rebind(dialect, "DELETE FROM "+tableName+" WHERE user_id = ?"),
+		scan:          rebind(dialect, "SELECT id, user_id, json, expires_at, "+sessionColumns+" FROM "+tableName+" WHERE id > ? AND expires_at > ? ORDER BY id LIMIT ?"),
+	}
+}
+
+// addColumnQueries returns the statements that check whether a column is missing from the table, and add it
+func addColumnQueries(tableName string, column string, definition string) addColumn {
+	return addColumn{
+		check: "SELECT " + column + " FROM " + tableName + " WHERE 1 = 0",
+		add:   "ALTER TABLE " + tableName + " ADD COLUMN " + column + " " + definition,
+	}
+}
+
+// rebind replaces the "?" placeholders of a query with the placeholders of the dialect
+func rebind(dialect Dialect, query string) string {
+	if dialect != DialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,70 @@
+// +build unit
+
+package sql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSetDefaultOptions tests the setDefaultOptions function
+func TestSetDefaultOptions(t *testing.T) {
+	var tests = []struct {
+		input    Options
+		expected Options
+	}{
+		{Options{}, Options{TableName: DefaultTableName, CleanupIntervalDuration: DefaultCleanupIntervalDuration}},
+		{Options{Dialect: DialectPostgres, TableName: "test", CleanupIntervalDuration: 1 * time.Second}, Options{Dialect: DialectPostgres, TableName: "test", CleanupIntervalDuration: 1 * time.Second}},
+	}
+
+	for idx, tt := range tests {
+		setDefaultOptions(&tt.input)
+		assert := reflect.DeepEqual(tt.expected, tt.input)
+
+		if !assert {
+			t.Errorf("test #%d failed; assert: %t, expected: %v, received: %v\n", idx+1, assert, tt.expected, tt.input)
+		}
+	}
+}
+
+// TestRebind tests the rebind function
+func TestRebind(t *testing.T) {
+	var tests = []struct {
+		dialect  Dialect
+		input    string
+		expected string
+	}{
+		{DialectSQLite, "SELECT a FROM t WHERE b = ? AND c > ?", "SELECT a FROM t WHERE b = ? AND c > ?"},
+		{DialectPostgres, "SELECT a FROM t WHERE b = ? AND c > ?", "SELECT a FROM t WHERE b = $1 AND c > $2"},
+		{DialectPostgres, "DELETE FROM t", "DELETE FROM t"},
+	}
+
+	for idx, tt := range tests {
+		a := rebind(tt.dialect, tt.input)
+
+		if a != tt.expected {
+			t.Errorf("test #%d failed; dialect: %v, input: %s, expected: %s, received: %s\n", idx+1, tt.dialect, tt.input, tt.expected, a)
+		}
+	}
+}
+
+// TestBuildQueries tests the buildQueries function
+func TestBuildQueries(t *testing.T) {
+	var tests = []struct {
+		dialect       Dialect
+		tableName     string
+		expectedFetch string
+	}{
+		{DialectSQLite, "sessions", "SELECT user_id, json, expires_at, created_at, duration_seconds, browser_session FROM sessions WHERE id = ? AND expires_at > ?"},
+		{DialectPostgres, "user_sessions", "SELECT user_id, json, expires_at, created_at, duration_seconds, browser_session FROM user_sessions WHERE id = $1 AND expires_at > $2"},
+	}
+
+	for idx, tt := range tests {
+		a := buildQueries(tt.dialect, tt.tableName)
+
+		if a.fetch != tt.expectedFetch || len(a.createSchema) != 3 || len(a.addColumns) != 3 {
+			t.Errorf("test #%d failed; dialect: %v, table name: %s, expected fetch: %s, received fetch: %s\n", idx+1, tt.dialect, tt.tableName, tt.expectedFetch, a.fetch)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"time"
@@ -43,7 +44,8 @@ func New(options Options) *Service {
 	}
 }
 
-// SetSessionOnResponse sets a signed session id and a user session on a responseWriter
+// SetSessionOnResponse sets a signed session id and a user session on a responseWriter. The cookie expires with the \
+// session, unless the session is a browser session, whose cookie has no expiry
 func (s *Service) SetSessionOnResponse(signedSessionID string, userSession *user.Session, w http.ResponseWriter) error {
 	sessionCookie := http.Cookie{
 		Name:     s.options.CookieName,
@@ -53,11 +55,24 @@ func (s *Service) SetSessionOnResponse(signedSessionID string, userSession *user
 		HttpOnly: s.options.HTTPOnly,
 		Secure:   s.options.Secure,
 	}
+	if userSession.BrowserSession {
+		sessionCookie.Expires = time.Time{}
+	}
 	http.SetCookie(w, &sessionCookie)
 
 	return nil
 }
 
+// SetSessionOnResponseContext is like SetSessionOnResponse, but returns ctx's error, without setting the cookie, if \
+// ctx is already done
+func (s *Service) SetSessionOnResponseContext(ctx context.Context, signedSessionID string, userSession *user.Session, w http.ResponseWriter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.SetSessionOnResponse(signedSessionID, userSession, w)
+}
+
 // DeleteSessionFromResponse deletes a user session from a responseWriter
 func (s *Service) DeleteSessionFromResponse(w http.ResponseWriter) error {
 	aLongTimeAgo := time.Now().Add(-1000 * time.Hour)
@@ -74,6 +89,16 @@ func (s *Service) DeleteSessionFromResponse(w http.ResponseWriter) error {
 	return nil
 }
 
+// DeleteSessionFromResponseContext is like DeleteSessionFromResponse, but returns ctx's error, without deleting the \
+// cookie, if ctx is already done
+func (s *Service) DeleteSessionFromResponseContext(ctx context.Context, w http.ResponseWriter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.DeleteSessionFromResponse(w)
+}
+
 // FetchSessionIDFromRequest retrieves a signed session id from a request
 func (s *Service) FetchSessionIDFromRequest(r *http.Request) (string, error) {
 	sessionCookie, err := r.Cookie(s.options.CookieName)
@@ -86,3 +111,12 @@ func (s *Service) FetchSessionIDFromRequest(r *http.Request) (string, error) {
 
 	return sessionCookie.Value, nil
 }
+
+// FetchSessionIDFromRequestContext is like FetchSessionIDFromRequest, but returns ctx's error if ctx is already done
+func (s *Service) FetchSessionIDFromRequestContext(ctx context.Context, r *http.Request) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return s.FetchSessionIDFromRequest(r)
+}
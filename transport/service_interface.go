@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/adam-hanna/sessions/user"
@@ -12,3 +13,11 @@ type ServiceInterface interface {
 	DeleteSessionFromResponse(w http.ResponseWriter) error
 	FetchSessionIDFromRequest(r *http.Request) (string, error)
 }
+
+// ContextServiceInterface is optionally implemented by transport services whose operations can be cancelled. When \
+// implemented, the session service passes the request's context to the transport service.
+type ContextServiceInterface interface {
+	SetSessionOnResponseContext(ctx context.Context, signedSessionID string, userSession *user.Session, w http.ResponseWriter) error
+	DeleteSessionFromResponseContext(ctx context.Context, w http.ResponseWriter) error
+	FetchSessionIDFromRequestContext(ctx context.Context, r *http.Request) (string, error)
+}
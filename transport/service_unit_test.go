@@ -3,14 +3,22 @@
 package transport
 
 import (
+	"context"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/adam-hanna/sessions/user"
 )
 
+// note: this will fail to compile if the transport service does not implement the transport interfaces
+var (
+	_ ServiceInterface        = (*Service)(nil)
+	_ ContextServiceInterface = (*Service)(nil)
+)
+
 var (
 	testOptions = Options{CookieName: "test", CookiePath: "/", HTTPOnly: true, Secure: true}
 	testService = Service{options: testOptions}
@@ -95,6 +103,30 @@ func TestSetSessionOnResponse(t *testing.T) {
 	}
 }
 
+// TestBrowserSessionCookie tests that the cookie of a browser session has no expiry, and that of other sessions does
+func TestBrowserSessionCookie(t *testing.T) {
+	var tests = []struct {
+		browserSession  bool
+		expectedExpires bool
+	}{
+		{false, true},
+		{true, false},
+	}
+
+	for idx, tt := range tests {
+		u := user.New("testID", "", 1*time.Hour)
+		u.BrowserSession = tt.browserSession
+		w := FakeResponse{make(http.Header), nil, 0}
+
+		_ = testService.SetSessionOnResponse("testSignedSessionID", u, w)
+
+		cookie := w.Header().Get("Set-Cookie")
+		if strings.Contains(cookie, "Expires=") != tt.expectedExpires {
+			t.Errorf("test #%d failed; expected expires: %t, received cookie: %s", idx+1, tt.expectedExpires, cookie)
+		}
+	}
+}
+
 // TestDeleteSessionFromResponse tests the DeleteSessionFromResponse function
 func TestDeleteSessionFromResponse(t *testing.T) {
 	m := make(map[string][]string, 1)
@@ -152,3 +184,35 @@ func TestFetchSessionIDFromRequest(t *testing.T) {
 		}
 	}
 }
+
+// TestContext tests that the context variants write and read cookies like their plain counterparts, unless ctx is \
+// done
+func TestContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var tests = []struct {
+		ctx             context.Context
+		expectedCookies int
+		expectedString  string
+		expectedErr     error
+	}{
+		{context.Background(), 2, "testValue", nil},
+		{cancelled, 0, "", context.Canceled},
+	}
+
+	for idx, tt := range tests {
+		w := FakeResponse{make(map[string][]string, 1), nil, 0}
+		setErr := testService.SetSessionOnResponseContext(tt.ctx, "testSignedSessionID", user.New("testID", "", 1*time.Second), w)
+		deleteErr := testService.DeleteSessionFromResponseContext(tt.ctx, w)
+
+		r := http.Request{Header: make(map[string][]string, 1)}
+		r.AddCookie(&http.Cookie{Name: testService.options.CookieName, Value: "testValue"})
+		s, fetchErr := testService.FetchSessionIDFromRequestContext(tt.ctx, &r)
+
+		cookies := len(w.Header()["Set-Cookie"])
+		if cookies != tt.expectedCookies || s != tt.expectedString || setErr != tt.expectedErr || deleteErr != tt.expectedErr || fetchErr != tt.expectedErr {
+			t.Errorf("test #%d failed; expected cookies: %d, string: %s, err: %v, received cookies: %d, string: %s, errs: %v, %v, %v", idx+1, tt.expectedCookies, tt.expectedString, tt.expectedErr, cookies, s, setErr, deleteErr, fetchErr)
+		}
+	}
+}
@@ -8,18 +8,73 @@ import (
 
 // Session is a user's session struct
 type Session struct {
-	ID        string
-	UserID    string
+	ID     string
+	UserID string
+	// TenantID is the tenant the session belongs to. Stores that support tenants, like the redis store, fold it \
+	// into the session's key
+	TenantID  string
 	ExpiresAt time.Time
-	JSON      string
+	// CreatedAt is the time the session was issued. It bounds the session's absolute lifetime, however often it is \
+	// extended. It is the zero time for sessions saved before it was recorded
+	CreatedAt time.Time
+	// Duration, if set, is the session's own idle timeout, e.g. 30 days for a "remember me" session, which it is \
+	// extended by instead of the session service's
+	Duration time.Duration
+	// BrowserSession, if set, makes the transport write the session's cookie without an expiry, so the browser \
+	// discards it when it closes
+	BrowserSession bool
+	JSON           string
+	// Version is incremented by stores that support versioning every time the session is saved. It lets a save \
+	// detect that the session was saved by a concurrent request since it was fetched
+	Version int64
+	// Data holds named values of the session, e.g. one per module of an app, next to the opaque JSON. Stores that \
+	// support fields, like the redis store, persist each value separately, so it can be set or deleted without \
+	// rewriting the others. Use Get, Set and Delete to access it
+	Data map[string]string
 }
 
 // New returns a new user Session
 func New(userID string, json string, duration time.Duration) *Session {
+	createdAt := time.Now().UTC()
+
 	return &Session{
 		ID:        uuid.New().String(),
 		UserID:    userID,
-		ExpiresAt: time.Now().Add(duration).UTC(),
+		ExpiresAt: createdAt.Add(duration),
+		CreatedAt: createdAt,
 		JSON:      json,
 	}
 }
+
+// Get returns the value of a named field of the session's Data, and whether it is set
+func (s *Session) Get(name string) (string, bool) {
+	value, ok := s.Data[name]
+	return value, ok
+}
+
+// Set sets a named field of the session's Data. The session must be saved, or the field set in the store, for the \
+// change to persist
+func (s *Session) Set(name string, value string) {
+	if s.Data == nil {
+		s.Data = make(map[string]string)
+	}
+	s.Data[name] = value
+}
+
+// Delete removes a named field from the session's Data
+func (s *Session) Delete(name string) {
+	delete(s.Data, name)
+}
+
+// Copy returns a copy of the session that doesn't share its Data with the session
+func (s *Session) Copy() *Session {
+	c := *s
+	if s.Data != nil {
+		c.Data = make(map[string]string, len(s.Data))
+		for name, value := range s.Data {
+			c.Data[name] = value
+		}
+	}
+
+	return &c
+}
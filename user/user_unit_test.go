@@ -35,7 +35,7 @@ func TestNew(t *testing.T) {
 			a = &Session{}
 		}
 
-		if a.UserID != tt.inputUserID || a.JSON != tt.inputJSON || !testSessionID(a.ID) || !testExpiresAt(tt.inputDuration, a.ExpiresAt) {
+		if a.UserID != tt.inputUserID || a.JSON != tt.inputJSON || !testSessionID(a.ID) || !testExpiresAt(tt.inputDuration, a.ExpiresAt) || a.ExpiresAt.Sub(a.CreatedAt) != tt.inputDuration {
 			t.Errorf("test #%d failed; inputUserID: %s, inputJSON: %s, inputDuration: %v, expected session: %v, received session: %v", idx+1, tt.inputUserID, tt.inputJSON, tt.inputDuration, tt.expected, *a)
 		}
 	}
@@ -53,3 +53,51 @@ func testSessionID(sessionID string) bool {
 		len(parts) == 5 && len([]rune(parts[0])) == 8 && len([]rune(parts[1])) == 4 && len([]rune(parts[2])) == 4 &&
 		len([]rune(parts[3])) == 4 && len([]rune(parts[4])) == 12
 }
+
+// TestData tests the Get, Set and Delete funcs
+func TestData(t *testing.T) {
+	s := &Session{}
+
+	var tests = []struct {
+		set           map[string]string
+		delete        []string
+		input         string
+		expectedValue string
+		expectedOk    bool
+	}{
+		{nil, nil, "cart", "", false},
+		{map[string]string{"cart": "1", "theme": "dark"}, nil, "cart", "1", true},
+		{map[string]string{"cart": "2"}, nil, "cart", "2", true},
+		{nil, []string{"cart"}, "cart", "", false},
+		{nil, []string{"missing"}, "theme", "dark", true},
+	}
+
+	for idx, tt := range tests {
+		for name, value := range tt.set {
+			s.Set(name, value)
+		}
+		for _, name := range tt.delete {
+			s.Delete(name)
+		}
+
+		a, ok := s.Get(tt.input)
+		if a != tt.expectedValue || ok != tt.expectedOk {
+			t.Errorf("test #%d failed; input: %s, expected value: %s, expected ok: %t, received value: %s, received ok: %t", idx+1, tt.input, tt.expectedValue, tt.expectedOk, a, ok)
+		}
+	}
+}
+
+// TestCopy tests that copies don't share their Data
+func TestCopy(t *testing.T) {
+	s := &Session{ID: "testID"}
+	if a := s.Copy(); a.ID != s.ID || a.Data != nil {
+		t.Errorf("test failed; expected copy: %v, received: %v", s, a)
+	}
+
+	s.Set("cart", "1")
+	a := s.Copy()
+	a.Set("cart", "2")
+	if value, _ := s.Get("cart"); value != "1" || a.ID != s.ID {
+		t.Errorf("test failed; expected the copy not to share data, received value: %s, received copy: %v", value, a)
+	}
+}